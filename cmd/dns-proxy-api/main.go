@@ -1,73 +1,588 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"context"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
+
+	"dns-proxy/internal/acmedns"
+	"dns-proxy/internal/api"
+	"dns-proxy/internal/audit"
+	"dns-proxy/internal/auth"
+	"dns-proxy/internal/config"
+	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/dnsclient"
+	"dns-proxy/internal/health"
+	"dns-proxy/internal/httperror"
+	"dns-proxy/internal/jobs"
+	"dns-proxy/internal/leases"
+	"dns-proxy/internal/listeners"
+	"dns-proxy/internal/notify"
+	"dns-proxy/internal/ratelimit"
+	"dns-proxy/internal/rfc2136"
+	"dns-proxy/internal/tlsconfig"
+	"dns-proxy/internal/validate"
 )
 
-func loadAPIKey(path string) string {
-	file, err := os.Open(path)
+const configPath = "/etc/dns-proxy-api.conf"
+
+// defaultShutdownTimeout is how long requests in flight may take to finish on SIGTERM
+const defaultShutdownTimeout = 30 * time.Second
+
+// defaultListen is used when neither the config nor the flags name a listener
+const defaultListen = ":5000"
+
+// listenFlags collects repeated --listen flags
+type listenFlags []string
+
+func (f *listenFlags) String() string     { return strings.Join(*f, ",") }
+func (f *listenFlags) Set(v string) error { *f = append(*f, v); return nil }
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		runTokenCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "notify" {
+		runNotifyCommand(os.Args[2:])
+		return
+	}
+
+	var listen listenFlags
+	flag.Var(&listen, "listen", "listener spec, may be repeated: [tls://]host:port, unix:<path> or [tls+]systemd[:name] (overrides listen= in the config)")
+	flag.Parse()
+
+	cfg := config.LoadConfig(configPath)
+
+	keyring, err := auth.LoadTokens(cfg)
 	if err != nil {
-		log.Fatalf("Failed to open config file: %v", err)
-	}
-	defer file.Close()
-	var apiKey string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
+		log.Fatalf("Invalid token configuration: %v", err)
+	}
+	if keyring.Len() == 0 {
+		log.Fatal("No API_KEY or token.<id> found in config file")
+	}
+
+	cpCfg, err := cpanel.NewCPanelConfig(cfg)
+	if err != nil {
+		log.Fatalf("Invalid cPanel configuration: %v", err)
+	}
+	apiMetrics := api.NewMetrics()
+	cpCfg.Observer = apiMetrics
+	var recorders cpanel.ChangeRecorders
+	notifier, err := notify.Load(cfg)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if path := cfg["audit_log"]; path != "" {
+		auditLog, err := audit.Open(path)
+		if err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		defer auditLog.Close()
+		// Changes outside a request, made by the lease janitor, are
+		// attributed to the daemon
+		auditLog.DefaultActor = audit.Actor{Identity: "dns-proxy-api"}
+		recorders = append(recorders, auditLog)
+	}
+	var leaseStore *leases.Store
+	if path := cfg["lease_file"]; path != "" {
+		if leaseStore, err = leases.Open(path); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		recorders = append(recorders, leaseStore)
+	}
+	if notifier != nil {
+		notifier.DefaultActor = audit.Actor{Identity: "dns-proxy-api"}
+		recorders = append(recorders, notifier)
+		notifier.Start()
+		log.Println("Notifications enabled")
+	}
+	if len(recorders) > 0 {
+		cpCfg.Changes = recorders
+	}
+
+	// Servers and queues whose changes in progress must finish before the
+	// process exits
+	var drainers []interface{ Drain() }
+
+	var queue *jobs.Queue
+	if cfg["jobs_file"] != "" {
+		if queue, err = openJobQueue(cfg, cpCfg); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
 		}
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) == 2 && strings.TrimSpace(parts[0]) == "API_KEY" {
-			apiKey = strings.TrimSpace(parts[1])
+		queue.Start()
+		drainers = append(drainers, queue)
+		log.Println("Async jobs enabled")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", api.NewHandler(keyring, cpCfg, queue, leaseStore))
+
+	readyInterval := health.DefaultInterval
+	if v := cfg["readyz_interval"]; v != "" {
+		if readyInterval, err = time.ParseDuration(v); err != nil || readyInterval <= 0 {
+			log.Fatalf("Invalid configuration: readyz_interval must be a duration such as 1m")
 		}
 	}
-	if apiKey == "" {
-		log.Fatal("API_KEY not found in config file")
+	// /readyz needs no authentication, so the account is listed under a
+	// fixed label rather than its cPanel user name
+	checker := health.NewChecker(map[string]health.Pinger{"cpanel": cpCfg}, readyInterval)
+	if notifier != nil {
+		checker.OnChange = notifier.HealthChanged
+	}
+	mux.Handle("GET /healthz", api.HealthzHandler())
+	mux.Handle("GET /readyz", api.ReadyzHandler(checker))
+
+	var metricsSrv *http.Server
+	if addr := cfg["metrics_listen"]; addr != "" {
+		metricsSrv = serveMetrics(addr, apiMetrics)
+	} else {
+		mux.Handle("GET /metrics", apiMetrics.Handler(keyring))
+	}
+
+	if cfg["acmedns_zone"] != "" {
+		drainers = append(drainers, mountACMEDNS(mux, cfg, keyring, cpCfg))
+	}
+
+	if user, pass := cfg["httpreq_username"], cfg["httpreq_password"]; user != "" && pass != "" {
+		scope, err := httpreqScope(cfg)
+		if err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		// lego's httpreq provider only knows basic auth
+		mux.Handle("POST /present", api.RequireBasicAuth(user, pass, api.PresentHandler(cpCfg, scope)))
+		mux.Handle("POST /cleanup", api.RequireBasicAuth(user, pass, api.CleanupHandler(cpCfg, scope)))
+		log.Println("httpreq API enabled")
+	}
+
+	if cfg["rfc2136_listen"] != "" {
+		drainers = append(drainers, startRFC2136(cfg, cpCfg))
+	}
+
+	ipLimiter, err := rateLimiter(cfg, "ratelimit_ip", defaultIPRate, defaultIPBurst)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	tokenLimiter, err := rateLimiter(cfg, "ratelimit_token", defaultTokenRate, defaultTokenBurst)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	// Outermost first: request ID, metrics, body limit, per-IP limit,
+	// authentication, per-token limit, audit actor and route tag
+	handler := api.WrapMux(mux)
+	if tokenLimiter != nil {
+		handler = api.RateLimit(tokenLimiter, api.ByToken, handler)
+	}
+	handler = api.BearerAuth(keyring, handler)
+	handler, err = signatureMiddleware(cfg, keyring, handler)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	handler = api.ClientCertAuth(keyring, handler)
+	if ipLimiter != nil {
+		handler = api.RateLimit(ipLimiter, api.ByClientIP, handler)
+	}
+
+	maxBody := int64(api.DefaultMaxBodyBytes)
+	if v := cfg["max_body_bytes"]; v != "" {
+		if maxBody, err = strconv.ParseInt(v, 10, 64); err != nil || maxBody <= 0 {
+			log.Fatalf("Invalid configuration: max_body_bytes must be a positive number of bytes")
+		}
+	}
+	handler = api.LimitBody(maxBody, handler)
+	handler = apiMetrics.Instrument(handler)
+	handler = httperror.RequestID(handler)
+
+	shutdownTimeout := defaultShutdownTimeout
+	if v := cfg["shutdown_timeout"]; v != "" {
+		if shutdownTimeout, err = time.ParseDuration(v); err != nil || shutdownTimeout <= 0 {
+			log.Fatalf("Invalid configuration: shutdown_timeout must be a duration such as 30s")
+		}
+	}
+
+	specs, err := listenSpecs(cfg, listen)
+	if err != nil {
+		log.Fatalf("Invalid listener configuration: %v", err)
+	}
+
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		// Leaves room for the cPanel calls, bounded by api.CPanelTimeout
+		WriteTimeout: api.CPanelTimeout + 30*time.Second,
+		IdleTimeout:  2 * time.Minute,
+	}
+	if cfg["tls_cert"] != "" {
+		reloader, err := tlsconfig.New(cfg["tls_cert"], cfg["tls_key"], cfg["tls_client_ca"], cfg["tls_client_auth"])
+		if err != nil {
+			log.Fatalf("Invalid TLS configuration: %v", err)
+		}
+		srv.TLSConfig = reloader.Config()
+		go reloadOnSIGHUP(reloader)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	go checker.Run(ctx)
+	if leaseStore != nil {
+		gcInterval := leases.DefaultInterval
+		if v := cfg["lease_gc_interval"]; v != "" {
+			if gcInterval, err = time.ParseDuration(v); err != nil || gcInterval < 0 {
+				log.Fatalf("Invalid configuration: lease_gc_interval must be a duration such as 1m, or 0")
+			}
+		}
+		// With 0, expired records are left for `dns-proxy-cli gc`
+		if gcInterval > 0 {
+			go leases.NewJanitor(leaseStore, cpCfg, gcInterval).Run(ctx)
+		}
+	}
+
+	errc, err := serve(srv, specs, unixSocketOptions(cfg))
+	if err != nil {
+		log.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	log.Printf("Shutting down, waiting up to %s for requests in flight...", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Requests still running after %s, closing connections: %v", shutdownTimeout, err)
+		srv.Close()
+	}
+	if metricsSrv != nil {
+		metricsSrv.Close()
+	}
+	// Closing connections cancels request contexts, but update sequences
+	// run detached from them and are waited for here
+	for _, d := range drainers {
+		d.Drain()
+	}
+	// Last, as the drained changes may still have queued notifications
+	if notifier != nil {
+		notifier.Drain()
 	}
-	return apiKey
+	log.Println("Shutdown complete")
 }
 
-func main() {
-	apiKey := loadAPIKey("/etc/dns-proxy-api.conf")
+// listenSpecs returns the listeners from the --listen flags, else from
+// listen= in the config. Without either, the API listens on :5000, with
+// TLS if a certificate is configured.
+func listenSpecs(cfg map[string]string, flags []string) ([]listeners.Spec, error) {
+	value := cfg["listen"]
+	if len(flags) > 0 {
+		value = strings.Join(flags, ",")
+	}
+	if value == "" {
+		value = defaultListen
+		if cfg["tls_cert"] != "" {
+			value = "tls://" + defaultListen
+		}
+	}
+	specs, err := listeners.ParseSpecs(value)
+	if err != nil {
+		return nil, err
+	}
+	for _, spec := range specs {
+		if spec.TLS && cfg["tls_cert"] == "" {
+			return nil, fmt.Errorf("%s needs tls_cert and tls_key", spec)
+		}
+	}
+	return specs, nil
+}
+
+func unixSocketOptions(cfg map[string]string) listeners.UnixOptions {
+	opts := listeners.UnixOptions{Owner: cfg["unix_socket_owner"], Group: cfg["unix_socket_group"]}
+	if v := cfg["unix_socket_mode"]; v != "" {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || mode > 0777 {
+			log.Fatalf("Invalid configuration: unix_socket_mode must be an octal mode such as 0660")
+		}
+		opts.Mode = os.FileMode(mode)
+	}
+	return opts
+}
+
+// serve opens every listener and serves srv on them in the background.
+// The returned channel receives the error of the first listener that fails.
+func serve(srv *http.Server, specs []listeners.Spec, unixOpts listeners.UnixOptions) (<-chan error, error) {
+	errc := make(chan error, 1)
+	for _, spec := range specs {
+		ls, err := listeners.Open(spec, unixOpts)
+		if err != nil {
+			return nil, fmt.Errorf("listen %s: %w", spec, err)
+		}
+		for _, l := range ls {
+			log.Printf("dns-proxy API listening on %s (%s)...", l.Addr(), spec)
+			go func(l net.Listener, tls bool) {
+				var err error
+				if tls {
+					err = srv.ServeTLS(l, "", "")
+				} else {
+					err = srv.Serve(l)
+				}
+				if !errors.Is(err, http.ErrServerClosed) {
+					select {
+					case errc <- err:
+					default:
+					}
+				}
+			}(l, spec.TLS)
+		}
+	}
+	return errc, nil
+}
+
+// serveMetrics serves /metrics on its own plain HTTP listener, so it can
+// be kept off the public API listeners
+func serveMetrics(addr string, m *api.Metrics) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", m.Registry.Handler())
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Metrics listener: %v", err)
+	}
+	log.Printf("Metrics listening on %s", l.Addr())
+	go func() {
+		if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Metrics listener failed: %v", err)
+		}
+	}()
+	return srv
+}
+
+// reloadOnSIGHUP reloads the TLS certificate and client CA whenever the
+// process receives SIGHUP, e.g. from a certificate renewal hook
+func reloadOnSIGHUP(reloader *tlsconfig.Reloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := reloader.Reload(); err != nil {
+			log.Printf("TLS reload failed, keeping the previous certificate: %v", err)
+			continue
+		}
+		log.Println("TLS certificate reloaded")
+	}
+}
+
+// Default rate limits, in requests per second and bucket size
+const (
+	defaultIPRate     = 2.0
+	defaultIPBurst    = 40
+	defaultTokenRate  = 1.0
+	defaultTokenBurst = 20
+)
+
+// rateLimiter builds a limiter from <prefix>_rate and <prefix>_burst. The
+// rate is a number of requests per second or "<n>/s", "<n>/m" or "<n>/h";
+// a rate of 0 disables the limiter and nil is returned.
+func rateLimiter(cfg map[string]string, prefix string, rate float64, burst int) (*ratelimit.Limiter, error) {
+	if v := cfg[prefix+"_rate"]; v != "" {
+		n, unit, _ := strings.Cut(v, "/")
+		r, err := strconv.ParseFloat(n, 64)
+		if err != nil || r < 0 {
+			return nil, fmt.Errorf("%s_rate: invalid rate %q", prefix, v)
+		}
+		switch unit {
+		case "", "s":
+		case "m":
+			r /= 60
+		case "h":
+			r /= 3600
+		default:
+			return nil, fmt.Errorf("%s_rate: unknown unit %q, expected s, m or h", prefix, unit)
+		}
+		rate = r
+	}
+	if v := cfg[prefix+"_burst"]; v != "" {
+		b, err := strconv.Atoi(v)
+		if err != nil || b < 1 {
+			return nil, fmt.Errorf("%s_burst: must be a positive number", prefix)
+		}
+		burst = b
+	}
+	if rate == 0 {
+		return nil, nil
+	}
+	return ratelimit.New(rate, burst), nil
+}
 
-	http.HandleFunc("/set_txt", func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		expected := "Bearer " + apiKey
-		if authHeader != expected {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+// openJobQueue opens the job queue at jobs_file with the jobs_workers and
+// jobs_max_attempts settings
+func openJobQueue(cfg map[string]string, svc jobs.Service) (*jobs.Queue, error) {
+	queue, err := jobs.Open(cfg["jobs_file"], svc)
+	if err != nil {
+		return nil, err
+	}
+	if v := cfg["jobs_workers"]; v != "" {
+		if queue.Workers, err = strconv.Atoi(v); err != nil || queue.Workers < 1 {
+			return nil, fmt.Errorf("jobs_workers: must be a positive number")
+		}
+	}
+	if v := cfg["jobs_max_attempts"]; v != "" {
+		if queue.MaxAttempts, err = strconv.Atoi(v); err != nil || queue.MaxAttempts < 1 {
+			return nil, fmt.Errorf("jobs_max_attempts: must be a positive number")
 		}
+	}
+	return queue, nil
+}
 
-		var req struct {
-			Domain string `json:"domain"`
-			Key    string `json:"key"`
-			Value  string `json:"value"`
+// signatureMiddleware wraps next so requests signed with a token's
+// signing_key are accepted alongside bearer tokens
+func signatureMiddleware(cfg map[string]string, keyring *auth.Keyring, next http.Handler) (http.Handler, error) {
+	verifier := auth.NewVerifier(keyring)
+	if v := cfg["signature_max_skew"]; v != "" {
+		skew, err := time.ParseDuration(v)
+		if err != nil || skew <= 0 {
+			return nil, fmt.Errorf("signature_max_skew: invalid duration %q", v)
 		}
-		err := json.NewDecoder(r.Body).Decode(&req)
-		if err != nil || req.Domain == "" || req.Key == "" || req.Value == "" {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
+		verifier.MaxSkew = skew
+	}
+	if v := cfg["signature_nonce_cache"]; v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("signature_nonce_cache: invalid size %q", v)
 		}
+		verifier.Nonces = auth.NewNonceCache(size)
+	}
+	return api.VerifySignatures(verifier, cfg["require_signed_requests"] == "true", next), nil
+}
 
-		cmd := exec.Command("/usr/local/bin/dns-proxy-cli", "set-txt", "--domain", req.Domain, "--key", req.Key, "--value", req.Value)
-		output, err := cmd.CombinedOutput()
+// mountACMEDNS adds the acme-dns compatible /register and /update endpoints
+func mountACMEDNS(mux *http.ServeMux, cfg map[string]string, keyring *auth.Keyring, records acmedns.Records) *acmedns.Server {
+	if err := validate.Hostname("acmedns_zone", cfg["acmedns_zone"]); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	storePath := cfg["acmedns_store"]
+	if storePath == "" {
+		storePath = acmedns.DefaultStorePath
+	}
+	store, err := acmedns.OpenStore(storePath)
+	if err != nil {
+		log.Fatalf("Failed to open acme-dns store: %v", err)
+	}
+
+	srv := &acmedns.Server{Zone: cfg["acmedns_zone"], Store: store, Records: records}
+
+	// A registration may write TXT records at any <subdomain>.<acmedns_zone>,
+	// so the token must be allowed to create them under the zone
+	register := api.RequireScope(auth.OpCreate, srv.Zone, "*", srv.RegisterHandler())
+	if cfg["acmedns_open_registration"] != "true" {
+		register = api.RequireToken(keyring, register)
+	}
+	mux.Handle("POST /register", register)
+	mux.Handle("POST /update", srv.UpdateHandler())
+
+	log.Printf("acme-dns API enabled for %s", srv.Zone)
+	return srv
+}
+
+// httpreqScope returns the zones and names the httpreq credentials may
+// change, from httpreq_zones and httpreq_names. Without either they may
+// change any record of the account.
+func httpreqScope(cfg map[string]string) (auth.Scope, error) {
+	var scope auth.Scope
+	for _, field := range []string{"zones", "names"} {
+		k := "httpreq_" + field
+		if cfg[k] == "" {
+			continue
+		}
+		values, err := auth.ParseScopeList(k, field, cfg[k])
 		if err != nil {
-			log.Printf("dns-proxy-cli error: %v, output: %s", err, string(output))
-			http.Error(w, string(output), http.StatusInternalServerError)
-			return
+			return auth.Scope{}, err
 		}
+		if field == "zones" {
+			scope.Zones = values
+		} else {
+			scope.Names = values
+		}
+	}
+	return scope, nil
+}
 
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("TXT record set"))
-	})
+// startRFC2136 starts the DNS UPDATE listener in the background. Keys are
+// configured as rfc2136_key.<name>=<base64 secret>, optionally limited by
+// rfc2136_key.<name>.zones and rfc2136_key.<name>.names.
+func startRFC2136(cfg map[string]string, records rfc2136.Records) *rfc2136.Server {
+	keys := make(map[string]*dnsclient.TSIGKey)
+	scopes := make(map[string]auth.Scope)
+	for k, v := range cfg {
+		name, ok := strings.CutPrefix(k, "rfc2136_key.")
+		if !ok {
+			continue
+		}
+		if base, field, ok := cutKeyScopeField(name); ok && cfg["rfc2136_key."+base] != "" {
+			values, err := auth.ParseScopeList(k, field, v)
+			if err != nil {
+				log.Fatalf("Invalid configuration: %v", err)
+			}
+			base = strings.ToLower(dnsclient.Fqdn(base))
+			scope := scopes[base]
+			if field == "zones" {
+				scope.Zones = values
+			} else {
+				scope.Names = values
+			}
+			scopes[base] = scope
+			continue
+		}
+		secret, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(secret) == 0 {
+			log.Fatalf("Invalid configuration: %s must be a base64 encoded secret", k)
+		}
+		name = strings.ToLower(dnsclient.Fqdn(name))
+		keys[name] = &dnsclient.TSIGKey{Name: name, Algorithm: dnsclient.HmacSHA256, Secret: secret}
+	}
+
+	srv := &rfc2136.Server{
+		Addr:          cfg["rfc2136_listen"],
+		Keys:          keys,
+		Scopes:        scopes,
+		Records:       records,
+		AllowUnsigned: cfg["rfc2136_allow_unsigned"] == "true",
+	}
+	if len(keys) == 0 && !srv.AllowUnsigned {
+		log.Fatal("Invalid configuration: rfc2136_listen requires at least one rfc2136_key.<name>")
+	}
 
-	log.Println("dns-proxy API listening on :5000...")
-	log.Fatal(http.ListenAndServe(":5000", nil))
+	go func() {
+		log.Fatalf("RFC 2136 listener failed: %v", srv.ListenAndServe())
+	}()
+	log.Printf("RFC 2136 update listener on %s (%d TSIG keys)", srv.Addr, len(keys))
+	return srv
+}
+
+// cutKeyScopeField splits "<key name>.zones" and "<key name>.names"
+func cutKeyScopeField(name string) (base, field string, ok bool) {
+	for _, field := range []string{"zones", "names"} {
+		if base, ok := strings.CutSuffix(name, "."+field); ok {
+			return base, field, true
+		}
+	}
+	return "", "", false
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"dns-proxy/internal/audit"
+	"dns-proxy/internal/config"
+	"dns-proxy/internal/notify"
+)
+
+// runNotifyCommand implements `dns-proxy-api notify test`, which sends a
+// test event to every configured sink and reports how each delivery went
+func runNotifyCommand(args []string) {
+	if len(args) != 1 || args[0] != "test" {
+		fmt.Fprintln(os.Stderr, "Usage: dns-proxy-api notify test")
+		os.Exit(2)
+	}
+
+	notifier, err := notify.Load(config.LoadConfig(configPath))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if notifier == nil {
+		fmt.Fprintln(os.Stderr, "Error: no notify.<name>.* sinks configured")
+		os.Exit(1)
+	}
+	notifier.DefaultActor = audit.Actor{Identity: "dns-proxy-api"}
+
+	results := notifier.Test(context.Background())
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failed := false
+	for _, name := range names {
+		if err := results[name]; err != nil {
+			fmt.Printf("%s: %v\n", name, err)
+			failed = true
+		} else {
+			fmt.Printf("%s: ok\n", name)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
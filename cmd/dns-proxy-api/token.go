@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"dns-proxy/internal/auth"
+	"dns-proxy/internal/config"
+)
+
+// runTokenCommand implements `dns-proxy-api token hash`, which prints a
+// configuration entry holding only the salted hash of a token secret
+func runTokenCommand(args []string) {
+	if len(args) < 1 || args[0] != "hash" {
+		fmt.Fprintln(os.Stderr, "Usage: dns-proxy-api token hash [--id <id>] [--expires <YYYY-MM-DD|RFC 3339>] [--stdin]")
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("token hash", flag.ExitOnError)
+	id := fs.String("id", "", "token ID, prints a token.<id> entry instead of API_KEY")
+	expires := fs.String("expires", "", "date or time after which the secret is rejected")
+	stdin := fs.Bool("stdin", false, "hash the secret read from stdin instead of generating one")
+	fs.Parse(args[1:])
+
+	var expiry time.Time
+	if *expires != "" {
+		var err error
+		if expiry, err = auth.ParseExpiry(*expires); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --expires: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var secret string
+	var err error
+	if *stdin {
+		line, readErr := bufio.NewReader(os.Stdin).ReadString('\n')
+		secret = strings.TrimSpace(line)
+		if secret == "" {
+			fmt.Fprintf(os.Stderr, "Error: no secret on stdin: %v\n", readErr)
+			os.Exit(1)
+		}
+	} else if secret, err = auth.GenerateSecret(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The pepper must match the one the server will use
+	var pepper string
+	if _, err := os.Stat(configPath); err == nil {
+		pepper = config.LoadConfig(configPath)["token_pepper"]
+	}
+
+	cred, err := auth.NewCredential(pepper, secret, expiry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	key := "API_KEY"
+	if *id != "" {
+		key = "token." + *id
+	}
+	if !*stdin {
+		fmt.Fprintf(os.Stderr, "Secret (give this to the client, it is not stored): %s\n", secret)
+	}
+	fmt.Printf("%s=%s\n", key, cred)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+
+	"dns-proxy/internal/audit"
+)
+
+// runAuditCommand implements "dns-proxy-cli audit verify [--file <path>]"
+func runAuditCommand(args []string) {
+	if len(args) < 1 || args[0] != "verify" {
+		fmt.Fprintln(os.Stderr, "Usage: dns-proxy-cli audit verify [--file <path>]")
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("audit verify", flag.ExitOnError)
+	file := fs.String("file", "", "Audit log to verify (default: audit_log from "+configPath+")")
+	fs.Parse(args[1:])
+
+	path := *file
+	if path == "" {
+		path = loadCPanelConfig(configPath)["audit_log"]
+	}
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "Error: no --file given and no audit_log in "+configPath)
+		os.Exit(2)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	n, last, err := audit.Verify(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Audit log is NOT intact after %d valid entries: %v\n", n, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Audit log intact: %d entries\n", n)
+	if n > 0 {
+		// Kept somewhere else, this detects entries later cut from the end
+		fmt.Printf("Last hash: %s\n", last)
+	}
+}
+
+// unixIdentity names the local user running the CLI, and the user who ran
+// sudo if it was started through it
+func unixIdentity() string {
+	name := strconv.Itoa(os.Getuid())
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	identity := "unix:" + name
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" && sudoUser != name {
+		identity += " (sudo from " + sudoUser + ")"
+	}
+	return identity
+}
@@ -8,10 +8,15 @@ import (
 	"os"
 	"strings"
 
+	"dns-proxy/internal/audit"
 	"dns-proxy/internal/commands"
 	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/leases"
+	"dns-proxy/internal/notify"
 )
 
+const configPath = "/etc/dns-proxy-cli.conf"
+
 func loadCPanelConfig(path string) map[string]string {
 	cfg := make(map[string]string)
 	file, err := os.Open(path)
@@ -47,14 +52,21 @@ func main() {
 	if len(filteredArgs) < 1 {
 		fmt.Println("Usage: dns-proxy-cli [-i|--ignore-errors] <command> [options]")
 		fmt.Println("Commands:")
-		fmt.Println("  set-txt --domain <domain> --key <key> --value <value>")
-		fmt.Println("  delete-txt --domain <domain> --key <key> --value <value>")
-		fmt.Println("  edit-txt --domain <domain> --key <key> --old-value <old-value> --new-value <new-value>")
-		fmt.Println("  list-txt --domain <domain> [--key <key>]")
+		fmt.Println("  set-txt --domain <domain> --key <key> --value <value> [--expires-in <duration>] [--wait] [--wait-timeout <duration>] [--resolver <host:port>] [--nameserver-port <port>]")
+		fmt.Println("  delete-txt --domain <domain> --key <key> --value <value> [--force] [--wait] [--wait-timeout <duration>] [--resolver <host:port>] [--nameserver-port <port>]")
+		fmt.Println("  edit-txt --domain <domain> --key <key> --old-value <old-value> --new-value <new-value> [--force]")
+		fmt.Println("  list-txt --domain <domain> [--key <key>] [--unicode]")
+		fmt.Println("  check-txt --domain <domain> --key <key> [--recursive] [--resolver <host:port>] [--nameserver-port <port>]")
+		fmt.Println("  gc [--dry-run] [--force]")
+		fmt.Println("  audit verify [--file <path>]")
 		os.Exit(1)
 	}
 
 	subcmd := filteredArgs[0]
+	if subcmd == "audit" {
+		runAuditCommand(filteredArgs[1:])
+		return
+	}
 
 	// Create command factory and get command
 	factory := commands.NewCommandFactory()
@@ -70,8 +82,12 @@ func main() {
 	// Parse arguments based on command
 	args := parseCommandArgs(subcmd, filteredArgs[1:])
 
-	// Validate arguments
-	if err := cmd.ValidateArgs(args); err != nil {
+	// Convert internationalized names to A-labels, then validate arguments
+	err = commands.NormalizeArgs(args)
+	if err == nil {
+		err = cmd.ValidateArgs(args)
+	}
+	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		fmt.Printf("Usage: %s\n", cmd.Usage())
 		if ignoreErrors {
@@ -81,7 +97,7 @@ func main() {
 	}
 
 	// Load cPanel config
-	cfg := loadCPanelConfig("/etc/dns-proxy-cli.conf")
+	cfg := loadCPanelConfig(configPath)
 	cpCfg, err := cpanel.NewCPanelConfig(cfg)
 	if err != nil {
 		log.Printf("%v", err)
@@ -91,8 +107,74 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Execute command
-	if err := cmd.Execute(cpCfg, args); err != nil {
+	var recorders cpanel.ChangeRecorders
+	if path := cfg["audit_log"]; path != "" {
+		auditLog, err := audit.Open(path)
+		if err != nil {
+			// Don't change records that would go unrecorded
+			log.Printf("%v", err)
+			if ignoreErrors {
+				os.Exit(0)
+			}
+			os.Exit(1)
+		}
+		defer auditLog.Close()
+		auditLog.DefaultActor = audit.Actor{Identity: unixIdentity()}
+		recorders = append(recorders, auditLog)
+	}
+	if path := cfg["lease_file"]; path != "" {
+		leaseStore, err := leases.Open(path)
+		if err != nil {
+			log.Printf("%v", err)
+			if ignoreErrors {
+				os.Exit(0)
+			}
+			os.Exit(1)
+		}
+		recorders = append(recorders, leaseStore)
+	}
+	notifier, err := notify.Load(cfg)
+	if err != nil {
+		log.Printf("Invalid configuration: %v", err)
+		if ignoreErrors {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+	if notifier != nil {
+		notifier.DefaultActor = audit.Actor{Identity: unixIdentity()}
+		recorders = append(recorders, notifier)
+		notifier.Start()
+	}
+	if len(recorders) > 0 {
+		cpCfg.Changes = recorders
+	}
+
+	// Fall back to the configured resolver and nameserver port for
+	// propagation checks
+	if args != nil && args["resolver"] == "" {
+		args["resolver"] = cfg["dns_resolver"]
+	}
+	if args != nil && args["nameserver-port"] == "" {
+		if err := commands.ValidatePort("dns_nameserver_port", cfg["dns_nameserver_port"]); err != nil {
+			log.Printf("Invalid configuration: %v", err)
+			if ignoreErrors {
+				os.Exit(0)
+			}
+			os.Exit(1)
+		}
+		args["nameserver-port"] = cfg["dns_nameserver_port"]
+	}
+	if args != nil {
+		args["lease-file"] = cfg["lease_file"]
+	}
+
+	// Execute command, then give the notifications a moment to go out
+	err = cmd.Execute(cpCfg, args)
+	if notifier != nil {
+		notifier.Drain()
+	}
+	if err != nil {
 		log.Printf("%v", err)
 		if ignoreErrors {
 			os.Exit(0)
@@ -110,20 +192,41 @@ func parseCommandArgs(subcmd string, args []string) map[string]string {
 		domain := cmdFlags.String("domain", "", "Domain name")
 		key := cmdFlags.String("key", "", "TXT record key")
 		value := cmdFlags.String("value", "", "TXT record value")
+		wait := cmdFlags.Bool("wait", false, "Wait until all authoritative nameservers serve the change")
+		waitTimeout := cmdFlags.String("wait-timeout", "", "Maximum time to wait for propagation (default 5m)")
+		resolver := cmdFlags.String("resolver", "", "Recursive resolver used to find the nameservers (host:port)")
+		nameserverPort := cmdFlags.String("nameserver-port", "", "Port queried on the authoritative nameservers (default 53)")
+		var expiresIn *string
+		force := new(bool)
+		if subcmd == "set-txt" {
+			expiresIn = cmdFlags.String("expires-in", "", "Delete the record after this duration (e.g. 1h), needs lease_file")
+		} else {
+			force = cmdFlags.Bool("force", false, "Delete the record even if the proxy did not create it")
+		}
 
 		cmdFlags.Parse(args)
 
-		return map[string]string{
-			"domain": *domain,
-			"key":    *key,
-			"value":  *value,
+		parsed := map[string]string{
+			"domain":          *domain,
+			"key":             *key,
+			"value":           *value,
+			"wait":            fmt.Sprint(*wait),
+			"wait-timeout":    *waitTimeout,
+			"resolver":        *resolver,
+			"nameserver-port": *nameserverPort,
+			"force":           fmt.Sprint(*force),
 		}
+		if expiresIn != nil {
+			parsed["expires-in"] = *expiresIn
+		}
+		return parsed
 	case "edit-txt":
 		cmdFlags = flag.NewFlagSet(subcmd, flag.ExitOnError)
 		domain := cmdFlags.String("domain", "", "Domain name")
 		key := cmdFlags.String("key", "", "TXT record key")
 		oldValue := cmdFlags.String("old-value", "", "Current TXT record value")
 		newValue := cmdFlags.String("new-value", "", "New TXT record value")
+		force := cmdFlags.Bool("force", false, "Edit the record even if the proxy did not create it")
 
 		cmdFlags.Parse(args)
 
@@ -132,17 +235,48 @@ func parseCommandArgs(subcmd string, args []string) map[string]string {
 			"key":       *key,
 			"old-value": *oldValue,
 			"new-value": *newValue,
+			"force":     fmt.Sprint(*force),
 		}
 	case "list-txt":
 		cmdFlags = flag.NewFlagSet(subcmd, flag.ExitOnError)
 		domain := cmdFlags.String("domain", "", "Domain name")
 		key := cmdFlags.String("key", "", "TXT record key filter (optional)")
+		unicode := cmdFlags.Bool("unicode", false, "Show internationalized names as Unicode")
+
+		cmdFlags.Parse(args)
+
+		return map[string]string{
+			"domain":  *domain,
+			"key":     *key,
+			"unicode": fmt.Sprint(*unicode),
+		}
+	case "check-txt":
+		cmdFlags = flag.NewFlagSet(subcmd, flag.ExitOnError)
+		domain := cmdFlags.String("domain", "", "Domain name")
+		key := cmdFlags.String("key", "", "TXT record key")
+		recursive := cmdFlags.Bool("recursive", false, "Also query the recursive resolver")
+		resolver := cmdFlags.String("resolver", "", "Recursive resolver (host:port)")
+		nameserverPort := cmdFlags.String("nameserver-port", "", "Port queried on the authoritative nameservers (default 53)")
+
+		cmdFlags.Parse(args)
+
+		return map[string]string{
+			"domain":          *domain,
+			"key":             *key,
+			"recursive":       fmt.Sprint(*recursive),
+			"resolver":        *resolver,
+			"nameserver-port": *nameserverPort,
+		}
+	case "gc":
+		cmdFlags = flag.NewFlagSet(subcmd, flag.ExitOnError)
+		dryRun := cmdFlags.Bool("dry-run", false, "Only list the expired records")
+		force := cmdFlags.Bool("force", false, "Also delete expired records the proxy did not create")
 
 		cmdFlags.Parse(args)
 
 		return map[string]string{
-			"domain": *domain,
-			"key":    *key,
+			"dry-run": fmt.Sprint(*dryRun),
+			"force":   fmt.Sprint(*force),
 		}
 	default:
 		return nil
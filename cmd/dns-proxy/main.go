@@ -1,47 +1,41 @@
 package main
 
 import (
-	"bufio"
 	"encoding/json"
 	"log"
 	"net/http"
-	"os"
 	"os/exec"
 	"strings"
+
+	"dns-proxy/internal/auth"
+	"dns-proxy/internal/config"
+	"dns-proxy/internal/httperror"
 )
 
-func loadAPIKey(path string) string {
-	file, err := os.Open(path)
-	if err != nil {
-		log.Fatalf("Failed to open config file: %v", err)
-	}
-	defer file.Close()
-	var apiKey string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) == 2 && strings.TrimSpace(parts[0]) == "API_KEY" {
-			apiKey = strings.TrimSpace(parts[1])
-		}
-	}
-	if apiKey == "" {
+// loadKeyring reads API_KEY, plaintext or hashed, from the config file.
+// Scoped tokens are not supported by this server.
+func loadKeyring(path string) *auth.Keyring {
+	cfg := config.LoadConfig(path)
+	if cfg["API_KEY"] == "" {
 		log.Fatal("API_KEY not found in config file")
 	}
-	return apiKey
+	keyring, err := auth.LoadTokens(map[string]string{
+		"API_KEY":      cfg["API_KEY"],
+		"token_pepper": cfg["token_pepper"],
+	})
+	if err != nil {
+		log.Fatalf("Invalid API_KEY: %v", err)
+	}
+	return keyring
 }
 
 func main() {
-	apiKey := loadAPIKey("/etc/dns-proxy.conf")
+	keyring := loadKeyring("/etc/dns-proxy.conf")
 
 	http.HandleFunc("/set_txt", func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		expected := "Bearer " + apiKey
-		if authHeader != expected {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, valid := keyring.Authenticate(secret); !ok || !valid {
+			httperror.Write(w, r, httperror.New(http.StatusUnauthorized, httperror.CodeUnauthorized, "missing or invalid credentials"))
 			return
 		}
 
@@ -52,15 +46,16 @@ func main() {
 		}
 		err := json.NewDecoder(r.Body).Decode(&req)
 		if err != nil || req.Domain == "" || req.Key == "" || req.Value == "" {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			httperror.Write(w, r, httperror.New(http.StatusBadRequest, httperror.CodeInvalidRequest, "body must be a JSON object with domain, key and value"))
 			return
 		}
 
 		cmd := exec.Command("/usr/local/bin/dns-proxy-cli", "set-txt", "--domain", req.Domain, "--key", req.Key, "--value", req.Value)
 		output, err := cmd.CombinedOutput()
 		if err != nil {
-			log.Printf("dns-proxy-cli error: %v, output: %s", err, string(output))
-			http.Error(w, string(output), http.StatusInternalServerError)
+			log.Printf("[%s] dns-proxy-cli error: %v, output: %s", httperror.RequestIDFromContext(r.Context()), err, string(output))
+			// The CLI's output may hold cPanel details, it is only logged
+			httperror.Write(w, r, httperror.New(http.StatusInternalServerError, httperror.CodeInternal, "dns-proxy-cli failed"))
 			return
 		}
 
@@ -69,5 +64,5 @@ func main() {
 	})
 
 	log.Println("dns-proxy API listening on :5000...")
-	log.Fatal(http.ListenAndServe(":5000", nil))
+	log.Fatal(http.ListenAndServe(":5000", httperror.RequestID(http.DefaultServeMux)))
 }
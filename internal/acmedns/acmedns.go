@@ -0,0 +1,225 @@
+// Package acmedns implements the acme-dns HTTP API (/register, /update) on
+// top of the cPanel TXT record operations, so that ACME clients with
+// acme-dns support can use cPanel zones without a custom hook.
+package acmedns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"dns-proxy/internal/audit"
+	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/httperror"
+)
+
+// DefaultStorePath is where registrations are kept unless configured otherwise
+const DefaultStorePath = "/var/lib/dns-proxy/acmedns.json"
+
+// cpanelTimeout bounds the cPanel calls made for a single update
+const cpanelTimeout = 30 * time.Second
+
+// keepValues is the number of TXT values kept per subdomain. Like acme-dns
+// we keep two so a wildcard and a base name can be validated together.
+const keepValues = 2
+
+// Records is the subset of cPanel operations used by the acme-dns API
+type Records interface {
+	CreateTxtRecord(ctx context.Context, domain, key, value string) error
+	DeleteTxtRecord(ctx context.Context, domain, key, value string) error
+}
+
+// Server serves the acme-dns API for subdomains of Zone
+type Server struct {
+	Zone    string // cPanel domain the registration subdomains live under
+	Store   *Store
+	Records Records
+
+	updateMu sync.Mutex // serializes the delete-then-add sequence of updates
+}
+
+type registerRequest struct {
+	AllowFrom []string `json:"allowfrom"`
+}
+
+type registerResponse struct {
+	Username   string   `json:"username"`
+	Password   string   `json:"password"`
+	FullDomain string   `json:"fulldomain"`
+	Subdomain  string   `json:"subdomain"`
+	AllowFrom  []string `json:"allowfrom"`
+}
+
+type updateRequest struct {
+	Subdomain string `json:"subdomain"`
+	TXT       string `json:"txt"`
+}
+
+// RegisterHandler serves POST /register
+func (s *Server) RegisterHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req registerRequest
+		// The body is optional
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, r, errMalformed, "malformed_json_payload")
+				return
+			}
+		}
+		for i, cidr := range req.AllowFrom {
+			cidr = strings.TrimSpace(cidr)
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				writeError(w, r, errBadAllowFrom, "invalid_allowfrom_cidr")
+				return
+			}
+			req.AllowFrom[i] = cidr
+		}
+
+		reg, password, err := s.Store.Create(req.AllowFrom)
+		if err != nil {
+			log.Printf("acme-dns register: %v", err)
+			writeError(w, r, httperror.From(err), "failed_to_create_registration")
+			return
+		}
+		log.Printf("acme-dns register: created %s for subdomain %s", reg.Username, reg.Subdomain)
+
+		writeJSON(w, http.StatusCreated, registerResponse{
+			Username:   reg.Username,
+			Password:   password,
+			FullDomain: reg.Subdomain + "." + s.Zone,
+			Subdomain:  reg.Subdomain,
+			AllowFrom:  reg.AllowFrom,
+		})
+	})
+}
+
+// UpdateHandler serves POST /update
+func (s *Server) UpdateHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reg, ok := s.Store.Get(r.Header.Get("X-Api-User"))
+		if !ok || !reg.CheckPassword(r.Header.Get("X-Api-Key")) {
+			writeError(w, r, errForbidden, "forbidden")
+			return
+		}
+		if !reg.Allowed(remoteIP(r)) {
+			writeError(w, r, errForbidden, "forbidden")
+			return
+		}
+
+		var req updateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, errMalformed, "malformed_json_payload")
+			return
+		}
+		if req.Subdomain != reg.Subdomain {
+			writeError(w, r, errForbidden, "forbidden")
+			return
+		}
+		if !validTXT(req.TXT) {
+			writeError(w, r, errBadTXT, "bad_txt")
+			return
+		}
+
+		// The account is only known now that its password was checked
+		actor, _ := audit.ActorFromContext(r.Context())
+		actor.Identity = "acmedns:" + strings.ToLower(reg.Username)
+		if err := s.update(audit.WithActor(r.Context(), actor), reg, req.TXT); err != nil {
+			log.Printf("acme-dns update %s: %v", reg.Subdomain, err)
+			writeError(w, r, httperror.From(err), "failed_to_update_record")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"txt": req.TXT})
+	})
+}
+
+// update publishes value for the registration and removes the oldest value
+// once more than keepValues would be published.
+func (s *Server) update(ctx context.Context, reg Registration, value string) error {
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+
+	// Re-read under the lock, a concurrent update may have changed the values
+	current, _ := s.Store.Get(reg.Username)
+	values := current.Values
+	for _, v := range values {
+		if v == value {
+			return nil
+		}
+	}
+
+	// Once the oldest value is deleted the new one must be added, so the
+	// sequence does not stop when the client goes away or the server shuts down
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cpanelTimeout)
+	defer cancel()
+
+	for len(values) >= keepValues {
+		err := s.Records.DeleteTxtRecord(ctx, s.Zone, reg.Subdomain, values[0])
+		if err != nil && !errors.Is(err, cpanel.ErrRecordNotFound) {
+			return err
+		}
+		values = values[1:]
+		if err := s.Store.SetValues(reg.Username, values); err != nil {
+			return err
+		}
+	}
+
+	if err := s.Records.CreateTxtRecord(ctx, s.Zone, reg.Subdomain, value); err != nil {
+		return err
+	}
+	return s.Store.SetValues(reg.Username, append(values, value))
+}
+
+// Drain waits for a running update to finish and blocks all further
+// updates. It is called on shutdown, after the HTTP server stopped.
+func (s *Server) Drain() {
+	s.updateMu.Lock()
+}
+
+// validTXT checks the value is a base64url encoded SHA-256 digest, the only
+// thing an ACME DNS-01 challenge publishes
+func validTXT(txt string) bool {
+	if len(txt) != 43 {
+		return false
+	}
+	for _, c := range txt {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+var (
+	errMalformed    = httperror.New(http.StatusBadRequest, httperror.CodeInvalidRequest, "malformed JSON payload")
+	errBadAllowFrom = &httperror.Error{Status: http.StatusBadRequest, Code: httperror.CodeInvalidField, Field: "allowfrom", Message: "allowfrom must only hold CIDR ranges"}
+	errForbidden    = httperror.New(http.StatusUnauthorized, httperror.CodeUnauthorized, "forbidden")
+	errBadTXT       = &httperror.Error{Status: http.StatusBadRequest, Code: httperror.CodeInvalidField, Field: "txt", Message: "txt must be a 43 character ACME challenge digest"}
+)
+
+// writeError replies with the shared error body plus the acme-dns style
+// "error": "<code>" field acme-dns clients look for
+func writeError(w http.ResponseWriter, r *http.Request, e *httperror.Error, code string) {
+	body := *e
+	body.LegacyCode = code
+	httperror.Write(w, r, &body)
+}
@@ -0,0 +1,231 @@
+package acmedns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"dns-proxy/internal/cpanel"
+)
+
+// fakeRecords is an in-memory zone keyed by "key value"
+type fakeRecords struct {
+	mu      sync.Mutex
+	records map[string]bool
+	fail    error
+}
+
+func (f *fakeRecords) CreateTxtRecord(ctx context.Context, domain, key, value string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail != nil {
+		return f.fail
+	}
+	if f.records == nil {
+		f.records = make(map[string]bool)
+	}
+	f.records[key+"."+domain+" "+value] = true
+	return nil
+}
+
+func (f *fakeRecords) DeleteTxtRecord(ctx context.Context, domain, key, value string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail != nil {
+		return f.fail
+	}
+	if !f.records[key+"."+domain+" "+value] {
+		return cpanel.ErrRecordNotFound
+	}
+	delete(f.records, key+"."+domain+" "+value)
+	return nil
+}
+
+func (f *fakeRecords) values(fqdn string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var values []string
+	for k := range f.records {
+		if name, value, _ := strings.Cut(k, " "); name == fqdn {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func newTestServer(t *testing.T) (*Server, *fakeRecords, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "acmedns.json")
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := &fakeRecords{}
+	return &Server{Zone: "auth.example.com", Store: store, Records: records}, records, path
+}
+
+func register(t *testing.T, s *Server, body string) registerResponse {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/register", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	s.RegisterHandler().ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register: %d %s", w.Code, w.Body)
+	}
+	var resp registerResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// update posts txt for reg and returns the response
+func update(s *Server, reg registerResponse, subdomain, txt, remoteAddr string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(updateRequest{Subdomain: subdomain, TXT: txt})
+	r := httptest.NewRequest("POST", "/update", strings.NewReader(string(body)))
+	r.RemoteAddr = remoteAddr
+	r.Header.Set("X-Api-User", reg.Username)
+	r.Header.Set("X-Api-Key", reg.Password)
+	w := httptest.NewRecorder()
+	s.UpdateHandler().ServeHTTP(w, r)
+	return w
+}
+
+// digest returns a 43 character challenge value made of c
+func digest(c string) string {
+	return strings.Repeat(c, 43)
+}
+
+func TestRegisterAndUpdate(t *testing.T) {
+	s, records, path := newTestServer(t)
+	reg := register(t, s, "")
+	if reg.FullDomain != reg.Subdomain+".auth.example.com" || len(reg.Password) != 40 || reg.Username == "" {
+		t.Fatalf("registration %+v", reg)
+	}
+	fqdn := reg.Subdomain + ".auth.example.com"
+
+	// Two values are kept, a third replaces the oldest
+	for _, c := range []string{"a", "b", "c"} {
+		if w := update(s, reg, reg.Subdomain, digest(c), "192.0.2.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("update %s: %d %s", c, w.Code, w.Body)
+		}
+	}
+	got := records.values(fqdn)
+	if len(got) != 2 || !contains(got, digest("b")) || !contains(got, digest("c")) {
+		t.Errorf("published %v, want the b and c values", got)
+	}
+	// Repeating a published value changes nothing
+	if w := update(s, reg, reg.Subdomain, digest("c"), "192.0.2.1:1234"); w.Code != http.StatusOK || len(records.values(fqdn)) != 2 {
+		t.Errorf("repeated update: %d, %v", w.Code, records.values(fqdn))
+	}
+
+	// The store survives a restart, without the plaintext password
+	reopened, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, ok := reopened.Get(reg.Username)
+	if !ok || !saved.CheckPassword(reg.Password) || saved.CheckPassword(reg.Password+"x") {
+		t.Fatalf("reopened store: %+v, %v", saved, ok)
+	}
+	if strings.Join(saved.Values, ",") != digest("b")+","+digest("c") {
+		t.Errorf("saved values %v", saved.Values)
+	}
+	if saved.PasswordHash == reg.Password || saved.PasswordHash == "" {
+		t.Error("the password is not stored hashed")
+	}
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func TestUpdateRejects(t *testing.T) {
+	s, records, _ := newTestServer(t)
+	reg := register(t, s, `{"allowfrom": ["192.0.2.0/24"]}`)
+	other := register(t, s, "")
+
+	wrongPassword := reg
+	wrongPassword.Password = "wrong"
+	tests := []struct {
+		name       string
+		reg        registerResponse
+		subdomain  string
+		txt        string
+		remoteAddr string
+		want       int
+		code       string
+	}{
+		{"wrong password", wrongPassword, reg.Subdomain, digest("a"), "192.0.2.1:1", http.StatusUnauthorized, "forbidden"},
+		{"outside allowfrom", reg, reg.Subdomain, digest("a"), "198.51.100.1:1", http.StatusUnauthorized, "forbidden"},
+		{"other subdomain", reg, other.Subdomain, digest("a"), "192.0.2.1:1", http.StatusUnauthorized, "forbidden"},
+		{"short txt", reg, reg.Subdomain, "short", "192.0.2.1:1", http.StatusBadRequest, "bad_txt"},
+		{"invalid txt", reg, reg.Subdomain, strings.Repeat("=", 43), "192.0.2.1:1", http.StatusBadRequest, "bad_txt"},
+	}
+	for _, tt := range tests {
+		w := update(s, tt.reg, tt.subdomain, tt.txt, tt.remoteAddr)
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != tt.want || body.Error != tt.code {
+			t.Errorf("%s: %d %s, want %d with error %q", tt.name, w.Code, w.Body, tt.want, tt.code)
+		}
+	}
+	if len(records.records) != 0 {
+		t.Errorf("rejected updates published %v", records.records)
+	}
+
+	records.fail = &cpanel.Error{Func: "add_zone_record", Kind: cpanel.KindUnavailable, Message: "down"}
+	if w := update(s, reg, reg.Subdomain, digest("a"), "192.0.2.1:1"); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"error":"failed_to_update_record"`) {
+		t.Errorf("update while cPanel fails: %d %s", w.Code, w.Body)
+	}
+	if saved, _ := s.Store.Get(reg.Username); len(saved.Values) != 0 {
+		t.Errorf("failed update recorded %v", saved.Values)
+	}
+}
+
+func TestRegisterRejects(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	for body, code := range map[string]string{
+		`{"allowfrom": ["192.0.2.1"]}`: "invalid_allowfrom_cidr",
+		`{"allowfrom":`:                "malformed_json_payload",
+	} {
+		r := httptest.NewRequest("POST", "/register", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		s.RegisterHandler().ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"error":"`+code+`"`) {
+			t.Errorf("register %s: %d %s, want 400 %s", body, w.Code, w.Body, code)
+		}
+	}
+}
+
+func TestUpdateKeepsGoingAfterDeleteNotFound(t *testing.T) {
+	s, records, _ := newTestServer(t)
+	reg := register(t, s, "")
+	for _, c := range []string{"a", "b"} {
+		update(s, reg, reg.Subdomain, digest(c), "192.0.2.1:1")
+	}
+	// The oldest value was removed by hand in the meantime
+	records.DeleteTxtRecord(context.Background(), s.Zone, reg.Subdomain, digest("a"))
+	if w := update(s, reg, reg.Subdomain, digest("c"), "192.0.2.1:1"); w.Code != http.StatusOK {
+		t.Fatalf("update: %d %s", w.Code, w.Body)
+	}
+	if got := records.values(reg.Subdomain + ".auth.example.com"); len(got) != 2 {
+		t.Errorf("published %v, want b and c", got)
+	}
+	if !errors.Is(records.DeleteTxtRecord(context.Background(), s.Zone, reg.Subdomain, digest("a")), cpanel.ErrRecordNotFound) {
+		t.Error("a is published again")
+	}
+}
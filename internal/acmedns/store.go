@@ -0,0 +1,171 @@
+package acmedns
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"dns-proxy/internal/statefile"
+)
+
+// Registration is an acme-dns account bound to a single subdomain
+type Registration struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"password_hash"` // hex SHA-256 of salt || password
+	Salt         string    `json:"salt"`
+	Subdomain    string    `json:"subdomain"`
+	AllowFrom    []string  `json:"allowfrom"`
+	Values       []string  `json:"values"` // current TXT values, oldest first
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CheckPassword reports whether password matches the stored hash, in constant time
+func (reg *Registration) CheckPassword(password string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashPassword(reg.Salt, password)), []byte(reg.PasswordHash)) == 1
+}
+
+// Allowed reports whether ip may update the registration
+func (reg *Registration) Allowed(ip net.IP) bool {
+	if len(reg.AllowFrom) == 0 {
+		return true
+	}
+	for _, cidr := range reg.AllowFrom {
+		_, network, err := net.ParseCIDR(cidr)
+		if err == nil && ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Store keeps registrations in memory and persists them to a JSON file
+type Store struct {
+	mu   sync.Mutex
+	path string
+	regs map[string]*Registration // by username
+}
+
+// OpenStore loads the registrations saved at path
+func OpenStore(path string) (*Store, error) {
+	var regs []*Registration
+	if err := statefile.Load(path, &regs); err != nil {
+		return nil, err
+	}
+	s := &Store{path: path, regs: make(map[string]*Registration)}
+	for _, reg := range regs {
+		s.regs[reg.Username] = reg
+	}
+	return s, nil
+}
+
+// Create registers a new account and returns it along with its plaintext
+// password, which is not stored.
+func (s *Store) Create(allowFrom []string) (*Registration, string, error) {
+	password, err := randomString(40)
+	if err != nil {
+		return nil, "", err
+	}
+	salt, err := randomString(16)
+	if err != nil {
+		return nil, "", err
+	}
+	username, err := newUUID()
+	if err != nil {
+		return nil, "", err
+	}
+	subdomain, err := newUUID()
+	if err != nil {
+		return nil, "", err
+	}
+	if allowFrom == nil {
+		allowFrom = []string{}
+	}
+
+	reg := &Registration{
+		Username:     username,
+		PasswordHash: hashPassword(salt, password),
+		Salt:         salt,
+		Subdomain:    subdomain,
+		AllowFrom:    allowFrom,
+		Values:       []string{},
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.regs[username] = reg
+	if err := s.saveLocked(); err != nil {
+		delete(s.regs, username)
+		return nil, "", err
+	}
+	return reg, password, nil
+}
+
+// Get returns a copy of the registration for username
+func (s *Store) Get(username string) (Registration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reg, ok := s.regs[username]
+	if !ok {
+		return Registration{}, false
+	}
+	copied := *reg
+	copied.Values = append([]string(nil), reg.Values...)
+	return copied, true
+}
+
+// SetValues records the TXT values currently published for username
+func (s *Store) SetValues(username string, values []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reg, ok := s.regs[username]
+	if !ok {
+		return fmt.Errorf("registration %s not found", username)
+	}
+	reg.Values = values
+	return s.saveLocked()
+}
+
+func (s *Store) saveLocked() error {
+	regs := make([]*Registration, 0, len(s.regs))
+	for _, reg := range s.regs {
+		regs = append(regs, reg)
+	}
+	return statefile.Save(s.path, regs)
+}
+
+func hashPassword(salt, password string) string {
+	sum := sha256.Sum256([]byte(salt + password))
+	return hex.EncodeToString(sum[:])
+}
+
+const passwordAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"
+
+// randomString returns n characters drawn uniformly from passwordAlphabet
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate random data: %w", err)
+	}
+	for i := range buf {
+		buf[i] = passwordAlphabet[int(buf[i])%len(passwordAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate random data: %w", err)
+	}
+	b[6] = (b[6] & 0x0F) | 0x40
+	b[8] = (b[8] & 0x3F) | 0x80
+	h := hex.EncodeToString(b[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
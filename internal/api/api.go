@@ -1,45 +1,177 @@
 package api
 
 import (
-	"encoding/json"
-	"log"
+	"context"
 	"net/http"
+	"time"
+
+	"dns-proxy/internal/auth"
+	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/httperror"
+	"dns-proxy/internal/idn"
+	"dns-proxy/internal/jobs"
+	"dns-proxy/internal/leases"
+	"dns-proxy/internal/validate"
 )
 
+// CPanelTimeout bounds the cPanel calls made for a single request
+const CPanelTimeout = 30 * time.Second
+
 type SetTxtRequest struct {
-	Domain string `json:"domain"`
-	Key    string `json:"key"`
-	Value  string `json:"value"`
+	Domain    string `json:"domain"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	ExpiresIn int64  `json:"expires_in,omitempty"` // seconds, 0 for a permanent record
+}
+
+// Normalize converts the domain and key to A-labels and validates the request
+func (req *SetTxtRequest) Normalize() error {
+	if err := normalizeRecordName("domain", &req.Domain, "key", &req.Key); err != nil {
+		return err
+	}
+	return validate.TXTValue("value", req.Value)
 }
 
+// TxtRecordSetter creates TXT records, typically a *cpanel.CPanelConfig
 type TxtRecordSetter interface {
-	CreateTxtRecord(domain, key, value string) error
+	CreateTxtRecord(ctx context.Context, domain, key, value string) error
 }
 
-func SetTxtHandler(apiKey string, setter TxtRecordSetter) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		expected := "Bearer " + apiKey
-		if authHeader != expected {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+// TxtRecordService is the full set of TXT record operations served by the
+// API, implemented by *cpanel.CPanelConfig
+type TxtRecordService interface {
+	TxtRecordSetter
+	ListTxtRecords(ctx context.Context, domain, keyFilter string) ([]cpanel.TxtRecord, error)
+	EditTxtRecord(ctx context.Context, domain, key, oldValue, newValue string) error
+	DeleteTxtRecord(ctx context.Context, domain, key, value string) error
+}
+
+// NewHandler returns the handler for all API endpoints: the versioned
+// /v1 TXT resource and the legacy /set_txt alias. With a queue, changes
+// can be submitted as jobs and their status is served under /v1/jobs; a
+// nil queue disables async mode. Likewise a nil lease store rejects
+// records with an expiry.
+func NewHandler(keyring *auth.Keyring, svc TxtRecordService, queue *jobs.Queue, leaseStore *leases.Store) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("POST /set_txt", SetTxtHandler(keyring, svc, queue, leaseStore))
+	mux.Handle("GET /v1/zones/{zone}/txt", RequireToken(keyring, ListTxtHandler(svc)))
+	mux.Handle("POST /v1/zones/{zone}/txt", RequireToken(keyring, CreateTxtHandler(svc, queue, leaseStore)))
+	mux.Handle("PUT /v1/zones/{zone}/txt", RequireToken(keyring, UpdateTxtHandler(svc, queue)))
+	mux.Handle("DELETE /v1/zones/{zone}/txt", RequireToken(keyring, DeleteTxtHandler(svc, queue)))
+	if queue != nil {
+		mux.Handle("GET /v1/jobs/{id}", RequireToken(keyring, JobHandler(queue)))
+	}
+	return jsonMuxErrors(mux)
+}
+
+var (
+	errNotFound         = httperror.New(http.StatusNotFound, httperror.CodeNotFound, "no such endpoint")
+	errMethodNotAllowed = httperror.New(http.StatusMethodNotAllowed, httperror.CodeMethodNotAllowed, "method not allowed")
+)
+
+// jsonMuxErrors answers requests that match no pattern of mux with the
+// JSON error body instead of ServeMux's plain text. The mux still serves
+// them, so the Allow header of a 405 and the request's pattern are set as
+// usual.
+func jsonMuxErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern == "" {
+			w = &muxErrorWriter{ResponseWriter: w, r: r}
 		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// muxErrorWriter replaces a 404 or 405 and its body with the JSON error.
+// Other answers, such as ServeMux's redirects, pass through.
+type muxErrorWriter struct {
+	http.ResponseWriter
+	r        *http.Request
+	replaced bool
+}
 
+func (w *muxErrorWriter) WriteHeader(code int) {
+	switch code {
+	case http.StatusNotFound:
+		w.replaced = true
+		httperror.Write(w.ResponseWriter, w.r, errNotFound)
+	case http.StatusMethodNotAllowed:
+		w.replaced = true
+		httperror.Write(w.ResponseWriter, w.r, errMethodNotAllowed)
+	default:
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *muxErrorWriter) Write(b []byte) (int, error) {
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// WrapMux adds the middlewares that run between authentication and the
+// top-level mux: AuditActor, then TagRequest. TagRequest has to call the
+// mux itself, as ServeMux records the matched pattern on the request it
+// is given, which a middleware replacing the request would hide.
+func WrapMux(mux http.Handler) http.Handler {
+	return AuditActor(TagRequest(mux))
+}
+
+// SetTxtHandler serves the legacy POST /set_txt endpoint
+func SetTxtHandler(keyring *auth.Keyring, setter TxtRecordSetter, queue *jobs.Queue, leaseStore *leases.Store) http.Handler {
+	return RequireToken(keyring, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var req SetTxtRequest
-		err := json.NewDecoder(r.Body).Decode(&req)
-		if err != nil || req.Domain == "" || req.Key == "" || req.Value == "" {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		if err := decodeJSON(r, &req); err != nil {
+			writeError(w, r, err)
 			return
 		}
-
-		err = setter.CreateTxtRecord(req.Domain, req.Key, req.Value)
+		if err := req.Normalize(); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		ttl, err := leaseTTL(req.ExpiresIn, leaseStore)
+		if err == nil {
+			err = authorize(r, auth.OpCreate, req.Domain, req.Key)
+		}
 		if err != nil {
-			log.Println("cPanel error:", err)
-			http.Error(w, "Failed to set TXT record", http.StatusInternalServerError)
+			writeError(w, r, err)
+			return
+		}
+		if queue != nil && wantsAsync(r) {
+			submitJob(w, r, queue, jobs.Job{Op: jobs.OpCreate, Zone: req.Domain, Name: req.Key, NewValue: req.Value, ExpiresIn: req.ExpiresIn})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(withTTL(r.Context(), ttl), CPanelTimeout)
+		defer cancel()
+
+		if err := setter.CreateTxtRecord(ctx, req.Domain, req.Key, req.Value); err != nil {
+			writeError(w, r, err)
 			return
 		}
 
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("TXT record set"))
+	}))
+}
+
+// normalizeRecordName converts a domain/key pair to A-labels and validates
+// both as well as the full name they combine to
+func normalizeRecordName(domainField string, domain *string, keyField string, key *string) error {
+	var err error
+	if *domain, err = idn.ToASCII(*domain); err != nil {
+		return &validate.FieldError{Field: domainField, Message: err.Error()}
+	}
+	if *key, err = idn.ToASCII(*key); err != nil {
+		return &validate.FieldError{Field: keyField, Message: err.Error()}
+	}
+	if err := validate.Hostname(domainField, *domain); err != nil {
+		return err
+	}
+	if err := validate.OwnerName(keyField, *key); err != nil {
+		return err
 	}
+	zone, name := cpanel.RecordName(*domain, *key)
+	return validate.FQDN(keyField, name+"."+zone)
 }
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"dns-proxy/internal/auth"
+	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/httperror"
+)
+
+// fakeService keeps TXT records in memory the way cPanel reports them:
+// Name fully qualified with a trailing dot, Key relative to the cPanel zone
+type fakeService struct {
+	mu      sync.Mutex
+	records []cpanel.TxtRecord
+}
+
+func (s *fakeService) CreateTxtRecord(ctx context.Context, domain, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	zone, name := cpanel.RecordName(domain, key)
+	s.records = append(s.records, cpanel.TxtRecord{Line: len(s.records) + 1, Key: name, Value: value, Name: name + "." + zone + "."})
+	return nil
+}
+
+func (s *fakeService) ListTxtRecords(ctx context.Context, domain, keyFilter string) ([]cpanel.TxtRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	zone, _ := cpanel.RecordName(domain, keyFilter)
+	var records []cpanel.TxtRecord
+	for _, r := range s.records {
+		if strings.HasSuffix(r.Name, "."+zone+".") {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+func (s *fakeService) EditTxtRecord(ctx context.Context, domain, key, oldValue, newValue string) error {
+	return nil
+}
+
+func (s *fakeService) DeleteTxtRecord(ctx context.Context, domain, key, value string) error {
+	return nil
+}
+
+func TestUnmatchedRoutesAnswerJSON(t *testing.T) {
+	keyring, err := auth.LoadTokens(map[string]string{"API_KEY": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHandler(keyring, &fakeService{}, nil, nil)
+
+	tests := []struct {
+		method, target string
+		status         int
+		code, allow    string
+	}{
+		{"GET", "/nothing-here", http.StatusNotFound, httperror.CodeNotFound, ""},
+		{"PATCH", "/v1/zones/example.com/txt", http.StatusMethodNotAllowed, httperror.CodeMethodNotAllowed, "DELETE, GET, HEAD, POST, PUT"},
+		{"GET", "/set_txt", http.StatusMethodNotAllowed, httperror.CodeMethodNotAllowed, "POST"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.target, w.Code, tt.status)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s %s: Content-Type %q", tt.method, tt.target, ct)
+		}
+		if allow := w.Header().Get("Allow"); allow != tt.allow {
+			t.Errorf("%s %s: Allow %q, want %q", tt.method, tt.target, allow, tt.allow)
+		}
+		var body httperror.Error
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s %s: body %q: %v", tt.method, tt.target, w.Body, err)
+		}
+		if body.Code != tt.code {
+			t.Errorf("%s %s: code %q, want %q", tt.method, tt.target, body.Code, tt.code)
+		}
+	}
+
+	// A handler's own 404 is left alone
+	r := httptest.NewRequest("DELETE", "/v1/zones/example.com/txt", strings.NewReader(`{"name":"_acme-challenge","value":"v"}`))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	NewHandler(keyring, &missingRecords{}, nil, nil).ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "TXT record not found") {
+		t.Errorf("record not found: %d %s", w.Code, w.Body)
+	}
+}
+
+// missingRecords finds no record to change
+type missingRecords struct {
+	fakeService
+}
+
+func (*missingRecords) DeleteTxtRecord(ctx context.Context, domain, key, value string) error {
+	return cpanel.ErrRecordNotFound
+}
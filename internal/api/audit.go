@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+
+	"dns-proxy/internal/audit"
+	"dns-proxy/internal/auth"
+)
+
+// AuditActor attributes the record changes made while serving a request
+// to its caller: the token, the client certificate and the client
+// address. It must run after the authentication middlewares. Handlers
+// that check their own credentials, such as RequireBasicAuth, replace the
+// identity with the one they verified, see withIdentity.
+func AuditActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := audit.Actor{Identity: ByToken(r), SourceIP: clientIP(r)}
+		if actor.Identity == "" {
+			actor.Identity = "anonymous"
+		}
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			if ids := auth.CertIdentities(r.TLS.PeerCertificates[0]); len(ids) > 0 {
+				actor.ClientCert = ids[0]
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(audit.WithActor(r.Context(), actor)))
+	})
+}
+
+// withIdentity returns r with the audit identity replaced by identity, for
+// callers authenticated after AuditActor ran
+func withIdentity(r *http.Request, identity string) *http.Request {
+	actor, _ := audit.ActorFromContext(r.Context())
+	actor.Identity = identity
+	return r.WithContext(audit.WithActor(r.Context(), actor))
+}
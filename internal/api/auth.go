@@ -0,0 +1,104 @@
+package api
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"dns-proxy/internal/auth"
+	"dns-proxy/internal/httperror"
+)
+
+var errUnauthorized = httperror.New(http.StatusUnauthorized, httperror.CodeUnauthorized, "missing or invalid credentials")
+
+// RequireToken rejects requests that do not carry "Authorization: Bearer <token>"
+// for one of the tokens in keyring. The token is stored in the request
+// context for the scope checks done by the handlers. Requests already
+// authenticated by VerifySignatures are let through.
+func RequireToken(keyring *auth.Keyring, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := auth.TokenFromContext(r.Context()); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			httperror.Write(w, r, errUnauthorized)
+			return
+		}
+		token, ok := keyring.Authenticate(secret)
+		if !ok {
+			httperror.Write(w, r, errUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithToken(r.Context(), token)))
+	})
+}
+
+// authorize checks that the request's token may perform op on the TXT
+// record at name.zone. Requests without a token, such as those
+// authenticated by basic auth, are not restricted.
+func authorize(r *http.Request, op, zone, name string) error {
+	token, ok := auth.TokenFromContext(r.Context())
+	if !ok {
+		return nil
+	}
+	fqdn := zone
+	if name != "" {
+		fqdn = name + "." + zone
+	}
+	if !token.Scope.Allows(op, fqdn, "TXT") {
+		return &auth.ScopeError{TokenID: token.ID, Op: op, Type: "TXT", Name: fqdn}
+	}
+	return nil
+}
+
+// RequireScope rejects requests whose token may not perform op on the TXT
+// record at name.zone, for handlers that do not check the scope themselves.
+// As with authorize, requests without a token are not restricted.
+func RequireScope(op, zone, name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := authorize(r, op, zone, name); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireBasicAuth rejects requests that do not carry the given HTTP basic
+// auth credentials. Changes made by the others are audited as basic:<user>.
+func RequireBasicAuth(username, password string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+		if !ok || !userOK || !passOK {
+			w.Header().Set("WWW-Authenticate", `Basic realm="dns-proxy"`)
+			httperror.Write(w, r, errUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, withIdentity(r, "basic:"+user))
+	})
+}
+
+// ClientCertAuth authenticates requests that present a verified client
+// certificate mapped to a token with token.<id>.client_cert. Requests
+// without one pass through to the other authentication methods.
+func ClientCertAuth(keyring *auth.Keyring, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cert := r.TLS.VerifiedChains[0][0]
+		token, ok := keyring.ClientCertToken(cert)
+		if !ok {
+			log.Printf("%s %s: client certificate %q from %s is not mapped to a token", r.Method, r.URL.Path, cert.Subject, r.RemoteAddr)
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithToken(r.Context(), token)))
+	})
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"dns-proxy/internal/acmedns"
+	"dns-proxy/internal/auth"
+)
+
+func TestACMEDNSRegisterNeedsScope(t *testing.T) {
+	keyring, err := auth.LoadTokens(map[string]string{
+		"API_KEY":                 "admin-secret",
+		"token.acme.zones":        "auth.example.com",
+		"token.acme":              "acme-secret",
+		"token.other":             "other-secret",
+		"token.other.zones":       "other.example.com",
+		"token.lister":            "lister-secret",
+		"token.lister.operations": "list",
+		"token.challenge":         "challenge-secret",
+		"token.challenge.names":   "_acme-challenge.*",
+		"token.challenge.zones":   "auth.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := acmedns.OpenStore(filepath.Join(t.TempDir(), "acmedns.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &acmedns.Server{Zone: "auth.example.com", Store: store}
+	// As mounted by cmd/dns-proxy-api
+	handler := RequireToken(keyring, RequireScope(auth.OpCreate, srv.Zone, "*", srv.RegisterHandler()))
+
+	tests := []struct {
+		token string
+		want  int
+	}{
+		{"admin-secret", http.StatusCreated},
+		{"acme-secret", http.StatusCreated},
+		{"other-secret", http.StatusForbidden},
+		{"lister-secret", http.StatusForbidden},
+		{"challenge-secret", http.StatusForbidden},
+		{"wrong", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/register", nil)
+		r.Header.Set("Authorization", "Bearer "+tt.token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("token %s: %d %s, want %d", tt.token, w.Code, w.Body, tt.want)
+		}
+	}
+}
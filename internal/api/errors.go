@@ -0,0 +1,16 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"dns-proxy/internal/httperror"
+)
+
+// writeError logs err and replies with the JSON error body it maps to.
+// Details of cPanel failures stay in the server log.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	e := httperror.From(err)
+	log.Printf("%s %s [%s]: %d: %v", r.Method, r.URL.Path, httperror.RequestIDFromContext(r.Context()), e.Status, err)
+	httperror.Write(w, r, e)
+}
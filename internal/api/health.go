@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"dns-proxy/internal/health"
+	"dns-proxy/internal/httperror"
+)
+
+// HealthzHandler serves /healthz, which only shows the process is serving
+func HealthzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+}
+
+// accountStatus is the JSON form of a health.Status
+type accountStatus struct {
+	OK        bool       `json:"ok"`
+	Code      string     `json:"code,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+	LatencyMS int64      `json:"latency_ms"`
+	LastOK    *time.Time `json:"last_ok,omitempty"`
+}
+
+// ReadyzHandler serves /readyz from the checker's latest results: 200 if
+// every cPanel account passed its last check, 503 otherwise
+func ReadyzHandler(checker *health.Checker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ready, statuses := checker.Ready()
+		accounts := make(map[string]accountStatus, len(statuses))
+		for _, s := range statuses {
+			as := accountStatus{OK: s.Err == nil && !s.CheckedAt.IsZero(), LatencyMS: s.Latency.Milliseconds()}
+			switch {
+			case s.CheckedAt.IsZero():
+				as.Error = "not checked yet"
+			case s.Err != nil:
+				// Same client-safe codes and messages as the API, details
+				// are logged by the checker
+				e := httperror.From(s.Err)
+				as.Code, as.Error = e.Code, e.Message
+			}
+			if !s.CheckedAt.IsZero() {
+				as.CheckedAt = &s.CheckedAt
+			}
+			if !s.LastOK.IsZero() {
+				as.LastOK = &s.LastOK
+			}
+			accounts[s.Account] = as
+		}
+
+		status, code := "ready", http.StatusOK
+		if !ready {
+			status, code = "not ready", http.StatusServiceUnavailable
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, code, map[string]interface{}{"status": status, "accounts": accounts})
+	})
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+
+	"dns-proxy/internal/auth"
+	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/validate"
+)
+
+// HTTPReqRequest is the body lego's httpreq provider sends to /present and
+// /cleanup. The default mode sends FQDN and Value; RAW mode sends Domain,
+// Token and KeyAuth and leaves the digest computation to the server.
+type HTTPReqRequest struct {
+	FQDN  string `json:"fqdn"`
+	Value string `json:"value"`
+
+	Domain  string `json:"domain"`
+	Token   string `json:"token"`
+	KeyAuth string `json:"keyAuth"`
+}
+
+// Record returns the fully qualified record name and TXT value for the request
+func (req *HTTPReqRequest) Record() (fqdn, value string, err error) {
+	if req.KeyAuth != "" || req.Domain != "" {
+		// RAW mode
+		if req.Domain == "" {
+			return "", "", &validate.FieldError{Field: "domain", Message: "is required"}
+		}
+		if req.KeyAuth == "" {
+			return "", "", &validate.FieldError{Field: "keyAuth", Message: "is required"}
+		}
+		domain := strings.TrimPrefix(strings.TrimSuffix(req.Domain, "."), "*.")
+		return "_acme-challenge." + domain, ChallengeDigest(req.KeyAuth), nil
+	}
+	if req.FQDN == "" {
+		return "", "", &validate.FieldError{Field: "fqdn", Message: "is required"}
+	}
+	return strings.TrimSuffix(req.FQDN, "."), req.Value, nil
+}
+
+// ChallengeDigest returns the DNS-01 TXT value for a key authorization:
+// the unpadded base64url encoding of its SHA-256 digest (RFC 8555, 8.4)
+func ChallengeDigest(keyAuth string) string {
+	sum := sha256.Sum256([]byte(keyAuth))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// PresentHandler serves lego httpreq's POST /present. Records outside scope
+// are refused; the zero Scope allows every record.
+func PresentHandler(svc TxtRecordService, scope auth.Scope) http.Handler {
+	return httpreqHandler(auth.OpCreate, scope, func(ctx context.Context, domain, key, value string) error {
+		return svc.CreateTxtRecord(ctx, domain, key, value)
+	})
+}
+
+// CleanupHandler serves lego httpreq's POST /cleanup. Cleaning up a record
+// that does not exist succeeds.
+func CleanupHandler(svc TxtRecordService, scope auth.Scope) http.Handler {
+	return httpreqHandler(auth.OpDelete, scope, func(ctx context.Context, domain, key, value string) error {
+		err := svc.DeleteTxtRecord(ctx, domain, key, value)
+		if errors.Is(err, cpanel.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	})
+}
+
+func httpreqHandler(op string, scope auth.Scope, apply func(ctx context.Context, domain, key, value string) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req HTTPReqRequest
+		if err := decodeJSON(r, &req); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		fqdn, value, err := req.Record()
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		domain, key := cpanel.SplitFQDN(fqdn)
+		err = normalizeRecordName("fqdn", &domain, "fqdn", &key)
+		if err == nil {
+			err = validate.TXTValue("value", value)
+		}
+		if err == nil && !scope.Allows(op, key+"."+domain, "TXT") {
+			err = &auth.ScopeError{TokenID: "httpreq", Op: op, Type: "TXT", Name: key + "." + domain}
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), CPanelTimeout)
+		defer cancel()
+
+		if err := apply(ctx, domain, key, value); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"fqdn": key + "." + domain + ".", "value": value})
+	})
+}
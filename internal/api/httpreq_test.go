@@ -0,0 +1,114 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dns-proxy/internal/auth"
+	"dns-proxy/internal/cpanel"
+)
+
+func TestChallengeDigest(t *testing.T) {
+	// The value lego computes for this key authorization
+	keyAuth := "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0.9jg46WB3rR_AHD-EBXdN7cBkH1WOu0tA3M9fm21mqTI"
+	if got, want := ChallengeDigest(keyAuth), "LPsIwTo7o8BoG0-vjCyGQGBWSVIPxI-i_X336eUOQZo"; got != want {
+		t.Errorf("ChallengeDigest = %q, want %q", got, want)
+	}
+}
+
+func TestHTTPReqRecord(t *testing.T) {
+	digest := ChallengeDigest("token.thumbprint")
+	tests := []struct {
+		req         HTTPReqRequest
+		fqdn, value string
+		field       string
+	}{
+		{HTTPReqRequest{FQDN: "_acme-challenge.example.com.", Value: "v"}, "_acme-challenge.example.com", "v", ""},
+		{HTTPReqRequest{Domain: "example.com", Token: "token", KeyAuth: "token.thumbprint"}, "_acme-challenge.example.com", digest, ""},
+		// A wildcard is validated at the base name
+		{HTTPReqRequest{Domain: "*.example.com.", KeyAuth: "token.thumbprint"}, "_acme-challenge.example.com", digest, ""},
+		{HTTPReqRequest{Domain: "example.com"}, "", "", "keyAuth"},
+		{HTTPReqRequest{KeyAuth: "token.thumbprint"}, "", "", "domain"},
+		{HTTPReqRequest{Value: "v"}, "", "", "fqdn"},
+	}
+	for _, tt := range tests {
+		fqdn, value, err := tt.req.Record()
+		if tt.field != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.field+" ") {
+				t.Errorf("%+v: err = %v, want one for %s", tt.req, err, tt.field)
+			}
+			continue
+		}
+		if err != nil || fqdn != tt.fqdn || value != tt.value {
+			t.Errorf("%+v: Record() = %q, %q, %v, want %q, %q", tt.req, fqdn, value, err, tt.fqdn, tt.value)
+		}
+	}
+}
+
+func TestPresentRawMode(t *testing.T) {
+	svc := &fakeService{}
+	handler := RequireBasicAuth("lego", "secret", PresentHandler(svc, auth.Scope{}))
+
+	do := func(user, pass, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/present", strings.NewReader(body))
+		r.SetBasicAuth(user, pass)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	if w := do("lego", "wrong", `{"domain":"example.com","keyAuth":"token.thumbprint"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: %d", w.Code)
+	}
+	if len(svc.records) != 0 {
+		t.Fatalf("unauthenticated request created %v", svc.records)
+	}
+
+	w := do("lego", "secret", `{"domain":"bücher.example.com","token":"token","keyAuth":"token.thumbprint"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("present: %d %s", w.Code, w.Body)
+	}
+	digest := ChallengeDigest("token.thumbprint")
+	want := cpanel.TxtRecord{Line: 1, Key: "_acme-challenge.xn--bcher-kva", Value: digest, Name: "_acme-challenge.xn--bcher-kva.example.com."}
+	if len(svc.records) != 1 || svc.records[0] != want {
+		t.Errorf("records %+v, want %+v", svc.records, want)
+	}
+	if !strings.Contains(w.Body.String(), `"value":"`+digest+`"`) {
+		t.Errorf("response %s lacks the digest", w.Body)
+	}
+}
+
+func TestHTTPReqScope(t *testing.T) {
+	svc := &fakeService{}
+	scope := auth.Scope{Zones: []string{"example.com"}, Names: []string{"_acme-challenge.*"}}
+	present := PresentHandler(svc, scope)
+	cleanup := CleanupHandler(svc, scope)
+
+	do := func(h http.Handler, fqdn string) int {
+		body := `{"fqdn":"` + fqdn + `","value":"token"}`
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		return w.Code
+	}
+	tests := []struct {
+		fqdn string
+		want int
+	}{
+		{"_acme-challenge.example.com.", http.StatusOK},
+		{"_acme-challenge.WWW.example.com", http.StatusOK},
+		{"_acme-challenge.example.org.", http.StatusForbidden},
+		{"_dmarc.example.com.", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if got := do(present, tt.fqdn); got != tt.want {
+			t.Errorf("present %s: %d, want %d", tt.fqdn, got, tt.want)
+		}
+		if got := do(cleanup, tt.fqdn); got != tt.want {
+			t.Errorf("cleanup %s: %d, want %d", tt.fqdn, got, tt.want)
+		}
+	}
+	if len(svc.records) != 2 {
+		t.Errorf("created %+v, want only the records in scope", svc.records)
+	}
+}
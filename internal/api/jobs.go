@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"dns-proxy/internal/audit"
+	"dns-proxy/internal/auth"
+	"dns-proxy/internal/httperror"
+	"dns-proxy/internal/jobs"
+)
+
+var errJobNotFound = httperror.New(http.StatusNotFound, httperror.CodeNotFound, "job not found")
+
+// wantsAsync reports whether the client asked for the change to be queued,
+// with "Prefer: respond-async" (RFC 7240) or ?async=true
+func wantsAsync(r *http.Request) bool {
+	if r.URL.Query().Get("async") == "true" {
+		return true
+	}
+	for _, prefer := range r.Header.Values("Prefer") {
+		for _, p := range strings.Split(prefer, ",") {
+			if strings.EqualFold(strings.TrimSpace(p), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// submitJob queues j on behalf of the request's caller and replies 202
+// with the job and its status URL
+func submitJob(w http.ResponseWriter, r *http.Request, queue *jobs.Queue, j jobs.Job) {
+	if token, ok := auth.TokenFromContext(r.Context()); ok {
+		j.TokenID = token.ID
+	}
+	actor, _ := audit.ActorFromContext(r.Context())
+	j.Identity, j.ClientCert, j.SourceIP = actor.Identity, actor.ClientCert, actor.SourceIP
+	j.RequestID = httperror.RequestIDFromContext(r.Context())
+
+	j, err := queue.Submit(j)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/v1/jobs/"+j.ID)
+	w.Header().Set("Preference-Applied", "respond-async")
+	writeJSON(w, http.StatusAccepted, j)
+}
+
+// JobHandler serves GET /v1/jobs/{id}. Jobs are only visible to the token
+// that submitted them.
+func JobHandler(queue *jobs.Queue) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		j, ok := queue.Get(r.PathValue("id"))
+		if ok {
+			token, hasToken := auth.TokenFromContext(r.Context())
+			ok = hasToken && j.TokenID == token.ID
+		}
+		if !ok {
+			httperror.Write(w, r, errJobNotFound)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, j)
+	})
+}
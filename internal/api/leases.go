@@ -0,0 +1,34 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"dns-proxy/internal/leases"
+	"dns-proxy/internal/validate"
+)
+
+// maxExpiresIn bounds expires_in, leases are meant for temporary records
+const maxExpiresIn = 365 * 24 * 60 * 60
+
+// leaseTTL checks the expires_in field of a create request. Records with
+// an expiry need a lease store.
+func leaseTTL(expiresIn int64, store *leases.Store) (time.Duration, error) {
+	switch {
+	case expiresIn == 0:
+		return 0, nil
+	case expiresIn < 0 || expiresIn > maxExpiresIn:
+		return 0, &validate.FieldError{Field: "expires_in", Message: "must be between 1 and 31536000 seconds"}
+	case store == nil:
+		return 0, &validate.FieldError{Field: "expires_in", Message: "is not supported, expiring records are not enabled on this server"}
+	}
+	return time.Duration(expiresIn) * time.Second, nil
+}
+
+// withTTL gives the records created under ctx a lease of ttl, if non-zero
+func withTTL(ctx context.Context, ttl time.Duration) context.Context {
+	if ttl == 0 {
+		return ctx
+	}
+	return leases.WithTTL(ctx, ttl)
+}
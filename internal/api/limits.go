@@ -0,0 +1,41 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"dns-proxy/internal/httperror"
+	"dns-proxy/internal/validate"
+)
+
+var errTooLarge = httperror.New(http.StatusRequestEntityTooLarge, httperror.CodePayloadTooLarge, "request body too large")
+
+// DefaultMaxBodyBytes bounds request bodies. The largest legitimate body
+// carries two 4096 byte TXT values.
+const DefaultMaxBodyBytes = 64 << 10
+
+// LimitBody rejects request bodies larger than max bytes. Handlers see the
+// limit as an *http.MaxBytesError when reading the body.
+func LimitBody(max int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > max {
+			httperror.Write(w, r, errTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeJSON decodes the request body into v. Malformed bodies are
+// reported as a validation error of the "body" field, oversized ones as
+// the *http.MaxBytesError from LimitBody.
+func decodeJSON(r *http.Request, v interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	var tooLarge *http.MaxBytesError
+	if err == nil || errors.As(err, &tooLarge) {
+		return err
+	}
+	return &validate.FieldError{Field: "body", Message: "must be a JSON object"}
+}
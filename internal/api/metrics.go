@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"dns-proxy/internal/auth"
+	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/httperror"
+	"dns-proxy/internal/metrics"
+)
+
+// cpanelFunctions are the ZoneEdit functions the proxy calls, exported
+// with zero counts from the start
+var cpanelFunctions = []string{"fetchzone", "fetchzones", "add_zone_record", "remove_zone_record", "edit_zone_record"}
+
+// Metrics holds the Prometheus metrics of the API server and of its cPanel
+// calls. It implements cpanel.Observer.
+type Metrics struct {
+	Registry *metrics.Registry
+
+	requests       *metrics.CounterVec
+	inFlight       *metrics.GaugeVec
+	cpanelDuration *metrics.HistogramVec
+	cpanelErrors   *metrics.CounterVec
+	cpanelInFlight *metrics.GaugeVec
+}
+
+// NewMetrics registers the API metrics in a new registry
+func NewMetrics() *Metrics {
+	reg := metrics.NewRegistry()
+	m := &Metrics{
+		Registry:       reg,
+		requests:       reg.NewCounterVec("dnsproxy_http_requests_total", "HTTP requests by endpoint, status code and token ID.", "endpoint", "status", "token"),
+		inFlight:       reg.NewGaugeVec("dnsproxy_http_requests_in_flight", "HTTP requests currently being served."),
+		cpanelDuration: reg.NewHistogramVec("dnsproxy_cpanel_call_duration_seconds", "Duration of cPanel API calls by function.", metrics.DefaultBuckets, "function"),
+		cpanelErrors:   reg.NewCounterVec("dnsproxy_cpanel_errors_total", "Failed cPanel API calls by function and error kind.", "function", "kind"),
+		cpanelInFlight: reg.NewGaugeVec("dnsproxy_cpanel_calls_in_flight", "cPanel API calls currently running by function.", "function"),
+	}
+	m.inFlight.Set(0)
+	for _, fn := range cpanelFunctions {
+		m.cpanelDuration.Init(fn)
+		m.cpanelInFlight.Set(0, fn)
+	}
+	return m
+}
+
+// Handler serves the metrics on the API listeners. They name the tokens in
+// use, so a token allowed auth.OpMetrics is required.
+func (m *Metrics) Handler(keyring *auth.Keyring) http.Handler {
+	next := m.Registry.Handler()
+	return RequireToken(keyring, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _ := auth.TokenFromContext(r.Context())
+		if !token.Scope.AllowsOp(auth.OpMetrics) {
+			httperror.Write(w, r, httperror.New(http.StatusForbidden, httperror.CodeForbidden, "token is not allowed to read metrics"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// CallStarted implements cpanel.Observer
+func (m *Metrics) CallStarted(fn string) {
+	m.cpanelInFlight.Add(1, fn)
+}
+
+// CallFinished implements cpanel.Observer
+func (m *Metrics) CallFinished(fn string, elapsed time.Duration, err error) {
+	m.cpanelInFlight.Add(-1, fn)
+	m.cpanelDuration.Observe(elapsed.Seconds(), fn)
+	if err != nil {
+		kind := "unknown"
+		var cpErr *cpanel.Error
+		if errors.As(err, &cpErr) {
+			kind = cpErr.Kind.String()
+		}
+		m.cpanelErrors.Inc(fn, kind)
+	}
+}
+
+// requestInfo is filled in by TagRequest for Instrument, which runs before
+// routing and authentication and so can't see the route or the token itself
+type requestInfo struct {
+	endpoint string
+	token    string
+}
+
+type requestInfoKey struct{}
+
+// Instrument counts requests and tracks the number in flight. It should
+// wrap all other middlewares so rejected requests are counted too, with
+// TagRequest around the mux.
+func (m *Metrics) Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.inFlight.Add(1)
+		defer m.inFlight.Add(-1)
+
+		info := &requestInfo{}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+
+		endpoint := info.endpoint
+		if endpoint == "" {
+			// Rejected before routing, or no route matched
+			endpoint = "unmatched"
+		}
+		token := info.token
+		if token == "" {
+			token = "none"
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		m.requests.Inc(endpoint, strconv.Itoa(status), token)
+	})
+}
+
+// TagRequest records the authenticated token and the matched route
+// pattern, e.g. "POST /v1/zones/{zone}/txt", for Instrument. mux must be
+// the top-level ServeMux, see WrapMux.
+func TagRequest(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, _ := r.Context().Value(requestInfoKey{}).(*requestInfo)
+		if info == nil {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		if token, ok := auth.TokenFromContext(r.Context()); ok {
+			info.token = token.ID
+		}
+		mux.ServeHTTP(w, r)
+		// ServeMux sets the pattern on the request it was given, nested
+		// muxes overwrite it with their more specific one
+		info.endpoint = r.Pattern
+	})
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
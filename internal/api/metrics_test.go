@@ -0,0 +1,94 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dns-proxy/internal/auth"
+)
+
+func TestRequestsLabeledByRouteAndToken(t *testing.T) {
+	keyring, err := auth.LoadTokens(map[string]string{"token.certbot": "certbot-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("/", NewHandler(keyring, &fakeService{}, nil, nil))
+	// The order of cmd/dns-proxy-api, without the optional middlewares
+	handler := m.Instrument(BearerAuth(keyring, WrapMux(mux)))
+
+	requests := []struct {
+		method, target, token string
+	}{
+		{"GET", "/v1/zones/example.com/txt", "certbot-secret"},
+		{"GET", "/v1/zones/example.com/txt", "wrong"},
+		{"GET", "/nothing-here", "certbot-secret"},
+	}
+	for _, req := range requests {
+		r := httptest.NewRequest(req.method, req.target, nil)
+		r.Header.Set("Authorization", "Bearer "+req.token)
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	var out strings.Builder
+	if err := m.Registry.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`dnsproxy_http_requests_total{endpoint="GET /v1/zones/{zone}/txt",status="200",token="certbot"} 1`,
+		`dnsproxy_http_requests_total{endpoint="GET /v1/zones/{zone}/txt",status="401",token="none"} 1`,
+		`dnsproxy_http_requests_total{endpoint="unmatched",status="404",token="certbot"} 1`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics lack %s\n%s", want, out.String())
+		}
+	}
+}
+
+func TestMetricsNeedToken(t *testing.T) {
+	keyring, err := auth.LoadTokens(map[string]string{
+		"token.certbot":               "certbot-secret",
+		"token.certbot.operations":    "create,delete",
+		"token.prometheus":            "prometheus-secret",
+		"token.prometheus.operations": "metrics",
+		"token.admin":                 "admin-secret",
+		"token.zoned":                 "zoned-secret",
+		"token.zoned.zones":           "example.com",
+		"API_KEY":                     "default-secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMetrics()
+	handler := m.Handler(keyring)
+
+	tests := []struct {
+		authorization string
+		want          int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer certbot-secret", http.StatusForbidden},
+		{"Bearer prometheus-secret", http.StatusOK},
+		{"Bearer admin-secret", http.StatusForbidden},
+		{"Bearer zoned-secret", http.StatusForbidden},
+		{"Bearer default-secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("Authorization %q: %d, want %d", tt.authorization, w.Code, tt.want)
+		}
+		if w.Code == http.StatusOK && !strings.Contains(w.Body.String(), "dnsproxy_http_requests_in_flight") {
+			t.Errorf("Authorization %q: body lacks the metrics", tt.authorization)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"dns-proxy/internal/auth"
+	"dns-proxy/internal/httperror"
+	"dns-proxy/internal/ratelimit"
+)
+
+// RateLimit rejects requests with 429 once the bucket for key(r) is empty.
+// Requests for which key returns "" are not limited.
+func RateLimit(l *ratelimit.Limiter, key func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k := key(r)
+		if k == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ok, wait := l.Allow(k, time.Now())
+		if !ok {
+			log.Printf("%s %s: rate limit exceeded for %s", r.Method, r.URL.Path, k)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			httperror.Write(w, r, &httperror.Error{Status: http.StatusTooManyRequests, Code: httperror.CodeRateLimited, Message: "too many requests", Retryable: true})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ByClientIP keys rate limits by the client address. All requests over a
+// Unix socket share one bucket.
+func ByClientIP(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// clientIP returns the client address without port, "unix" for requests
+// over a Unix socket
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+			return "unix"
+		}
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ByToken keys rate limits by the authenticated token. It must run after
+// the authentication middlewares. Other requests, such as basic auth
+// (httpreq) and acme-dns ones whose credentials are only checked by their
+// handlers, are left to the per-IP limit: keying on a user name nobody has
+// verified yet would let anyone drain another user's bucket, or dodge the
+// limit by sending a new name each time.
+func ByToken(r *http.Request) string {
+	if token, ok := auth.TokenFromContext(r.Context()); ok {
+		return "token:" + token.ID
+	}
+	return ""
+}
+
+// BearerAuth stores the token of a valid "Authorization: Bearer" header in
+// the request context, so middlewares running before the handlers know
+// the caller. Invalid tokens are left for RequireToken to reject.
+func BearerAuth(keyring *auth.Keyring, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := auth.TokenFromContext(r.Context()); !ok {
+			if secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+				if token, ok := keyring.Authenticate(secret); ok {
+					r = r.WithContext(auth.WithToken(r.Context(), token))
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"dns-proxy/internal/audit"
+	"dns-proxy/internal/auth"
+)
+
+func TestByTokenIgnoresUnverifiedUsers(t *testing.T) {
+	r := httptest.NewRequest("POST", "/present", nil)
+	r.SetBasicAuth("victim", "wrong")
+	r.Header.Set("X-Api-User", "victim")
+	if k := ByToken(r); k != "" {
+		t.Errorf("ByToken of unauthenticated request = %q, want \"\"", k)
+	}
+
+	r = r.WithContext(auth.WithToken(r.Context(), &auth.Token{ID: "certbot"}))
+	if k := ByToken(r); k != "token:certbot" {
+		t.Errorf("ByToken = %q, want token:certbot", k)
+	}
+}
+
+func TestBasicAuthIdentityAudited(t *testing.T) {
+	var identity string
+	handler := AuditActor(RequireBasicAuth("lego", "pass", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor, _ := audit.ActorFromContext(r.Context())
+		identity = actor.Identity
+	})))
+
+	for _, tt := range []struct {
+		pass     string
+		status   int
+		identity string
+	}{
+		{"wrong", http.StatusUnauthorized, ""},
+		{"pass", http.StatusOK, "basic:lego"},
+	} {
+		identity = ""
+		r := httptest.NewRequest("POST", "/present", nil)
+		r.SetBasicAuth("lego", tt.pass)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.status || identity != tt.identity {
+			t.Errorf("password %q: status %d, identity %q, want %d, %q", tt.pass, w.Code, identity, tt.status, tt.identity)
+		}
+	}
+}
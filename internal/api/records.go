@@ -0,0 +1,264 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"dns-proxy/internal/auth"
+	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/idn"
+	"dns-proxy/internal/jobs"
+	"dns-proxy/internal/leases"
+	"dns-proxy/internal/validate"
+)
+
+// TxtRecordRequest is the body of POST and DELETE /v1/zones/{zone}/txt
+type TxtRecordRequest struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	ExpiresIn int64  `json:"expires_in,omitempty"` // seconds, POST only, 0 for a permanent record
+}
+
+// UpdateTxtRequest is the body of PUT /v1/zones/{zone}/txt
+type UpdateTxtRequest struct {
+	Name     string `json:"name"`
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+}
+
+// TxtRecordResponse describes a single TXT record in responses
+type TxtRecordResponse struct {
+	Zone  string `json:"zone"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// TxtRecordList is the response of GET /v1/zones/{zone}/txt
+type TxtRecordList struct {
+	Zone    string             `json:"zone"`
+	Records []cpanel.TxtRecord `json:"records"`
+}
+
+// ListTxtHandler serves GET /v1/zones/{zone}/txt[?name=<name>]
+func ListTxtHandler(svc TxtRecordService) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zone := r.PathValue("zone")
+		name := r.URL.Query().Get("name")
+
+		var err error
+		if name != "" {
+			err = normalizeRecordName("zone", &zone, "name", &name)
+		} else if zone, err = idn.ToASCII(zone); err != nil {
+			err = &validate.FieldError{Field: "zone", Message: err.Error()}
+		} else {
+			err = validate.Hostname("zone", zone)
+		}
+		if err == nil {
+			err = authorizeList(r, zone, name)
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), CPanelTimeout)
+		defer cancel()
+
+		records, err := svc.ListTxtRecords(ctx, zone, name)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// ListTxtRecords matches by prefix within the cPanel zone, the API
+		// returns exact matches within the zone of the path only, with keys
+		// relative to it. Records outside the token's scope are left out.
+		resp := TxtRecordList{Zone: zone, Records: []cpanel.TxtRecord{}}
+		suffix := "." + zone + "."
+		for _, record := range records {
+			if name != "" && !strings.EqualFold(record.Name, name+suffix) {
+				continue
+			}
+			if !strings.EqualFold(record.Name, zone+".") && !hasSuffixFold(record.Name, suffix) {
+				continue
+			}
+			if authorize(r, auth.OpList, record.Name, "") != nil {
+				continue
+			}
+			if hasSuffixFold(record.Name, suffix) {
+				record.Key = record.Name[:len(record.Name)-len(suffix)]
+			}
+			resp.Records = append(resp.Records, record)
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+// CreateTxtHandler serves POST /v1/zones/{zone}/txt
+func CreateTxtHandler(svc TxtRecordService, queue *jobs.Queue, leaseStore *leases.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req TxtRecordRequest
+		zone, ok := decodeRecordRequest(w, r, &req, &req.Name)
+		if !ok {
+			return
+		}
+		err := validate.TXTValue("value", req.Value)
+		var ttl time.Duration
+		if err == nil {
+			ttl, err = leaseTTL(req.ExpiresIn, leaseStore)
+		}
+		if err == nil {
+			err = authorize(r, auth.OpCreate, zone, req.Name)
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if queue != nil && wantsAsync(r) {
+			submitJob(w, r, queue, jobs.Job{Op: jobs.OpCreate, Zone: zone, Name: req.Name, NewValue: req.Value, ExpiresIn: req.ExpiresIn})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(withTTL(r.Context(), ttl), CPanelTimeout)
+		defer cancel()
+
+		if err := svc.CreateTxtRecord(ctx, zone, req.Name, req.Value); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, TxtRecordResponse{Zone: zone, Name: req.Name, Value: req.Value})
+	})
+}
+
+// UpdateTxtHandler serves PUT /v1/zones/{zone}/txt[?force=true], replacing
+// old_value with new_value
+func UpdateTxtHandler(svc TxtRecordService, queue *jobs.Queue) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req UpdateTxtRequest
+		zone, ok := decodeRecordRequest(w, r, &req, &req.Name)
+		if !ok {
+			return
+		}
+		err := validate.TXTValue("old_value", req.OldValue)
+		if err == nil {
+			err = validate.TXTValue("new_value", req.NewValue)
+		}
+		if err == nil {
+			err = authorize(r, auth.OpUpdate, zone, req.Name)
+		}
+		if err == nil && wantsForce(r) {
+			err = authorize(r, auth.OpForce, zone, req.Name)
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if queue != nil && wantsAsync(r) {
+			submitJob(w, r, queue, jobs.Job{Op: jobs.OpEdit, Zone: zone, Name: req.Name, OldValue: req.OldValue, NewValue: req.NewValue, Force: wantsForce(r)})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(forceContext(r), CPanelTimeout)
+		defer cancel()
+
+		if err := svc.EditTxtRecord(ctx, zone, req.Name, req.OldValue, req.NewValue); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, TxtRecordResponse{Zone: zone, Name: req.Name, Value: req.NewValue})
+	})
+}
+
+// DeleteTxtHandler serves DELETE /v1/zones/{zone}/txt[?force=true]
+func DeleteTxtHandler(svc TxtRecordService, queue *jobs.Queue) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req TxtRecordRequest
+		zone, ok := decodeRecordRequest(w, r, &req, &req.Name)
+		if !ok {
+			return
+		}
+		err := validate.TXTValue("value", req.Value)
+		if err == nil {
+			err = authorize(r, auth.OpDelete, zone, req.Name)
+		}
+		if err == nil && wantsForce(r) {
+			err = authorize(r, auth.OpForce, zone, req.Name)
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if queue != nil && wantsAsync(r) {
+			submitJob(w, r, queue, jobs.Job{Op: jobs.OpDelete, Zone: zone, Name: req.Name, OldValue: req.Value, Force: wantsForce(r)})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(forceContext(r), CPanelTimeout)
+		defer cancel()
+
+		if err := svc.DeleteTxtRecord(ctx, zone, req.Name, req.Value); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, TxtRecordResponse{Zone: zone, Name: req.Name, Value: req.Value})
+	})
+}
+
+// hasSuffixFold reports whether s ends with suffix, ignoring case
+func hasSuffixFold(s, suffix string) bool {
+	return len(s) >= len(suffix) && strings.EqualFold(s[len(s)-len(suffix):], suffix)
+}
+
+// authorizeList checks that the request's token may list TXT records in zone,
+// or the record name.zone when a name filter is given
+func authorizeList(r *http.Request, zone, name string) error {
+	if name != "" {
+		return authorize(r, auth.OpList, zone, name)
+	}
+	token, ok := auth.TokenFromContext(r.Context())
+	if ok && !token.Scope.AllowsZone(auth.OpList, zone) {
+		return &auth.ScopeError{TokenID: token.ID, Op: auth.OpList, Type: "TXT", Name: zone}
+	}
+	return nil
+}
+
+// wantsForce reports whether the client asked, with ?force=true, to change
+// a record the owner registry does not list
+func wantsForce(r *http.Request) bool {
+	return r.URL.Query().Get("force") == "true"
+}
+
+// forceContext returns the request's context, marked to skip the ownership
+// check if the client asked for it
+func forceContext(r *http.Request) context.Context {
+	if wantsForce(r) {
+		return cpanel.WithForce(r.Context())
+	}
+	return r.Context()
+}
+
+// decodeRecordRequest decodes the JSON body into req and normalizes the zone
+// path value and the record name. On failure it writes the error response
+// and returns false.
+func decodeRecordRequest(w http.ResponseWriter, r *http.Request, req interface{}, name *string) (string, bool) {
+	if err := decodeJSON(r, req); err != nil {
+		writeError(w, r, err)
+		return "", false
+	}
+	zone := r.PathValue("zone")
+	if err := normalizeRecordName("zone", &zone, "name", name); err != nil {
+		writeError(w, r, err)
+		return "", false
+	}
+	return zone, true
+}
+
+// writeJSON replies with v encoded as JSON
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
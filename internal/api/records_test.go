@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dns-proxy/internal/auth"
+)
+
+func TestListTxtRelativeToPathZone(t *testing.T) {
+	keyring, err := auth.LoadTokens(map[string]string{"API_KEY": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHandler(keyring, &fakeService{}, nil, nil)
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	for _, c := range []struct{ zone, name string }{
+		{"sub.example.com", "_acme-challenge"},
+		{"other.example.com", "_acme-challenge"},
+		{"example.com", "_acme-challenge"},
+	} {
+		if w := do("POST", "/v1/zones/"+c.zone+"/txt", `{"name":"`+c.name+`","value":"v"}`); w.Code != http.StatusCreated {
+			t.Fatalf("POST %s: %d %s", c.zone, w.Code, w.Body)
+		}
+	}
+
+	tests := []struct {
+		target string
+		keys   []string
+	}{
+		{"/v1/zones/sub.example.com/txt?name=_acme-challenge", []string{"_acme-challenge"}},
+		{"/v1/zones/sub.example.com/txt", []string{"_acme-challenge"}},
+		{"/v1/zones/example.com/txt?name=_acme-challenge", []string{"_acme-challenge"}},
+		{"/v1/zones/example.com/txt?name=_acme-challenge.sub", []string{"_acme-challenge.sub"}},
+		{"/v1/zones/example.com/txt", []string{"_acme-challenge.sub", "_acme-challenge.other", "_acme-challenge"}},
+	}
+	for _, tt := range tests {
+		w := do("GET", tt.target, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", tt.target, w.Code, w.Body)
+		}
+		var list TxtRecordList
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, rec := range list.Records {
+			keys = append(keys, rec.Key)
+		}
+		if strings.Join(keys, " ") != strings.Join(tt.keys, " ") {
+			t.Errorf("GET %s: keys %q, want %q", tt.target, keys, tt.keys)
+		}
+	}
+}
+
+func TestForceNeedsScope(t *testing.T) {
+	keyring, err := auth.LoadTokens(map[string]string{
+		"token.certbot":            "certbot-secret",
+		"token.certbot.operations": "create,delete",
+		"token.admin":              "admin-secret",
+		"token.admin.operations":   "update,delete,force",
+		"token.zoned":              "zoned-secret",
+		"token.zoned.zones":        "example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHandler(keyring, &fakeService{}, nil, nil)
+
+	tests := []struct {
+		secret, method, target, body string
+		want                         int
+	}{
+		{"certbot-secret", "DELETE", "/v1/zones/example.com/txt", `{"name":"_acme-challenge","value":"v"}`, http.StatusOK},
+		{"certbot-secret", "DELETE", "/v1/zones/example.com/txt?force=true", `{"name":"_acme-challenge","value":"v"}`, http.StatusForbidden},
+		{"admin-secret", "DELETE", "/v1/zones/example.com/txt?force=true", `{"name":"_acme-challenge","value":"v"}`, http.StatusOK},
+		{"admin-secret", "PUT", "/v1/zones/example.com/txt?force=true", `{"name":"_acme-challenge","old_value":"v","new_value":"w"}`, http.StatusOK},
+		// Without an operations line every operation but force is allowed
+		{"zoned-secret", "DELETE", "/v1/zones/example.com/txt", `{"name":"_acme-challenge","value":"v"}`, http.StatusOK},
+		{"zoned-secret", "DELETE", "/v1/zones/example.com/txt?force=true", `{"name":"_acme-challenge","value":"v"}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		r.Header.Set("Authorization", "Bearer "+tt.secret)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s %s as %s: %d %s, want %d", tt.method, tt.target, tt.secret, w.Code, w.Body, tt.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"dns-proxy/internal/auth"
+	"dns-proxy/internal/httperror"
+)
+
+// maxSignedBody bounds the body read to compute the signature
+const maxSignedBody = 1 << 20
+
+// VerifySignatures authenticates requests signed with a token's signing key
+// and stores the token in the request context, where RequireToken accepts
+// it. Other requests pass through unchanged unless required is set, in which
+// case bearer tokens are refused. Basic auth and acme-dns credentials are
+// not affected.
+func VerifySignatures(v *auth.Verifier, required bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if !auth.IsSigned(header) {
+			if required && strings.HasPrefix(header, "Bearer ") {
+				httperror.Write(w, r, httperror.New(http.StatusUnauthorized, httperror.CodeUnauthorized, "signed requests required"))
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+		var tooLarge *http.MaxBytesError
+		if err != nil && !errors.As(err, &tooLarge) {
+			httperror.Write(w, r, httperror.New(http.StatusBadRequest, httperror.CodeInvalidRequest, "failed to read request body"))
+			return
+		}
+		if err != nil || len(body) > maxSignedBody {
+			httperror.Write(w, r, errTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		token, err := v.Verify(header, r.Method, r.URL.RequestURI(), body, time.Now())
+		if err != nil {
+			log.Printf("%s %s: rejected signed request from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			if errors.Is(err, auth.ErrNonceCacheFull) {
+				w.Header().Set("Retry-After", "1")
+				httperror.Write(w, r, &httperror.Error{Status: http.StatusServiceUnavailable, Code: httperror.CodeOverloaded, Message: "too many signed requests", Retryable: true})
+				return
+			}
+			httperror.Write(w, r, httperror.New(http.StatusUnauthorized, httperror.CodeUnauthorized, err.Error()))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithToken(r.Context(), token)))
+	})
+}
@@ -0,0 +1,276 @@
+// Package audit appends an entry for every DNS record change to a
+// JSON-lines file. Each entry includes the hash of the previous one, so
+// editing or removing an entry breaks the chain and is found by Verify.
+//
+// The chain is plain, unkeyed SHA-256: anyone who can write the file can
+// also recompute every hash after their edit and produce a valid chain.
+// The log is only tamper-evident against such a writer if the last hash
+// reported by Verify is kept somewhere they cannot change.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"dns-proxy/internal/cpanel"
+)
+
+// Results recorded in Entry.Result
+const (
+	ResultOK    = "ok"
+	ResultError = "error"
+)
+
+// Entry is one line of the audit log
+type Entry struct {
+	Seq        int64     `json:"seq"`
+	Time       time.Time `json:"time"`
+	Identity   string    `json:"identity"`              // e.g. "token:certbot" or "unix:root"
+	ClientCert string    `json:"client_cert,omitempty"` // identity of the TLS client certificate, if any
+	SourceIP   string    `json:"source_ip,omitempty"`
+	Op         string    `json:"op"`
+	Zone       string    `json:"zone"`
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	OldValue   string    `json:"old_value,omitempty"`
+	NewValue   string    `json:"new_value,omitempty"`
+	Serial     string    `json:"serial,omitempty"`
+	Forced     bool      `json:"forced,omitempty"` // the ownership check was skipped
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+// computeHash returns the hex SHA-256 of the entry encoded without its hash
+func (e Entry) computeHash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Actor is who made a change and from where
+type Actor struct {
+	Identity   string
+	ClientCert string
+	SourceIP   string
+}
+
+type actorKey struct{}
+
+// WithActor returns a context whose record changes are attributed to actor
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor stored by WithActor
+func ActorFromContext(ctx context.Context) (Actor, bool) {
+	actor, ok := ctx.Value(actorKey{}).(Actor)
+	return actor, ok
+}
+
+// Log appends entries to an audit file. Appends are serialized with an
+// exclusive lock on the file, so the API and CLI can share one log.
+// It implements cpanel.ChangeRecorder.
+type Log struct {
+	// DefaultActor is used for changes whose context carries no actor
+	DefaultActor Actor
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Open opens or creates the audit log at path
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &Log{file: f}, nil
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	return l.file.Close()
+}
+
+// RecordChange implements cpanel.ChangeRecorder. A failure to write the
+// entry is logged, the change itself has already been made.
+func (l *Log) RecordChange(ctx context.Context, c cpanel.Change) {
+	actor, ok := ActorFromContext(ctx)
+	if !ok {
+		actor = l.DefaultActor
+	}
+	e := Entry{
+		Identity:   actor.Identity,
+		ClientCert: actor.ClientCert,
+		SourceIP:   actor.SourceIP,
+		Op:         c.Op,
+		Zone:       c.Zone,
+		Name:       c.Name,
+		Type:       c.Type,
+		OldValue:   c.OldValue,
+		NewValue:   c.NewValue,
+		Serial:     c.Serial,
+		Forced:     c.Forced,
+		Result:     ResultOK,
+	}
+	if c.Err != nil {
+		e.Result = ResultError
+		e.Error = c.Err.Error()
+	}
+	if err := l.Append(e); err != nil {
+		log.Printf("audit: failed to record %s of %s: %v", c.Op, c.Name, err)
+	}
+}
+
+// Append sets the sequence number, time and hashes of e and appends it
+func (l *Log) Append(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fd := int(l.file.Fd())
+	if err := syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock audit log: %w", err)
+	}
+	defer syscall.Flock(fd, syscall.LOCK_UN)
+
+	last, err := lastLine(l.file)
+	if err != nil {
+		return err
+	}
+	if len(last) > 0 {
+		var prev Entry
+		if err := json.Unmarshal(last, &prev); err != nil {
+			return fmt.Errorf("failed to parse last audit entry: %w", err)
+		}
+		e.Seq = prev.Seq + 1
+		e.PrevHash = prev.Hash
+	} else {
+		e.Seq = 1
+	}
+	e.Time = time.Now().UTC()
+	if e.Hash, err = e.computeHash(); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return l.file.Sync()
+}
+
+// lastLine returns the last complete line of f without its newline, or
+// nil if f is empty
+func lastLine(f *os.File) ([]byte, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	if size == 0 {
+		return nil, nil
+	}
+
+	const chunk = 4096
+	var tail []byte
+	for off := size; off > 0; {
+		n := int64(chunk)
+		if off < n {
+			n = off
+		}
+		off -= n
+		buf := make([]byte, n)
+		if _, err := f.ReadAt(buf, off); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to read audit log: %w", err)
+		}
+		tail = append(buf, tail...)
+		if tail[len(tail)-1] != '\n' {
+			return nil, errors.New("audit log does not end with a complete entry")
+		}
+		if i := bytes.LastIndexByte(tail[:len(tail)-1], '\n'); i >= 0 {
+			return tail[i+1 : len(tail)-1], nil
+		}
+	}
+	return tail[:len(tail)-1], nil
+}
+
+// VerifyError reports where the chain of an audit log is broken
+type VerifyError struct {
+	Line   int
+	Reason string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("audit log line %d: %s", e.Line, e.Reason)
+}
+
+// Verify checks every entry read from r: that it is unmodified, numbered
+// in sequence and linked to the previous entry. It returns the number of
+// entries and the hash of the last one. As the hashes are unkeyed, a
+// rewritten log verifies too; only comparing against a last hash stored
+// elsewhere detects that, or entries removed from the end.
+func Verify(r io.Reader) (int, string, error) {
+	br := bufio.NewReader(r)
+	var prev Entry
+	n := 0
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return n, prev.Hash, nil
+		}
+		if err == io.EOF {
+			return n, prev.Hash, &VerifyError{Line: n + 1, Reason: "incomplete entry at end of file"}
+		}
+		if err != nil {
+			return n, prev.Hash, err
+		}
+		line = line[:len(line)-1]
+		n++
+
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return n - 1, prev.Hash, &VerifyError{Line: n, Reason: "not a valid entry: " + err.Error()}
+		}
+		// Re-encoding must give the same bytes, so no field was added,
+		// reformatted or reordered
+		canonical, err := json.Marshal(e)
+		if err != nil || !bytes.Equal(canonical, line) {
+			return n - 1, prev.Hash, &VerifyError{Line: n, Reason: "entry was modified"}
+		}
+		if e.Seq != int64(n) {
+			return n - 1, prev.Hash, &VerifyError{Line: n, Reason: fmt.Sprintf("sequence number %d, expected %d", e.Seq, n)}
+		}
+		if e.PrevHash != prev.Hash {
+			return n - 1, prev.Hash, &VerifyError{Line: n, Reason: "does not link to the previous entry"}
+		}
+		hash, err := e.computeHash()
+		if err != nil {
+			return n - 1, prev.Hash, err
+		}
+		if hash != e.Hash {
+			return n - 1, prev.Hash, &VerifyError{Line: n, Reason: "hash does not match the entry"}
+		}
+		prev = e
+	}
+}
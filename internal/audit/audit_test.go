@@ -0,0 +1,170 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dns-proxy/internal/cpanel"
+)
+
+// writeLog appends n entries through Log.Append and returns the file's lines
+func writeLog(t *testing.T, n int) (string, [][]byte) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for i := 1; i <= n; i++ {
+		e := Entry{Identity: "token:certbot", Op: "create", Zone: "example.com", Name: "_acme-challenge.example.com.", Type: "TXT", NewValue: fmt.Sprint("value-", i), Result: ResultOK}
+		if err := l.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, bytes.SplitAfter(data, []byte("\n"))[:n]
+}
+
+func join(lines ...[]byte) []byte {
+	return bytes.Join(lines, nil)
+}
+
+func TestVerify(t *testing.T) {
+	_, lines := writeLog(t, 5)
+
+	n, last, err := Verify(bytes.NewReader(join(lines...)))
+	if err != nil || n != 5 {
+		t.Fatalf("Verify = %d, %v, want 5 entries", n, err)
+	}
+	var e Entry
+	json.Unmarshal(lines[4], &e)
+	if last != e.Hash || e.Seq != 5 {
+		t.Errorf("last hash %q, want that of entry 5 %+v", last, e)
+	}
+
+	edited := bytes.Replace(lines[2], []byte("value-3"), []byte("value-X"), 1)
+	reordered := [][]byte{lines[0], lines[2], lines[1], lines[3], lines[4]}
+	tests := []struct {
+		name   string
+		data   []byte
+		line   int
+		reason string
+	}{
+		{"edited value", join(lines[0], lines[1], edited, lines[3], lines[4]), 3, "hash does not match the entry"},
+		{"removed middle line", join(lines[0], lines[1], lines[3], lines[4]), 3, "sequence number 4, expected 3"},
+		{"reordered lines", join(reordered...), 2, "sequence number 3, expected 2"},
+		{"truncated last line", join(lines[0], lines[1], lines[2], lines[3], lines[4][:len(lines[4])/2]), 5, "incomplete entry at end of file"},
+		{"reformatted line", join(lines[0], bytes.Replace(lines[1], []byte(`,"op"`), []byte(`, "op"`), 1)), 2, "entry was modified"},
+		{"not JSON", join(lines[0], []byte("garbage\n")), 2, "not a valid entry"},
+		{"first line removed", join(lines[1:]...), 1, "sequence number 2, expected 1"},
+	}
+	for _, tt := range tests {
+		n, _, err := Verify(bytes.NewReader(tt.data))
+		var verr *VerifyError
+		if !errors.As(err, &verr) || verr.Line != tt.line || !strings.Contains(verr.Reason, tt.reason) {
+			t.Errorf("%s: err = %v, want line %d: %s", tt.name, err, tt.line, tt.reason)
+			continue
+		}
+		if n != tt.line-1 {
+			t.Errorf("%s: %d entries verified, want %d", tt.name, n, tt.line-1)
+		}
+	}
+}
+
+func TestVerifyRewrittenChain(t *testing.T) {
+	_, lines := writeLog(t, 3)
+	_, last, _ := Verify(bytes.NewReader(join(lines...)))
+
+	// Anyone who can write the file can rebuild the chain after an edit.
+	// It verifies, only the last hash kept elsewhere gives it away.
+	var rewritten [][]byte
+	prev := ""
+	for _, line := range lines {
+		var e Entry
+		json.Unmarshal(line, &e)
+		e.NewValue = strings.Replace(e.NewValue, "value", "forged", 1)
+		e.PrevHash = prev
+		e.Hash, _ = e.computeHash()
+		prev = e.Hash
+		data, _ := json.Marshal(e)
+		rewritten = append(rewritten, append(data, '\n'))
+	}
+	n, forgedLast, err := Verify(bytes.NewReader(join(rewritten...)))
+	if err != nil || n != 3 {
+		t.Fatalf("rewritten chain: %d, %v", n, err)
+	}
+	if forgedLast == last {
+		t.Error("rewritten chain ends in the original hash")
+	}
+
+	// Cutting entries from the end also leaves a valid chain
+	if _, cutLast, err := Verify(bytes.NewReader(join(lines[:2]...))); err != nil || cutLast == last {
+		t.Errorf("cut chain: %q, %v", cutLast, err)
+	}
+}
+
+func TestAppendContinuesChain(t *testing.T) {
+	path, _ := writeLog(t, 2)
+
+	// A second writer, such as the CLI next to the API, links to the file
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.DefaultActor = Actor{Identity: "unix:root"}
+	ctx := WithActor(context.Background(), Actor{Identity: "token:certbot", SourceIP: "192.0.2.10", ClientCert: "cn:certbot"})
+	l.RecordChange(ctx, cpanel.Change{Op: "delete", Zone: "example.com", Name: "x.example.com.", Type: "TXT", OldValue: "v", Forced: true})
+	l.RecordChange(context.Background(), cpanel.Change{Op: "edit", Zone: "example.com", Name: "x.example.com.", Type: "TXT", Err: errors.New("cPanel is down")})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _, err := Verify(bytes.NewReader(data)); err != nil || n != 4 {
+		t.Fatalf("Verify = %d, %v, want 4 entries", n, err)
+	}
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+	var forced, failed Entry
+	json.Unmarshal(lines[2], &forced)
+	json.Unmarshal(lines[3], &failed)
+	if forced.Identity != "token:certbot" || forced.SourceIP != "192.0.2.10" || forced.ClientCert != "cn:certbot" || !forced.Forced || forced.Result != ResultOK {
+		t.Errorf("entry 3 %+v, want the request's actor and forced", forced)
+	}
+	if failed.Identity != "unix:root" || failed.Result != ResultError || failed.Error != "cPanel is down" {
+		t.Errorf("entry 4 %+v, want the default actor and the error", failed)
+	}
+
+	// An incomplete last line is not built upon
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`{"seq":5`)
+	f.Close()
+	if err := l.Append(Entry{Op: "create"}); err == nil || !strings.Contains(err.Error(), "does not end with a complete entry") {
+		t.Errorf("Append after a torn write: %v", err)
+	}
+}
+
+func TestLastLineAcrossChunks(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	long := strings.Repeat("x", 10000)
+	f.WriteString("first\n" + long + "\n")
+	got, err := lastLine(f)
+	if err != nil || string(got) != long {
+		t.Errorf("lastLine = %d bytes, %v, want the %d byte line", len(got), err, len(long))
+	}
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// Client certificate identity kinds, used as prefixes in token.<id>.client_cert
+var certIdentityKinds = []string{"cn", "dns", "email", "uri"}
+
+// CertIdentities returns the identities a client certificate proves, in
+// the form used by token.<id>.client_cert: "cn:<subject common name>",
+// "dns:<SAN>", "email:<SAN>" and "uri:<SAN>".
+func CertIdentities(cert *x509.Certificate) []string {
+	var ids []string
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, "cn:"+cert.Subject.CommonName)
+	}
+	for _, name := range cert.DNSNames {
+		ids = append(ids, "dns:"+strings.ToLower(name))
+	}
+	for _, addr := range cert.EmailAddresses {
+		ids = append(ids, "email:"+strings.ToLower(addr))
+	}
+	for _, uri := range cert.URIs {
+		ids = append(ids, "uri:"+uri.String())
+	}
+	return ids
+}
+
+// ClientCertToken returns the token mapped to a verified client certificate
+func (k *Keyring) ClientCertToken(cert *x509.Certificate) (*Token, bool) {
+	ids := CertIdentities(cert)
+	for _, t := range k.tokens {
+		for _, want := range t.ClientCerts {
+			for _, id := range ids {
+				if id == want {
+					return t, true
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+// parseCertIdentity validates a configured client certificate identity
+func parseCertIdentity(key, v string) (string, error) {
+	kind, value, ok := strings.Cut(v, ":")
+	if !ok || value == "" || !contains(certIdentityKinds, kind) {
+		return "", fmt.Errorf("%s: %q must be one of %s followed by \":<value>\"", key, v, strings.Join(certIdentityKinds, ", "))
+	}
+	kind = strings.ToLower(kind)
+	if kind == "dns" || kind == "email" {
+		value = strings.ToLower(value)
+	}
+	return kind + ":" + value, nil
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClientCertToken(t *testing.T) {
+	keyring, err := LoadTokens(map[string]string{
+		"token.certbot.client_cert": "DNS:Certbot.Internal, cn:certbot",
+		"token.spiffe.client_cert":  "uri:spiffe://example.com/dns-proxy",
+		"token.admin":               "admin-secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spiffe, _ := url.Parse("spiffe://example.com/dns-proxy")
+	tests := []struct {
+		cert *x509.Certificate
+		want string
+	}{
+		{&x509.Certificate{DNSNames: []string{"certbot.INTERNAL"}}, "certbot"},
+		{&x509.Certificate{Subject: pkix.Name{CommonName: "certbot"}}, "certbot"},
+		{&x509.Certificate{URIs: []*url.URL{spiffe}}, "spiffe"},
+		// Common names are matched exactly
+		{&x509.Certificate{Subject: pkix.Name{CommonName: "Certbot"}}, ""},
+		{&x509.Certificate{DNSNames: []string{"admin"}}, ""},
+	}
+	for _, tt := range tests {
+		token, ok := keyring.ClientCertToken(tt.cert)
+		got := ""
+		if ok {
+			got = token.ID
+		}
+		if got != tt.want {
+			t.Errorf("certificate %v: token %q, want %q", CertIdentities(tt.cert), got, tt.want)
+		}
+	}
+}
+
+func TestCertIdentities(t *testing.T) {
+	uri, _ := url.Parse("spiffe://example.com/x")
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "Certbot"},
+		DNSNames:       []string{"A.example.com"},
+		EmailAddresses: []string{"Ops@Example.com"},
+		URIs:           []*url.URL{uri},
+	}
+	want := []string{"cn:Certbot", "dns:a.example.com", "email:ops@example.com", "uri:spiffe://example.com/x"}
+	if got := CertIdentities(cert); !reflect.DeepEqual(got, want) {
+		t.Errorf("CertIdentities = %v, want %v", got, want)
+	}
+}
+
+func TestClientCertConfigRejects(t *testing.T) {
+	for _, v := range []string{"certbot", "ip:192.0.2.1", "dns:"} {
+		_, err := LoadTokens(map[string]string{"token.certbot.client_cert": v})
+		if err == nil || !strings.Contains(err.Error(), "must be one of cn, dns, email, uri") {
+			t.Errorf("client_cert=%q: err = %v", v, err)
+		}
+	}
+}
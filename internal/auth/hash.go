@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// hashScheme prefixes hashed credentials in the configuration:
+//
+//	sha256:<salt>:<hex digest>[@<expiry>]
+//
+// The digest is HMAC-SHA256 keyed with the pepper over salt and secret, so
+// a leaked configuration file without the pepper does not allow offline
+// guessing.
+const hashScheme = "sha256"
+
+// Credential is a single accepted secret of a token, stored as a salted hash
+type Credential struct {
+	Salt    string
+	Digest  []byte
+	Expires time.Time // zero for no expiry
+}
+
+// Expired reports whether the credential is no longer valid at now
+func (c *Credential) Expired(now time.Time) bool {
+	return !c.Expires.IsZero() && !now.Before(c.Expires)
+}
+
+// matches reports whether secret hashes to the credential's digest, in constant time
+func (c *Credential) matches(pepper, secret string) bool {
+	return subtle.ConstantTimeCompare(hashSecret(pepper, c.Salt, secret), c.Digest) == 1
+}
+
+// String returns the configuration form of the credential
+func (c *Credential) String() string {
+	s := hashScheme + ":" + c.Salt + ":" + hex.EncodeToString(c.Digest)
+	if !c.Expires.IsZero() {
+		s += "@" + c.Expires.UTC().Format(time.RFC3339)
+	}
+	return s
+}
+
+// NewCredential hashes secret with a fresh salt
+func NewCredential(pepper, secret string, expires time.Time) (*Credential, error) {
+	salt, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	return &Credential{Salt: salt, Digest: hashSecret(pepper, salt, secret), Expires: expires}, nil
+}
+
+// ParseCredential parses a hashed credential from the configuration
+func ParseCredential(s string) (*Credential, error) {
+	hash, expiry, hasExpiry := strings.Cut(s, "@")
+	parts := strings.Split(hash, ":")
+	if len(parts) != 3 || parts[0] != hashScheme || parts[1] == "" {
+		return nil, errors.New("expected sha256:<salt>:<digest>[@<expiry>]")
+	}
+	digest, err := hex.DecodeString(parts[2])
+	if err != nil || len(digest) != sha256.Size {
+		return nil, errors.New("digest must be 64 hex characters")
+	}
+	c := &Credential{Salt: parts[1], Digest: digest}
+	if hasExpiry {
+		if c.Expires, err = ParseExpiry(expiry); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// ParseExpiry accepts an RFC 3339 timestamp or a YYYY-MM-DD date, which
+// means midnight UTC at the start of that day
+func ParseExpiry(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry %q, expected YYYY-MM-DD or RFC 3339", s)
+}
+
+// isHashed reports whether a configured secret is in the hashed form
+func isHashed(s string) bool {
+	return strings.HasPrefix(s, hashScheme+":")
+}
+
+func hashSecret(pepper, salt, secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(pepper))
+	mac.Write([]byte(salt))
+	mac.Write([]byte{0})
+	mac.Write([]byte(secret))
+	return mac.Sum(nil)
+}
+
+// GenerateSecret returns a new random token secret
+func GenerateSecret() (string, error) {
+	return randomToken(32)
+}
+
+// randomToken returns n random bytes, base64url encoded
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate random data: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
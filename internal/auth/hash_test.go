@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCredentialRoundTrip(t *testing.T) {
+	expires := time.Date(2027, 1, 31, 0, 0, 0, 0, time.UTC)
+	c, err := NewCredential("pepper", "secret", expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := c.String()
+	if !strings.HasPrefix(s, "sha256:") || !strings.HasSuffix(s, "@2027-01-31T00:00:00Z") || strings.Contains(s, "secret") {
+		t.Errorf("String() = %q", s)
+	}
+
+	parsed, err := ParseCredential(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.matches("pepper", "secret") {
+		t.Error("parsed credential does not match its secret")
+	}
+	if parsed.matches("", "secret") || parsed.matches("pepper", "Secret") {
+		t.Error("credential matches with the wrong pepper or secret")
+	}
+	if !parsed.Expires.Equal(expires) {
+		t.Errorf("Expires = %v, want %v", parsed.Expires, expires)
+	}
+
+	// The same secret gets a different salt and digest each time
+	other, _ := NewCredential("pepper", "secret", time.Time{})
+	if other.Salt == c.Salt || strings.Contains(other.String(), "@") {
+		t.Errorf("second credential %q", other)
+	}
+}
+
+func TestParseCredentialRejects(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+	for _, s := range []string{
+		"md5:salt:" + digest,
+		"sha256::" + digest,
+		"sha256:salt",
+		"sha256:salt:" + digest[:62],
+		"sha256:salt:" + strings.Repeat("zz", 32),
+		"sha256:salt:" + digest + "@tomorrow",
+	} {
+		if _, err := ParseCredential(s); err == nil {
+			t.Errorf("ParseCredential(%q) succeeded", s)
+		}
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	tests := []struct {
+		s    string
+		want time.Time
+	}{
+		{"2027-01-31", time.Date(2027, 1, 31, 0, 0, 0, 0, time.UTC)},
+		{"2027-01-31T12:00:00+02:00", time.Date(2027, 1, 31, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseExpiry(tt.s)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseExpiry(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+	}
+	if _, err := ParseExpiry("31.01.2027"); err == nil {
+		t.Error("ParseExpiry accepted a date in another format")
+	}
+}
+
+func TestCredentialRotation(t *testing.T) {
+	now := time.Now()
+	old, _ := NewCredential("pepper", "old-secret", now.Add(-time.Minute))
+	current, _ := NewCredential("pepper", "new-secret", now.Add(time.Hour))
+	soon, _ := NewCredential("pepper", "next-secret", time.Time{})
+
+	keyring, err := LoadTokens(map[string]string{
+		"token_pepper":  "pepper",
+		"token.certbot": old.String() + ", " + current.String() + "," + soon.String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for secret, want := range map[string]bool{"old-secret": false, "new-secret": true, "next-secret": true, "other": false} {
+		if _, ok := keyring.Authenticate(secret); ok != want {
+			t.Errorf("Authenticate(%q) = %v, want %v", secret, ok, want)
+		}
+	}
+
+	// Hashes only match with the pepper they were made with
+	unpeppered, err := LoadTokens(map[string]string{"token.certbot": current.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := unpeppered.Authenticate("new-secret"); ok {
+		t.Error("credential matched without its pepper")
+	}
+
+	// Plaintext secrets are still accepted and hashed on load
+	plain, err := LoadTokens(map[string]string{"token.certbot": "plain-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, ok := plain.Authenticate("plain-secret")
+	if !ok || len(token.Credentials) != 1 || strings.Contains(token.Credentials[0].String(), "plain-secret") {
+		t.Errorf("plaintext secret: %v, %v", token, ok)
+	}
+}
+
+func TestCredentialExpired(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		expires time.Time
+		want    bool
+	}{
+		{time.Time{}, false},
+		{now.Add(time.Second), false},
+		{now, true},
+		{now.Add(-time.Second), true},
+	}
+	for _, tt := range tests {
+		c := &Credential{Expires: tt.expires}
+		if got := c.Expired(now); got != tt.want {
+			t.Errorf("Expired with expiry %v = %v, want %v", tt.expires, got, tt.want)
+		}
+	}
+}
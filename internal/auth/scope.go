@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Operations a token can be allowed to perform
+const (
+	OpList   = "list"
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+	// OpForce is needed on top of OpUpdate or OpDelete to change a record
+	// the owner registry does not list
+	OpForce = "force"
+	// OpMetrics allows reading /metrics on the API listeners
+	OpMetrics = "metrics"
+)
+
+var operations = []string{OpList, OpCreate, OpUpdate, OpDelete, OpForce, OpMetrics}
+
+// privileged operations are only allowed if Operations lists them
+var privileged = []string{OpForce, OpMetrics}
+
+// Scope limits what a token may do. An empty list allows everything for
+// that dimension, except that an empty Operations does not cover the
+// privileged OpForce and OpMetrics. The zero Scope may change but not force
+// any record, and may not read metrics.
+type Scope struct {
+	Zones      []string // zones the record must be in, e.g. "example.com"
+	Names      []string // record name globs, e.g. "_acme-challenge.*.example.com"
+	Types      []string // record types, e.g. "TXT"
+	Operations []string // any of OpList, OpCreate, OpUpdate, OpDelete, OpForce, OpMetrics
+}
+
+// ScopeError is returned when a token's scope does not cover a request
+type ScopeError struct {
+	TokenID string
+	Op      string
+	Type    string
+	Name    string
+}
+
+func (e *ScopeError) Error() string {
+	return fmt.Sprintf("token %q may not %s %s %s", e.TokenID, e.Op, e.Type, e.Name)
+}
+
+// AllowsOp reports whether the scope permits op, for operations such as
+// OpMetrics that do not concern any record
+func (s Scope) AllowsOp(op string) bool {
+	if len(s.Operations) == 0 && contains(privileged, op) {
+		return false
+	}
+	return contains(s.Operations, op)
+}
+
+// AllowsZone reports whether the scope covers any record in zone. It is
+// used for listing, where no single record name is known in advance.
+func (s Scope) AllowsZone(op, zone string) bool {
+	if !s.AllowsOp(op) {
+		return false
+	}
+	if len(s.Zones) == 0 {
+		return true
+	}
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	for _, z := range s.Zones {
+		if inZone(zone, z) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the scope permits op on the record fqdn of type rrtype
+func (s Scope) Allows(op, fqdn, rrtype string) bool {
+	if !s.AllowsOp(op) || !contains(s.Types, rrtype) {
+		return false
+	}
+	fqdn = strings.ToLower(strings.TrimSuffix(fqdn, "."))
+
+	if len(s.Zones) > 0 {
+		ok := false
+		for _, z := range s.Zones {
+			if inZone(fqdn, z) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	if len(s.Names) == 0 {
+		return true
+	}
+	for _, pattern := range s.Names {
+		// "*" also matches dots, so "*.example.com" covers every subdomain
+		if ok, _ := path.Match(pattern, fqdn); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether list is empty or contains v, ignoring case
+func contains(list []string, v string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		if strings.EqualFold(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// inZone reports whether name is zone or a name below it. Both are
+// expected in lower case without a trailing dot.
+func inZone(name, zone string) bool {
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
@@ -0,0 +1,103 @@
+package auth
+
+import "testing"
+
+func TestScopeAllows(t *testing.T) {
+	certbot := Scope{
+		Zones:      []string{"example.com"},
+		Names:      []string{"_acme-challenge.example.com", "_acme-challenge.*.example.com"},
+		Types:      []string{"TXT"},
+		Operations: []string{"create", "delete"},
+	}
+	tests := []struct {
+		scope        Scope
+		op, fqdn, rr string
+		want         bool
+	}{
+		{Scope{}, OpCreate, "anything.example.org", "TXT", true},
+		{Scope{}, OpForce, "anything.example.org", "TXT", false},
+		{certbot, OpCreate, "_acme-challenge.example.com", "TXT", true},
+		{certbot, OpDelete, "_ACME-Challenge.Example.com.", "TXT", true},
+		{certbot, OpCreate, "_acme-challenge.a.b.example.com", "TXT", true},
+		{certbot, OpUpdate, "_acme-challenge.example.com", "TXT", false},
+		{certbot, OpForce, "_acme-challenge.example.com", "TXT", false},
+		{certbot, OpCreate, "_acme-challenge.example.com", "CNAME", false},
+		{certbot, OpCreate, "www.example.com", "TXT", false},
+		{certbot, OpCreate, "_acme-challenge.example.org", "TXT", false},
+		// A name glob does not reach outside the zones
+		{Scope{Zones: []string{"example.com"}, Names: []string{"_acme-challenge.*"}}, OpCreate, "_acme-challenge.example.org", "TXT", false},
+		// "example.com" does not cover "badexample.com"
+		{Scope{Zones: []string{"example.com"}}, OpCreate, "badexample.com", "TXT", false},
+		{Scope{Zones: []string{"example.com"}}, OpForce, "example.com", "TXT", false},
+		{Scope{Operations: []string{"Update", "FORCE"}}, OpForce, "example.com", "TXT", true},
+	}
+	for _, tt := range tests {
+		if got := tt.scope.Allows(tt.op, tt.fqdn, tt.rr); got != tt.want {
+			t.Errorf("%+v.Allows(%s, %s, %s) = %v, want %v", tt.scope, tt.op, tt.fqdn, tt.rr, got, tt.want)
+		}
+	}
+}
+
+func TestScopeAllowsZone(t *testing.T) {
+	tests := []struct {
+		scope    Scope
+		op, zone string
+		want     bool
+	}{
+		{Scope{}, OpList, "example.com", true},
+		{Scope{}, OpForce, "example.com", false},
+		{Scope{Zones: []string{"example.com"}}, OpList, "Example.COM.", true},
+		{Scope{Zones: []string{"example.com"}}, OpList, "sub.example.com", true},
+		{Scope{Zones: []string{"sub.example.com"}}, OpList, "example.com", false},
+		{Scope{Zones: []string{"example.com"}}, OpList, "example.org", false},
+		{Scope{Operations: []string{"create"}}, OpList, "example.com", false},
+	}
+	for _, tt := range tests {
+		if got := tt.scope.AllowsZone(tt.op, tt.zone); got != tt.want {
+			t.Errorf("%+v.AllowsZone(%s, %s) = %v, want %v", tt.scope, tt.op, tt.zone, got, tt.want)
+		}
+	}
+}
+
+func TestScopeAllowsOp(t *testing.T) {
+	tests := []struct {
+		scope Scope
+		op    string
+		want  bool
+	}{
+		{Scope{}, OpList, true},
+		{Scope{}, OpDelete, true},
+		// Privileged operations are never implied by an empty list
+		{Scope{}, OpForce, false},
+		{Scope{Zones: []string{"example.com"}, Names: []string{"*"}}, OpForce, false},
+		{Scope{Operations: []string{"delete"}}, OpForce, false},
+		{Scope{Operations: []string{"delete", "force"}}, OpForce, true},
+		{Scope{Operations: []string{"delete"}}, OpCreate, false},
+		{Scope{}, OpMetrics, false},
+		{Scope{Zones: []string{"example.com"}}, OpMetrics, false},
+		{Scope{Operations: []string{"metrics"}}, OpMetrics, true},
+	}
+	for _, tt := range tests {
+		if got := tt.scope.AllowsOp(tt.op); got != tt.want {
+			t.Errorf("%+v.AllowsOp(%s) = %v, want %v", tt.scope, tt.op, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultTokenIsPrivileged(t *testing.T) {
+	keyring, err := LoadTokens(map[string]string{"API_KEY": "secret", "token.plain": "plain-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for secret, want := range map[string]bool{"secret": true, "plain-secret": false} {
+		token, ok := keyring.Authenticate(secret)
+		if !ok {
+			t.Fatalf("%s not accepted", secret)
+		}
+		for _, op := range []string{OpForce, OpMetrics} {
+			if got := token.Scope.AllowsOp(op); got != want {
+				t.Errorf("token %s AllowsOp(%s) = %v, want %v", token.ID, op, got, want)
+			}
+		}
+	}
+}
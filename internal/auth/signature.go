@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"container/heap"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SignatureScheme is the Authorization scheme of signed requests:
+//
+//	Authorization: DNS-PROXY-HMAC-SHA256 key=<id>,ts=<unix>,nonce=<nonce>,sig=<base64>
+const SignatureScheme = "DNS-PROXY-HMAC-SHA256"
+
+// DefaultMaxSkew is how far a signed request's timestamp may be from the server clock
+const DefaultMaxSkew = 5 * time.Minute
+
+// DefaultNonceCacheSize bounds the number of nonces remembered at once
+const DefaultNonceCacheSize = 100000
+
+// Signature verification failures
+var (
+	ErrMalformedSignature = errors.New("malformed signature header")
+	ErrUnknownSigningKey  = errors.New("unknown signing key")
+	ErrBadSignature       = errors.New("signature mismatch")
+	ErrStaleTimestamp     = errors.New("timestamp outside the permitted window")
+	ErrReplayedNonce      = errors.New("nonce already used")
+	ErrNonceCacheFull     = errors.New("too many recent signed requests")
+)
+
+// StringToSign returns the canonical form of a request covered by the
+// signature. uri is the escaped path and query, as in http.Request.RequestURI.
+func StringToSign(method, uri string, body []byte, timestamp int64, nonce string) string {
+	sum := sha256.Sum256(body)
+	return strings.Join([]string{
+		SignatureScheme,
+		strings.ToUpper(method),
+		uri,
+		hex.EncodeToString(sum[:]),
+		strconv.FormatInt(timestamp, 10),
+		nonce,
+	}, "\n")
+}
+
+// Sign returns the base64 HMAC-SHA256 of the string to sign
+func Sign(secret []byte, stringToSign string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignatureHeader formats the Authorization header value of a signed request
+func SignatureHeader(keyID string, timestamp int64, nonce, signature string) string {
+	return fmt.Sprintf("%s key=%s,ts=%d,nonce=%s,sig=%s", SignatureScheme, keyID, timestamp, nonce, signature)
+}
+
+// signatureParams is the parsed Authorization header of a signed request
+type signatureParams struct {
+	keyID     string
+	timestamp int64
+	nonce     string
+	signature []byte
+}
+
+func parseSignatureHeader(header string) (*signatureParams, error) {
+	rest, ok := strings.CutPrefix(header, SignatureScheme+" ")
+	if !ok {
+		return nil, ErrMalformedSignature
+	}
+	var p signatureParams
+	var err error
+	for _, field := range strings.Split(rest, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, ErrMalformedSignature
+		}
+		switch k {
+		case "key":
+			p.keyID = v
+		case "ts":
+			if p.timestamp, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return nil, ErrMalformedSignature
+			}
+		case "nonce":
+			p.nonce = v
+		case "sig":
+			if p.signature, err = base64.StdEncoding.DecodeString(v); err != nil {
+				return nil, ErrMalformedSignature
+			}
+		}
+	}
+	if p.keyID == "" || p.timestamp == 0 || p.nonce == "" || len(p.signature) == 0 || len(p.nonce) > 128 {
+		return nil, ErrMalformedSignature
+	}
+	return &p, nil
+}
+
+// IsSigned reports whether an Authorization header uses the signature scheme
+func IsSigned(header string) bool {
+	return strings.HasPrefix(header, SignatureScheme+" ")
+}
+
+// Verifier checks signed requests against the signing keys of a keyring
+type Verifier struct {
+	Keyring *Keyring
+	MaxSkew time.Duration
+	Nonces  *NonceCache
+}
+
+// NewVerifier returns a verifier with the default skew and nonce cache size
+func NewVerifier(keyring *Keyring) *Verifier {
+	return &Verifier{
+		Keyring: keyring,
+		MaxSkew: DefaultMaxSkew,
+		Nonces:  NewNonceCache(DefaultNonceCacheSize),
+	}
+}
+
+// Verify checks the signature in header over the request and returns the
+// token whose signing key produced it. The nonce is only remembered once
+// the signature is valid, so forged requests cannot fill the cache.
+func (v *Verifier) Verify(header, method, uri string, body []byte, now time.Time) (*Token, error) {
+	p, err := parseSignatureHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	token, ok := v.Keyring.signingToken(p.keyID)
+	if !ok {
+		return nil, ErrUnknownSigningKey
+	}
+	mac := hmac.New(sha256.New, token.SigningKey)
+	mac.Write([]byte(StringToSign(method, uri, body, p.timestamp, p.nonce)))
+	if !hmac.Equal(mac.Sum(nil), p.signature) {
+		return nil, ErrBadSignature
+	}
+
+	signed := time.Unix(p.timestamp, 0)
+	if signed.Before(now.Add(-v.MaxSkew)) || signed.After(now.Add(v.MaxSkew)) {
+		return nil, ErrStaleTimestamp
+	}
+	// A nonce only has to be remembered while its timestamp is acceptable
+	if err := v.Nonces.Add(p.keyID+"/"+p.nonce, signed.Add(v.MaxSkew), now); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// NonceCache remembers recently used nonces until they expire. It holds at
+// most a fixed number of entries; when full, new nonces are refused rather
+// than evicting live ones, which would allow replays.
+type NonceCache struct {
+	mu     sync.Mutex
+	max    int
+	seen   map[string]time.Time
+	expiry nonceHeap // the soonest expiry first
+}
+
+type nonceEntry struct {
+	nonce   string
+	expires time.Time
+}
+
+// nonceHeap implements heap.Interface ordered by expiry. Expiries come from
+// client timestamps, so they are not in insertion order.
+type nonceHeap []nonceEntry
+
+func (h nonceHeap) Len() int            { return len(h) }
+func (h nonceHeap) Less(i, j int) bool  { return h[i].expires.Before(h[j].expires) }
+func (h nonceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nonceHeap) Push(x interface{}) { *h = append(*h, x.(nonceEntry)) }
+func (h *nonceHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// NewNonceCache returns a cache holding at most max nonces
+func NewNonceCache(max int) *NonceCache {
+	return &NonceCache{max: max, seen: make(map[string]time.Time)}
+}
+
+// Add records nonce until expires. It fails if the nonce is already known
+// or the cache is full of unexpired nonces.
+func (c *NonceCache) Add(nonce string, expires, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Drop every expired entry, whatever order they were added in
+	for len(c.expiry) > 0 && !now.Before(c.expiry[0].expires) {
+		e := heap.Pop(&c.expiry).(nonceEntry)
+		if c.seen[e.nonce] == e.expires {
+			delete(c.seen, e.nonce)
+		}
+	}
+
+	if exp, ok := c.seen[nonce]; ok && now.Before(exp) {
+		return ErrReplayedNonce
+	}
+	if len(c.seen) >= c.max {
+		return ErrNonceCacheFull
+	}
+	c.seen[nonce] = expires
+	heap.Push(&c.expiry, nonceEntry{nonce: nonce, expires: expires})
+	return nil
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStringToSign(t *testing.T) {
+	got := StringToSign("post", "/v1/zones/example.com/txt?force=true", []byte(`{"name":"x"}`), 1700000000, "n1")
+	want := "DNS-PROXY-HMAC-SHA256\n" +
+		"POST\n" +
+		"/v1/zones/example.com/txt?force=true\n" +
+		"0229d37e33daae149bf40543a5ce1db4459d10f830d5139279aa2bfd5f6485a1\n" +
+		"1700000000\n" +
+		"n1"
+	if got != want {
+		t.Errorf("StringToSign = %q, want %q", got, want)
+	}
+}
+
+// signedHeader returns the Authorization header a client with secret sends
+func signedHeader(keyID string, secret []byte, method, uri, body string, ts int64, nonce string) string {
+	sig := Sign(secret, StringToSign(method, uri, []byte(body), ts, nonce))
+	return SignatureHeader(keyID, ts, nonce, sig)
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("0123456789abcdef0123")
+	keyring, err := LoadTokens(map[string]string{"token.ci.signing_key": string(secret)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	v := NewVerifier(keyring)
+	const uri, body = "/v1/zones/example.com/txt", `{"name":"_acme-challenge","value":"v"}`
+
+	header := signedHeader("ci", secret, "POST", uri, body, now.Unix(), "n1")
+	token, err := v.Verify(header, "POST", uri, []byte(body), now)
+	if err != nil || token.ID != "ci" {
+		t.Fatalf("Verify = %v, %v, want token ci", token, err)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		method string
+		uri    string
+		body   string
+		want   error
+	}{
+		{"replay", header, "POST", uri, body, ErrReplayedNonce},
+		{"other body", signedHeader("ci", secret, "POST", uri, body, now.Unix(), "n2"), "POST", uri, body + " ", ErrBadSignature},
+		{"other method", signedHeader("ci", secret, "POST", uri, body, now.Unix(), "n3"), "DELETE", uri, body, ErrBadSignature},
+		{"other query", signedHeader("ci", secret, "POST", uri, body, now.Unix(), "n4"), "POST", uri + "?force=true", body, ErrBadSignature},
+		{"wrong secret", signedHeader("ci", []byte("another secret 1234"), "POST", uri, body, now.Unix(), "n5"), "POST", uri, body, ErrBadSignature},
+		{"unknown key", signedHeader("other", secret, "POST", uri, body, now.Unix(), "n6"), "POST", uri, body, ErrUnknownSigningKey},
+		{"too old", signedHeader("ci", secret, "POST", uri, body, now.Add(-DefaultMaxSkew-time.Second).Unix(), "n7"), "POST", uri, body, ErrStaleTimestamp},
+		{"too new", signedHeader("ci", secret, "POST", uri, body, now.Add(DefaultMaxSkew+time.Second).Unix(), "n8"), "POST", uri, body, ErrStaleTimestamp},
+		{"edge of the window", signedHeader("ci", secret, "POST", uri, body, now.Add(-DefaultMaxSkew).Unix(), "n9"), "POST", uri, body, nil},
+		{"no nonce", "DNS-PROXY-HMAC-SHA256 key=ci,ts=1700000000,sig=AAAA", "POST", uri, body, ErrMalformedSignature},
+		{"bearer", "Bearer secret", "POST", uri, body, ErrMalformedSignature},
+	}
+	for _, tt := range tests {
+		if _, err := v.Verify(tt.header, tt.method, tt.uri, []byte(tt.body), now); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// A forged request does not use up the nonce
+	forged := signedHeader("ci", []byte("another secret 1234"), "POST", uri, body, now.Unix(), "fresh")
+	if _, err := v.Verify(forged, "POST", uri, []byte(body), now); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("forged: err = %v", err)
+	}
+	if _, err := v.Verify(signedHeader("ci", secret, "POST", uri, body, now.Unix(), "fresh"), "POST", uri, []byte(body), now); err != nil {
+		t.Errorf("nonce of a forged request refused afterwards: %v", err)
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := NewNonceCache(3)
+
+	if err := c.Add("a", now.Add(10*time.Minute), now); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Add("a", now.Add(10*time.Minute), now.Add(time.Minute)); !errors.Is(err, ErrReplayedNonce) {
+		t.Errorf("second a: err = %v, want ErrReplayedNonce", err)
+	}
+	if err := c.Add("b", now.Add(time.Minute), now); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Add("c", now.Add(2*time.Minute), now); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Add("d", now.Add(time.Minute), now); !errors.Is(err, ErrNonceCacheFull) {
+		t.Errorf("d in a full cache: err = %v, want ErrNonceCacheFull", err)
+	}
+
+	// b and c expired although a, added before them, has not
+	later := now.Add(3 * time.Minute)
+	for _, nonce := range []string{"d", "e"} {
+		if err := c.Add(nonce, later.Add(time.Minute), later); err != nil {
+			t.Errorf("%s after b and c expired: %v", nonce, err)
+		}
+	}
+	if err := c.Add("a", later.Add(time.Minute), later); !errors.Is(err, ErrReplayedNonce) {
+		t.Errorf("a is still live: err = %v, want ErrReplayedNonce", err)
+	}
+
+	// An expired nonce may be used again
+	if err := c.Add("d", later.Add(5*time.Minute), later.Add(2*time.Minute)); err != nil {
+		t.Errorf("d after it expired: %v", err)
+	}
+}
+
+func TestNonceCacheBound(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := NewNonceCache(100)
+	// Live nonces with unordered expiries fill the cache exactly to its bound
+	for i := 0; i < 100; i++ {
+		if err := c.Add(fmt.Sprint(i), now.Add(time.Duration(100-i)*time.Second), now); err != nil {
+			t.Fatalf("nonce %d: %v", i, err)
+		}
+	}
+	if err := c.Add("full", now.Add(time.Hour), now); !errors.Is(err, ErrNonceCacheFull) {
+		t.Fatalf("err = %v, want ErrNonceCacheFull", err)
+	}
+	// Half of them expire, making room for as many new ones
+	half := now.Add(50 * time.Second)
+	for i := 0; i < 50; i++ {
+		if err := c.Add(fmt.Sprint("new", i), half.Add(time.Hour), half); err != nil {
+			t.Fatalf("new nonce %d: %v", i, err)
+		}
+	}
+	if err := c.Add("full", half.Add(time.Hour), half); !errors.Is(err, ErrNonceCacheFull) {
+		t.Errorf("err = %v, want ErrNonceCacheFull", err)
+	}
+	if len(c.seen) != 100 || len(c.expiry) != 100 {
+		t.Errorf("cache holds %d nonces and %d expiries, want 100", len(c.seen), len(c.expiry))
+	}
+}
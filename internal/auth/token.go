@@ -0,0 +1,265 @@
+// Package auth holds the API tokens of dns-proxy-api and the scopes that
+// limit which records each token may change.
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"dns-proxy/internal/idn"
+	"dns-proxy/internal/validate"
+)
+
+// DefaultTokenID is the ID of the unrestricted token configured by API_KEY
+const DefaultTokenID = "default"
+
+// Token is an API bearer token and what it may be used for. A token can
+// have several credentials so its secret can be rotated without downtime.
+type Token struct {
+	ID          string
+	Credentials []*Credential
+	SigningKey  []byte   // shared secret for signed requests, if any
+	ClientCerts []string // client certificate identities, see CertIdentities
+	Scope       Scope
+}
+
+// Keyring is the set of configured tokens
+type Keyring struct {
+	pepper string
+	tokens []*Token
+}
+
+// NewKeyring returns a keyring holding tokens whose credentials were hashed with pepper
+func NewKeyring(pepper string, tokens ...*Token) *Keyring {
+	return &Keyring{pepper: pepper, tokens: tokens}
+}
+
+// Len returns the number of configured tokens
+func (k *Keyring) Len() int {
+	return len(k.tokens)
+}
+
+// Authenticate returns the token that has an unexpired credential matching
+// secret. Every credential is checked so the timing does not reveal which
+// one matched.
+func (k *Keyring) Authenticate(secret string) (*Token, bool) {
+	now := time.Now()
+	var found *Token
+	for _, t := range k.tokens {
+		for _, c := range t.Credentials {
+			if c.matches(k.pepper, secret) && !c.Expired(now) {
+				found = t
+			}
+		}
+	}
+	return found, found != nil
+}
+
+// signingToken returns the token with ID id if it has a signing key
+func (k *Keyring) signingToken(id string) (*Token, bool) {
+	for _, t := range k.tokens {
+		if t.ID == id && len(t.SigningKey) > 0 {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// LoadTokens builds the keyring from the API configuration. API_KEY is an
+// unrestricted token with ID "default"; scoped tokens are configured as
+//
+//	token.<id>=<credential>[,<credential>...]
+//	token.<id>.zones=example.com,example.org
+//	token.<id>.names=_acme-challenge.*.example.com
+//	token.<id>.types=TXT
+//	token.<id>.operations=create,delete
+//	token.<id>.signing_key=<shared secret for signed requests>
+//	token.<id>.client_cert=dns:certbot.internal,cn:certbot
+//
+// A token needs at least one of a credential, a signing key or a client
+// certificate identity. Credentials are normally hashes generated by `dns-proxy-api token hash`,
+// optionally peppered with token_pepper. Plaintext secrets are still
+// accepted, hashed on load and reported in the log.
+func LoadTokens(cfg map[string]string) (*Keyring, error) {
+	pepper := cfg["token_pepper"]
+	byID := make(map[string]*Token)
+	if key := cfg["API_KEY"]; key != "" {
+		creds, err := parseCredentials("API_KEY", pepper, key)
+		if err != nil {
+			return nil, err
+		}
+		// Listing every operation also grants the privileged ones
+		byID[DefaultTokenID] = &Token{ID: DefaultTokenID, Credentials: creds, Scope: Scope{Operations: operations}}
+	}
+
+	// Secrets first, so scope lines can be checked against known tokens
+	for k, v := range cfg {
+		rest, ok := strings.CutPrefix(k, "token.")
+		if !ok {
+			continue
+		}
+		id, field, _ := strings.Cut(rest, ".")
+		if field != "" && field != "signing_key" && field != "client_cert" {
+			continue
+		}
+		if id == "" || id == DefaultTokenID || strings.ContainsAny(id, ",= ") {
+			return nil, fmt.Errorf("%s: invalid token ID", k)
+		}
+		t, ok := byID[id]
+		if !ok {
+			t = &Token{ID: id}
+			byID[id] = t
+		}
+		if field == "client_cert" {
+			for _, id := range strings.Split(v, ",") {
+				id, err := parseCertIdentity(k, strings.TrimSpace(id))
+				if err != nil {
+					return nil, err
+				}
+				t.ClientCerts = append(t.ClientCerts, id)
+			}
+			continue
+		}
+		if field == "signing_key" {
+			if len(v) < 16 {
+				return nil, fmt.Errorf("%s: must be at least 16 characters", k)
+			}
+			t.SigningKey = []byte(v)
+			continue
+		}
+		creds, err := parseCredentials(k, pepper, v)
+		if err != nil {
+			return nil, err
+		}
+		t.Credentials = creds
+	}
+
+	for k, v := range cfg {
+		rest, ok := strings.CutPrefix(k, "token.")
+		if !ok || !strings.Contains(rest, ".") {
+			continue
+		}
+		id, field, _ := strings.Cut(rest, ".")
+		if field == "signing_key" || field == "client_cert" {
+			continue
+		}
+		t, ok := byID[id]
+		if !ok || id == DefaultTokenID {
+			return nil, fmt.Errorf("%s: no secret configured for token %q", k, id)
+		}
+		values, err := ParseScopeList(k, field, v)
+		if err != nil {
+			return nil, err
+		}
+		switch field {
+		case "zones":
+			t.Scope.Zones = values
+		case "names":
+			t.Scope.Names = values
+		case "types":
+			t.Scope.Types = values
+		case "operations":
+			t.Scope.Operations = values
+		}
+	}
+
+	ids := make([]string, 0, len(byID))
+	for id := range byID {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	tokens := make([]*Token, 0, len(ids))
+	for _, id := range ids {
+		tokens = append(tokens, byID[id])
+	}
+	return NewKeyring(pepper, tokens...), nil
+}
+
+// parseCredentials parses the comma separated credentials of a token
+func parseCredentials(key, pepper, value string) ([]*Credential, error) {
+	var creds []*Credential
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !isHashed(v) {
+			log.Printf("%s holds a plaintext secret, replace it with the output of `dns-proxy-api token hash`", key)
+			c, err := NewCredential(pepper, v, time.Time{})
+			if err != nil {
+				return nil, err
+			}
+			creds = append(creds, c)
+			continue
+		}
+		c, err := ParseCredential(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		creds = append(creds, c)
+	}
+	if len(creds) == 0 {
+		return nil, fmt.Errorf("%s: secret is empty", key)
+	}
+	return creds, nil
+}
+
+// ParseScopeList parses a comma separated scope line, of the field
+// "zones", "names", "types" or "operations", and validates each entry
+func ParseScopeList(key, field, value string) ([]string, error) {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		switch field {
+		case "zones":
+			zone, err := idn.ToASCII(strings.TrimSuffix(v, "."))
+			if err == nil {
+				err = validate.Hostname(key, zone)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			v = zone
+		case "names":
+			v = strings.ToLower(strings.TrimSuffix(v, "."))
+			if _, err := path.Match(v, ""); err != nil {
+				return nil, fmt.Errorf("%s: invalid pattern %q", key, v)
+			}
+		case "types":
+			v = strings.ToUpper(v)
+		case "operations":
+			v = strings.ToLower(v)
+			if !contains(operations, v) {
+				return nil, fmt.Errorf("%s: unknown operation %q, expected one of %s", key, v, strings.Join(operations, ", "))
+			}
+		default:
+			return nil, fmt.Errorf("%s: unknown token setting %q", key, field)
+		}
+		values = append(values, v)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%s: must list at least one value", key)
+	}
+	return values, nil
+}
+
+type tokenKey struct{}
+
+// WithToken returns a copy of ctx carrying the authenticated token
+func WithToken(ctx context.Context, t *Token) context.Context {
+	return context.WithValue(ctx, tokenKey{}, t)
+}
+
+// TokenFromContext returns the token stored by WithToken
+func TokenFromContext(ctx context.Context) (*Token, bool) {
+	t, ok := ctx.Value(tokenKey{}).(*Token)
+	return t, ok
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadTokens(t *testing.T) {
+	keyring, err := LoadTokens(map[string]string{
+		"API_KEY":                  "admin-secret",
+		"token.certbot":            "certbot-secret",
+		"token.certbot.zones":      "Example.com., bücher.de",
+		"token.certbot.names":      "_ACME-Challenge.*.example.com.",
+		"token.certbot.types":      "txt",
+		"token.certbot.operations": "Create, delete",
+		"token.reader":             "reader-secret",
+		"token.reader.operations":  "list",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyring.Len() != 3 {
+		t.Fatalf("keyring holds %d tokens, want 3", keyring.Len())
+	}
+
+	token, ok := keyring.Authenticate("certbot-secret")
+	if !ok || token.ID != "certbot" {
+		t.Fatalf("Authenticate(certbot-secret) = %v, %v", token, ok)
+	}
+	want := Scope{
+		Zones:      []string{"example.com", "xn--bcher-kva.de"},
+		Names:      []string{"_acme-challenge.*.example.com"},
+		Types:      []string{"TXT"},
+		Operations: []string{"create", "delete"},
+	}
+	if !reflect.DeepEqual(token.Scope, want) {
+		t.Errorf("certbot scope %+v, want %+v", token.Scope, want)
+	}
+
+	if token, ok := keyring.Authenticate("admin-secret"); !ok || token.ID != DefaultTokenID {
+		t.Errorf("Authenticate(admin-secret) = %v, %v, want the default token", token, ok)
+	}
+	for _, secret := range []string{"", "wrong", "certbot-secret "} {
+		if token, ok := keyring.Authenticate(secret); ok {
+			t.Errorf("Authenticate(%q) = %s", secret, token.ID)
+		}
+	}
+
+	ctx := WithToken(context.Background(), token)
+	if got, ok := TokenFromContext(ctx); !ok || got != token {
+		t.Errorf("TokenFromContext = %v, %v", got, ok)
+	}
+	if _, ok := TokenFromContext(context.Background()); ok {
+		t.Error("TokenFromContext found a token in an empty context")
+	}
+}
+
+func TestLoadTokensRejects(t *testing.T) {
+	tests := []struct {
+		cfg  map[string]string
+		want string
+	}{
+		{map[string]string{"token.a b": "secret"}, "invalid token ID"},
+		{map[string]string{"token.default": "secret"}, "invalid token ID"},
+		{map[string]string{"token.certbot.zones": "example.com"}, `no secret configured for token "certbot"`},
+		{map[string]string{"API_KEY": "secret", "token.default.zones": "example.com"}, `no secret configured for token "default"`},
+		{map[string]string{"token.certbot": "secret", "token.certbot.zone": "example.com"}, `unknown token setting "zone"`},
+		{map[string]string{"token.certbot": "secret", "token.certbot.operations": "create,purge"}, `unknown operation "purge"`},
+		{map[string]string{"token.certbot": "secret", "token.certbot.zones": " , "}, "must list at least one value"},
+		{map[string]string{"token.certbot": "secret", "token.certbot.zones": "localhost"}, "must be a fully qualified domain name"},
+		{map[string]string{"token.certbot": "secret", "token.certbot.names": "[a"}, "invalid pattern"},
+		{map[string]string{"token.certbot": " , "}, "secret is empty"},
+		{map[string]string{"token.ci.signing_key": "short"}, "at least 16 characters"},
+	}
+	for _, tt := range tests {
+		if _, err := LoadTokens(tt.cfg); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("LoadTokens(%v) = %v, want %q", tt.cfg, err, tt.want)
+		}
+	}
+}
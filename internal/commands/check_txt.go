@@ -0,0 +1,137 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/dnsclient"
+)
+
+// checkTimeout bounds all cPanel and DNS queries made by a single check-txt run
+const checkTimeout = 30 * time.Second
+
+// CheckTxtCommand implements the check-txt command. It compares the values
+// cPanel holds for a name with what the authoritative nameservers (and
+// optionally a recursive resolver) actually serve.
+type CheckTxtCommand struct{}
+
+func (c *CheckTxtCommand) ValidateArgs(args map[string]string) error {
+	if err := validateRecordArgs(args); err != nil {
+		return err
+	}
+	return ValidatePort("--nameserver-port", args["nameserver-port"])
+}
+
+func (c *CheckTxtCommand) Execute(cpCfg *cpanel.CPanelConfig, args map[string]string) error {
+	domain := args["domain"]
+	key := args["key"]
+
+	zone, name := cpanel.RecordName(domain, key)
+	fqdn := dnsclient.Fqdn(name + "." + zone)
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	records, err := cpCfg.ListTxtRecords(ctx, domain, key)
+	if err != nil {
+		return fmt.Errorf("failed to list TXT records: %w", err)
+	}
+	var expected []string
+	for _, record := range records {
+		if strings.EqualFold(record.Name, fqdn) {
+			expected = append(expected, record.Value)
+		}
+	}
+
+	fmt.Printf("cPanel zone '%s', name '%s': %d value(s)\n", zone, fqdn, len(expected))
+	for _, value := range expected {
+		fmt.Printf("  %q\n", value)
+	}
+
+	client := newDNSClient(args)
+	servers, err := client.Nameservers(ctx, zone)
+	if err != nil {
+		return fmt.Errorf("failed to find nameservers for %s: %w", zone, err)
+	}
+
+	inSync := true
+	fmt.Println("Authoritative nameservers:")
+	for _, ns := range servers {
+		if ns.Err != nil {
+			fmt.Printf("  %s  SKIPPED: %v\n", strings.TrimSuffix(ns.Host, "."), ns.Err)
+			continue
+		}
+		label := fmt.Sprintf("%s (%s)", strings.TrimSuffix(ns.Host, "."), ns.Addr)
+		if !reportServer(ctx, client, label, ns.Addr, zone, fqdn, expected, false) {
+			inSync = false
+		}
+	}
+
+	if args["recursive"] == "true" {
+		fmt.Println("Recursive resolver:")
+		if !reportServer(ctx, client, client.Resolver, client.Resolver, zone, fqdn, expected, true) {
+			inSync = false
+		}
+	}
+
+	if !inSync {
+		return errors.New("live DNS answers do not match cPanel")
+	}
+	fmt.Println("All servers match cPanel.")
+	return nil
+}
+
+// reportServer prints what server answers for fqdn and reports whether it matches expected
+func reportServer(ctx context.Context, client *dnsclient.Client, label, server, zone, fqdn string, expected []string, recursion bool) bool {
+	serial := "?"
+	if soa, err := client.LookupSOA(ctx, server, zone, recursion); err == nil {
+		serial = fmt.Sprint(soa.Serial)
+	}
+
+	answer, err := client.LookupTXT(ctx, server, fqdn, recursion)
+	if err != nil {
+		fmt.Printf("  %s  serial %s  ERROR: %v\n", label, serial, err)
+		return false
+	}
+
+	fmt.Printf("  %s  serial %s  %s  TTL %d\n", label, serial, dnsclient.RcodeString(answer.Rcode), answer.TTL)
+	if !recursion && !answer.Authoritative {
+		fmt.Println("    WARNING: answer is not authoritative")
+	}
+	for _, value := range answer.Values {
+		fmt.Printf("    %q\n", value)
+	}
+
+	missing, extra := diffValues(expected, answer.Values)
+	for _, value := range missing {
+		fmt.Printf("    MISSING: %q\n", value)
+	}
+	for _, value := range extra {
+		fmt.Printf("    EXTRA:   %q\n", value)
+	}
+	return len(missing) == 0 && len(extra) == 0
+}
+
+// diffValues returns the expected values absent from actual, and the actual values not expected
+func diffValues(expected, actual []string) (missing, extra []string) {
+	for _, value := range expected {
+		if !slices.Contains(actual, value) {
+			missing = append(missing, value)
+		}
+	}
+	for _, value := range actual {
+		if !slices.Contains(expected, value) {
+			extra = append(extra, value)
+		}
+	}
+	return missing, extra
+}
+
+func (c *CheckTxtCommand) Usage() string {
+	return "check-txt --domain <domain> --key <key> [--recursive] [--resolver <host:port>] [--nameserver-port <port>]"
+}
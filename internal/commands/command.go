@@ -1,6 +1,10 @@
 package commands
 
-import "dns-proxy/internal/cpanel"
+import (
+	"context"
+
+	"dns-proxy/internal/cpanel"
+)
 
 // Command represents a DNS operation command
 type Command interface {
@@ -31,11 +35,24 @@ func (f *DefaultCommandFactory) CreateCommand(name string) (Command, error) {
 		return &EditTxtCommand{}, nil
 	case "list-txt":
 		return &ListTxtCommand{}, nil
+	case "check-txt":
+		return &CheckTxtCommand{}, nil
+	case "gc":
+		return &GCCommand{}, nil
 	default:
 		return nil, &UnknownCommandError{Command: name}
 	}
 }
 
+// commandContext returns the context for a command's cPanel calls, marked
+// to skip the ownership check if --force was given
+func commandContext(args map[string]string) context.Context {
+	if args["force"] == "true" {
+		return cpanel.WithForce(context.Background())
+	}
+	return context.Background()
+}
+
 // UnknownCommandError represents an error for unknown commands
 type UnknownCommandError struct {
 	Command string
@@ -1,7 +1,6 @@
 package commands
 
 import (
-	"errors"
 	"fmt"
 
 	"dns-proxy/internal/cpanel"
@@ -15,28 +14,29 @@ func (c *DeleteTxtCommand) Execute(cpCfg *cpanel.CPanelConfig, args map[string]s
 	key := args["key"]
 	value := args["value"]
 
-	err := cpCfg.DeleteTxtRecord(domain, key, value)
+	err := cpCfg.DeleteTxtRecord(commandContext(args), domain, key, value)
 	if err != nil {
 		return fmt.Errorf("failed to delete TXT record: %w", err)
 	}
 
 	fmt.Println("TXT record deleted successfully.")
+
+	if waitRequested(args) {
+		return waitForPropagation(args, domain, key, value, false)
+	}
 	return nil
 }
 
 func (c *DeleteTxtCommand) ValidateArgs(args map[string]string) error {
-	if args["domain"] == "" {
-		return errors.New("--domain is required")
+	if err := validateRecordArgs(args); err != nil {
+		return err
 	}
-	if args["key"] == "" {
-		return errors.New("--key is required")
+	if err := validateValueArgs(args, "value"); err != nil {
+		return err
 	}
-	if args["value"] == "" {
-		return errors.New("--value is required")
-	}
-	return nil
+	return validateWaitArgs(args)
 }
 
 func (c *DeleteTxtCommand) Usage() string {
-	return "delete-txt --domain <domain> --key <key> --value <value>"
+	return "delete-txt --domain <domain> --key <key> --value <value> [--force] [--wait] [--wait-timeout <duration>] [--resolver <host:port>] [--nameserver-port <port>]"
 }
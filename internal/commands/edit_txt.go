@@ -2,7 +2,6 @@ package commands
 
 import (
 	"dns-proxy/internal/cpanel"
-	"errors"
 )
 
 // EditTxtCommand implements the edit-txt command
@@ -14,25 +13,16 @@ func (c *EditTxtCommand) Execute(cpCfg *cpanel.CPanelConfig, args map[string]str
 	oldValue := args["old-value"]
 	newValue := args["new-value"]
 
-	return cpCfg.EditTxtRecord(domain, key, oldValue, newValue)
+	return cpCfg.EditTxtRecord(commandContext(args), domain, key, oldValue, newValue)
 }
 
 func (c *EditTxtCommand) ValidateArgs(args map[string]string) error {
-	if args["domain"] == "" {
-		return errors.New("domain is required")
+	if err := validateRecordArgs(args); err != nil {
+		return err
 	}
-	if args["key"] == "" {
-		return errors.New("key is required")
-	}
-	if args["old-value"] == "" {
-		return errors.New("old-value is required")
-	}
-	if args["new-value"] == "" {
-		return errors.New("new-value is required")
-	}
-	return nil
+	return validateValueArgs(args, "old-value", "new-value")
 }
 
 func (c *EditTxtCommand) Usage() string {
-	return "edit-txt --domain <domain> --key <key> --old-value <old-value> --new-value <new-value>"
+	return "edit-txt --domain <domain> --key <key> --old-value <old-value> --new-value <new-value> [--force]"
 }
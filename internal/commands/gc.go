@@ -0,0 +1,50 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+
+	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/leases"
+)
+
+// GCCommand implements the gc command. It deletes the records whose
+// leases have expired, for setups where the API daemon's janitor is not
+// running. With an owner registry, records the proxy does not own are
+// only deleted with --force.
+type GCCommand struct{}
+
+func (c *GCCommand) ValidateArgs(args map[string]string) error {
+	return nil
+}
+
+func (c *GCCommand) Execute(cpCfg *cpanel.CPanelConfig, args map[string]string) error {
+	if args["lease-file"] == "" {
+		return errors.New("gc needs lease_file in the config")
+	}
+	store, err := leases.Open(args["lease-file"])
+	if err != nil {
+		return err
+	}
+
+	dryRun := args["dry-run"] == "true"
+	expired, err := leases.NewJanitor(store, cpCfg, 0).Collect(commandContext(args), dryRun)
+	for _, l := range expired {
+		if dryRun {
+			fmt.Printf("Would delete %s %q (expired %s)\n", l.Name, l.Value, l.ExpiresAt.Local().Format("2006-01-02 15:04:05"))
+		} else {
+			fmt.Printf("Deleted %s %q\n", l.Name, l.Value)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(expired) == 0 {
+		fmt.Println("No expired records.")
+	}
+	return nil
+}
+
+func (c *GCCommand) Usage() string {
+	return "gc [--dry-run] [--force]"
+}
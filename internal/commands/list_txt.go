@@ -1,15 +1,23 @@
 package commands
 
 import (
+	"context"
 	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/idn"
+	"dns-proxy/internal/validate"
 	"fmt"
 )
 
 type ListTxtCommand struct{}
 
 func (c *ListTxtCommand) ValidateArgs(args map[string]string) error {
-	if args["domain"] == "" {
-		return fmt.Errorf("domain is required")
+	if err := validate.Hostname("domain", args["domain"]); err != nil {
+		return flagError(err)
+	}
+	if args["key"] != "" {
+		if err := validate.OwnerName("key", args["key"]); err != nil {
+			return flagError(err)
+		}
 	}
 	return nil
 }
@@ -18,7 +26,7 @@ func (c *ListTxtCommand) Execute(cpCfg *cpanel.CPanelConfig, args map[string]str
 	domain := args["domain"]
 	key := args["key"] // Optional - if provided, filter by key
 
-	records, err := cpCfg.ListTxtRecords(domain, key)
+	records, err := cpCfg.ListTxtRecords(context.Background(), domain, key)
 	if err != nil {
 		return fmt.Errorf("failed to list TXT records: %w", err)
 	}
@@ -32,16 +40,34 @@ func (c *ListTxtCommand) Execute(cpCfg *cpanel.CPanelConfig, args map[string]str
 		return nil
 	}
 
-	fmt.Printf("TXT records for domain '%s':\n", domain)
+	// Show U-labels instead of A-labels on request
+	display := func(name string) string { return name }
+	if args["unicode"] == "true" {
+		display = idn.ToUnicode
+	}
+
+	fmt.Printf("TXT records for domain '%s':\n", display(domain))
 	for _, record := range records {
 		if key == "" || record.Key == key {
-			fmt.Printf("  Line: %-3d | Key: %-30s | Value: %s\n", record.Line, record.Key, record.Value)
+			if record.Owned != nil {
+				fmt.Printf("  Line: %-3d | Owner: %-8s | Key: %-30s | Value: %s\n", record.Line, owner(record), display(record.Key), record.Value)
+			} else {
+				fmt.Printf("  Line: %-3d | Key: %-30s | Value: %s\n", record.Line, display(record.Key), record.Value)
+			}
 		}
 	}
 
 	return nil
 }
 
+// owner describes who manages a record in the ownership column
+func owner(record cpanel.TxtRecord) string {
+	if *record.Owned {
+		return "proxy"
+	}
+	return "-"
+}
+
 func (c *ListTxtCommand) Usage() string {
-	return "list-txt --domain <domain> [--key <key>]"
+	return "list-txt --domain <domain> [--key <key>] [--unicode]"
 }
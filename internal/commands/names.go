@@ -0,0 +1,27 @@
+package commands
+
+import (
+	"fmt"
+
+	"dns-proxy/internal/idn"
+)
+
+// nameArgs are the arguments holding domain or owner names
+var nameArgs = []string{"domain", "key"}
+
+// NormalizeArgs converts the domain and key arguments to their A-label form
+// so that they are sent to cPanel and matched against zone data in ASCII.
+func NormalizeArgs(args map[string]string) error {
+	for _, name := range nameArgs {
+		value, ok := args[name]
+		if !ok || value == "" {
+			continue
+		}
+		ascii, err := idn.ToASCII(value)
+		if err != nil {
+			return fmt.Errorf("--%s: %w", name, err)
+		}
+		args[name] = ascii
+	}
+	return nil
+}
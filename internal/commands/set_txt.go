@@ -1,10 +1,13 @@
 package commands
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/leases"
 )
 
 // SetTxtCommand implements the set-txt command
@@ -15,28 +18,46 @@ func (c *SetTxtCommand) Execute(cpCfg *cpanel.CPanelConfig, args map[string]stri
 	key := args["key"]
 	value := args["value"]
 
-	err := cpCfg.CreateTxtRecord(domain, key, value)
+	ctx := context.Background()
+	if args["expires-in"] != "" {
+		if args["lease-file"] == "" {
+			return errors.New("--expires-in needs lease_file in the config")
+		}
+		ttl, _ := time.ParseDuration(args["expires-in"])
+		ctx = leases.WithTTL(ctx, ttl)
+	}
+
+	err := cpCfg.CreateTxtRecord(ctx, domain, key, value)
 	if err != nil {
 		return fmt.Errorf("failed to set TXT record: %w", err)
 	}
 
 	fmt.Println("TXT record set successfully.")
+	if args["expires-in"] != "" {
+		fmt.Printf("The record expires in %s.\n", args["expires-in"])
+	}
+
+	if waitRequested(args) {
+		return waitForPropagation(args, domain, key, value, true)
+	}
 	return nil
 }
 
 func (c *SetTxtCommand) ValidateArgs(args map[string]string) error {
-	if args["domain"] == "" {
-		return errors.New("--domain is required")
+	if err := validateRecordArgs(args); err != nil {
+		return err
 	}
-	if args["key"] == "" {
-		return errors.New("--key is required")
+	if err := validateValueArgs(args, "value"); err != nil {
+		return err
 	}
-	if args["value"] == "" {
-		return errors.New("--value is required")
+	if args["expires-in"] != "" {
+		if d, err := time.ParseDuration(args["expires-in"]); err != nil || d <= 0 {
+			return fmt.Errorf("--expires-in must be a positive duration (e.g. 1h, 30m)")
+		}
 	}
-	return nil
+	return validateWaitArgs(args)
 }
 
 func (c *SetTxtCommand) Usage() string {
-	return "set-txt --domain <domain> --key <key> --value <value>"
+	return "set-txt --domain <domain> --key <key> --value <value> [--expires-in <duration>] [--wait] [--wait-timeout <duration>] [--resolver <host:port>] [--nameserver-port <port>]"
 }
@@ -0,0 +1,39 @@
+package commands
+
+import (
+	"errors"
+
+	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/validate"
+)
+
+// flagError reports a field error using the command line flag name
+func flagError(err error) error {
+	var fieldErr *validate.FieldError
+	if errors.As(err, &fieldErr) {
+		return &validate.FieldError{Field: "--" + fieldErr.Field, Message: fieldErr.Message}
+	}
+	return err
+}
+
+// validateRecordArgs checks --domain, --key and the full name they combine to
+func validateRecordArgs(args map[string]string) error {
+	if err := validate.Hostname("domain", args["domain"]); err != nil {
+		return flagError(err)
+	}
+	if err := validate.OwnerName("key", args["key"]); err != nil {
+		return flagError(err)
+	}
+	zone, name := cpanel.RecordName(args["domain"], args["key"])
+	return flagError(validate.FQDN("key", name+"."+zone))
+}
+
+// validateValueArgs checks that each of the named TXT value flags is valid
+func validateValueArgs(args map[string]string, names ...string) error {
+	for _, name := range names {
+		if err := validate.TXTValue(name, args[name]); err != nil {
+			return flagError(err)
+		}
+	}
+	return nil
+}
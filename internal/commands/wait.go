@@ -0,0 +1,82 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/dnsclient"
+)
+
+// DefaultWaitTimeout bounds --wait when --wait-timeout is not given
+const DefaultWaitTimeout = 5 * time.Minute
+
+// waitRequested reports whether --wait was passed
+func waitRequested(args map[string]string) bool {
+	return args["wait"] == "true"
+}
+
+// validateWaitArgs checks the optional --wait-timeout and --nameserver-port values
+func validateWaitArgs(args map[string]string) error {
+	if args["wait-timeout"] != "" {
+		if d, err := time.ParseDuration(args["wait-timeout"]); err != nil || d <= 0 {
+			return fmt.Errorf("--wait-timeout must be a positive duration (e.g. 90s, 5m)")
+		}
+	}
+	return ValidatePort("--nameserver-port", args["nameserver-port"])
+}
+
+// ValidatePort checks an optional port number
+func ValidatePort(name, port string) error {
+	if port == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%s must be a port number between 1 and 65535", name)
+	}
+	return nil
+}
+
+// newDNSClient returns a client for the --resolver and --nameserver-port
+// arguments, which fall back to dns_resolver and dns_nameserver_port
+func newDNSClient(args map[string]string) *dnsclient.Client {
+	client := dnsclient.NewClient(args["resolver"])
+	client.Port = args["nameserver-port"]
+	return client
+}
+
+// waitForPropagation polls the zone's authoritative nameservers until all of
+// them report the record as present (or absent) or the wait timeout expires.
+// Nameservers that do not resolve are reported and skipped.
+func waitForPropagation(args map[string]string, domain, key, value string, present bool) error {
+	timeout := DefaultWaitTimeout
+	if args["wait-timeout"] != "" {
+		timeout, _ = time.ParseDuration(args["wait-timeout"])
+	}
+
+	zone, name := cpanel.RecordName(domain, key)
+	fqdn := name + "." + zone
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	fmt.Printf("Waiting up to %s for %s to propagate to all nameservers of %s...\n", timeout, fqdn, zone)
+	client := newDNSClient(args)
+	servers, err := client.Nameservers(ctx, zone)
+	if err != nil {
+		return fmt.Errorf("propagation check failed: failed to find nameservers for %s: %w", zone, err)
+	}
+	for _, ns := range servers {
+		if ns.Err != nil {
+			fmt.Printf("Skipping %s: %v\n", strings.TrimSuffix(ns.Host, "."), ns.Err)
+		}
+	}
+	if err := client.WaitForTXT(ctx, servers, fqdn, value, present, dnsclient.DefaultPollInterval); err != nil {
+		return fmt.Errorf("propagation check failed: %w", err)
+	}
+	fmt.Println("All authoritative nameservers are in sync.")
+	return nil
+}
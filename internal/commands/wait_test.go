@@ -0,0 +1,138 @@
+package commands
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"dns-proxy/internal/dnsclient"
+)
+
+// stubDNS is a local DNS server that plays both the recursive resolver and
+// every authoritative nameserver: NS and address records come from ns and
+// addrs, TXT records from txt
+type stubDNS struct {
+	Addr  string
+	Port  string
+	mu    sync.Mutex
+	ns    map[string][]string // zone -> NS targets
+	addrs map[string]net.IP   // host -> IPv4 address
+	txt   map[string][]string // name -> values
+}
+
+func newStubDNS(t *testing.T) *stubDNS {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	_, port, _ := net.SplitHostPort(pc.LocalAddr().String())
+	s := &stubDNS{Addr: pc.LocalAddr().String(), Port: port, ns: map[string][]string{}, addrs: map[string]net.IP{}, txt: map[string][]string{}}
+	go s.serve(pc)
+	return s
+}
+
+func (s *stubDNS) serve(pc net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req, err := dnsclient.Unpack(buf[:n])
+		if err != nil || len(req.Questions) != 1 {
+			continue
+		}
+		resp, _ := s.answer(req).Pack()
+		pc.WriteTo(resp, addr)
+	}
+}
+
+func (s *stubDNS) answer(req *dnsclient.Message) *dnsclient.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := req.Questions[0]
+	name := strings.ToLower(q.Name)
+	resp := &dnsclient.Message{
+		Header:    dnsclient.Header{ID: req.ID, Response: true, Authoritative: true},
+		Questions: req.Questions,
+	}
+	rr := func(typ uint16) dnsclient.Resource {
+		return dnsclient.Resource{Name: q.Name, Type: typ, Class: dnsclient.ClassINET, TTL: 60}
+	}
+	switch q.Type {
+	case dnsclient.TypeNS:
+		for _, target := range s.ns[name] {
+			r := rr(dnsclient.TypeNS)
+			r.Target = target
+			resp.Answers = append(resp.Answers, r)
+		}
+	case dnsclient.TypeA:
+		if ip, ok := s.addrs[name]; ok {
+			r := rr(dnsclient.TypeA)
+			r.IP = ip
+			resp.Answers = append(resp.Answers, r)
+		} else {
+			resp.Rcode = dnsclient.RcodeNXDomain
+		}
+	case dnsclient.TypeTXT:
+		for _, v := range s.txt[name] {
+			r := rr(dnsclient.TypeTXT)
+			r.TXT = []string{v}
+			resp.Answers = append(resp.Answers, r)
+		}
+	}
+	return resp
+}
+
+func TestWaitForPropagation(t *testing.T) {
+	dns := newStubDNS(t)
+	dns.ns["example.test."] = []string{"ns1.example.test."}
+	dns.addrs["ns1.example.test."] = net.IPv4(127, 0, 0, 1)
+	dns.txt["_acme-challenge.example.test."] = []string{"token"}
+
+	args := map[string]string{"resolver": dns.Addr, "nameserver-port": dns.Port, "wait-timeout": "2s"}
+	if err := validateWaitArgs(args); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitForPropagation(args, "example.test", "_acme-challenge", "token", true); err != nil {
+		t.Errorf("waiting for a served value: %v", err)
+	}
+	if err := waitForPropagation(args, "example.test", "_acme-challenge", "gone", false); err != nil {
+		t.Errorf("waiting for an absent value: %v", err)
+	}
+
+	args["wait-timeout"] = "200ms"
+	err := waitForPropagation(args, "example.test", "_acme-challenge", "other", true)
+	if err == nil || !strings.Contains(err.Error(), "ns1.example.test") {
+		t.Errorf("waiting for a value never served: %v, want a timeout naming ns1.example.test", err)
+	}
+}
+
+func TestValidatePort(t *testing.T) {
+	for port, ok := range map[string]bool{"": true, "53": true, "5353": true, "0": false, "65536": false, "dns": false} {
+		if err := ValidatePort("--nameserver-port", port); (err == nil) != ok {
+			t.Errorf("ValidatePort(%q) = %v", port, err)
+		}
+	}
+}
+
+func TestWaitSkipsUnresolvableNameservers(t *testing.T) {
+	dns := newStubDNS(t)
+	dns.ns["example.test."] = []string{"ns1.example.test.", "broken.example.test."}
+	dns.addrs["ns1.example.test."] = net.IPv4(127, 0, 0, 1)
+	dns.txt["_acme-challenge.example.test."] = []string{"token"}
+
+	args := map[string]string{"resolver": dns.Addr, "nameserver-port": dns.Port, "wait-timeout": "2s"}
+	if err := waitForPropagation(args, "example.test", "_acme-challenge", "token", true); err != nil {
+		t.Errorf("one broken nameserver failed the wait: %v", err)
+	}
+
+	dns.mu.Lock()
+	delete(dns.addrs, "ns1.example.test.")
+	dns.mu.Unlock()
+	if err := waitForPropagation(args, "example.test", "_acme-challenge", "token", true); err == nil {
+		t.Error("wait succeeded without any resolvable nameserver")
+	}
+}
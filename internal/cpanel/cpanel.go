@@ -2,19 +2,80 @@ package cpanel
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
+
+	"dns-proxy/internal/txtdata"
 )
 
+// DefaultMaxConcurrent is the number of cPanel calls allowed in flight at
+// once unless cpanel_max_concurrent says otherwise
+const DefaultMaxConcurrent = 4
+
+// callTimeout bounds a cPanel call, slot wait included, when the caller's
+// context has no deadline. A deadline set by the caller is left alone, longer
+// or not.
+const callTimeout = 60 * time.Second
+
+// httpClient is shared by all calls so connections to cPanel are reused. It
+// has no timeout of its own, the context of each call bounds it.
+var httpClient = &http.Client{}
+
 type CPanelConfig struct {
 	URL    string
 	User   string
 	APIKey string
+
+	// Observer, if set, is told about every cPanel API call
+	Observer Observer
+	// Changes, if set, is told about every attempted record change
+	Changes ChangeRecorder
+	// Owners, if set, limits deletes and edits to the records it lists
+	Owners OwnerRegistry
+
+	slots chan struct{} // limits concurrent calls, nil for no limit
+}
+
+// Observer watches cPanel API calls, e.g. to export metrics. CallFinished
+// gets the call's error, nil or an *Error.
+type Observer interface {
+	CallStarted(fn string)
+	CallFinished(fn string, elapsed time.Duration, err error)
+}
+
+// Change describes a record change attempted through the client
+type Change struct {
+	Op       string // "create", "edit" or "delete"
+	Zone     string
+	Name     string // fully qualified with trailing dot
+	Type     string
+	OldValue string
+	NewValue string
+	Serial   string // zone serial after the change as reported by cPanel, if any
+	Forced   bool   // the ownership check was skipped, see WithForce
+	Err      error  // nil if the change succeeded
+}
+
+// ChangeRecorder records record changes, e.g. in an audit log
+type ChangeRecorder interface {
+	RecordChange(ctx context.Context, c Change)
+}
+
+// ChangeRecorders passes every change to each of its recorders in turn
+type ChangeRecorders []ChangeRecorder
+
+func (rs ChangeRecorders) RecordChange(ctx context.Context, c Change) {
+	for _, r := range rs {
+		r.RecordChange(ctx, c)
+	}
 }
 
 // TxtRecord represents a TXT DNS record
@@ -23,6 +84,36 @@ type TxtRecord struct {
 	Key   string `json:"key"`   // The record name without the zone
 	Value string `json:"value"` // The txtdata
 	Name  string `json:"name"`  // Full name including zone
+
+	// Owned tells whether the proxy created the record, nil without an
+	// owner registry
+	Owned *bool `json:"owned,omitempty"`
+}
+
+// zoneRecord is a single record as returned by fetchzone
+type zoneRecord struct {
+	Line    int    `json:"Line"` // Capital L as per API docs
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	TxtData string `json:"txtdata"`
+}
+
+// apiResult is the part of the cPanel API 2 envelope shared by all functions
+type apiResult struct {
+	CPanelResult struct {
+		Error string `json:"error"`
+		Data  []struct {
+			Record []zoneRecord `json:"record"`
+			Result *struct {
+				NewSerial interface{} `json:"newserial"` // Can be string or int
+				StatusMsg string      `json:"statusmsg"`
+				Status    int         `json:"status"`
+			} `json:"result"`
+		} `json:"data"`
+		Event struct {
+			Result int `json:"result"`
+		} `json:"event"`
+	} `json:"cpanelresult"`
 }
 
 func NewCPanelConfig(cfg map[string]string) (*CPanelConfig, error) {
@@ -32,425 +123,340 @@ func NewCPanelConfig(cfg map[string]string) (*CPanelConfig, error) {
 	if url == "" || user == "" || apikey == "" {
 		return nil, errors.New("config incomplete: missing url, user or apikey")
 	}
-	return &CPanelConfig{URL: url, User: user, APIKey: apikey}, nil
-}
 
-func (c *CPanelConfig) CreateTxtRecord(domain, key, value string) error {
-	// Extract the actual zone and record name
-	zone, recordName := extractZoneAndName(domain)
-	if recordName != "" {
-		// If we have a subdomain, prepend the key to the record name
-		recordName = key + "." + recordName
-	} else {
-		// If no subdomain, just use the key
-		recordName = key
+	maxConcurrent := DefaultMaxConcurrent
+	if v := cfg["cpanel_max_concurrent"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("cpanel_max_concurrent must be a positive number, got %q", v)
+		}
+		maxConcurrent = n
 	}
+	c := &CPanelConfig{URL: url, User: user, APIKey: apikey, slots: make(chan struct{}, maxConcurrent)}
+	owners, err := newOwnerRegistry(cfg, c)
+	if err != nil {
+		return nil, err
+	}
+	c.Owners = owners
+	return c, nil
+}
 
-	fmt.Printf("DEBUG: Creating TXT record - zone='%s', recordName='%s', value='%s'\n", zone, recordName, value)
+// call invokes a ZoneEdit function through cPanel API 2 and returns the
+// decoded envelope. Transport, authentication and API level failures, and
+// giving up on a free call slot, are all reported as *Error.
+func (c *CPanelConfig) call(ctx context.Context, fn string, params url.Values) (res *apiResult, err error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, callTimeout)
+		defer cancel()
+	}
+
+	// Wait for a free slot so bursts of requests don't trip cPanel's own limits
+	if c.slots != nil {
+		select {
+		case c.slots <- struct{}{}:
+			defer func() { <-c.slots }()
+		case <-ctx.Done():
+			return nil, &Error{Func: fn, Kind: KindUnavailable, Message: "gave up waiting for a free call slot", Err: ctx.Err()}
+		}
+	}
+	if c.Observer != nil {
+		start := time.Now()
+		c.Observer.CallStarted(fn)
+		defer func() { c.Observer.CallFinished(fn, time.Since(start), err) }()
+	}
 
-	data := url.Values{}
-	data.Set("cpanel_jsonapi_user", c.User)
-	data.Set("cpanel_jsonapi_apiversion", "2")
-	data.Set("cpanel_jsonapi_module", "ZoneEdit")
-	data.Set("cpanel_jsonapi_func", "add_zone_record")
-	data.Set("domain", zone)     // Use the extracted zone
-	data.Set("name", recordName) // Use the extracted record name
-	data.Set("type", "TXT")
-	data.Set("txtdata", value)
-	data.Set("ttl", "300")
+	params.Set("cpanel_jsonapi_user", c.User)
+	params.Set("cpanel_jsonapi_apiversion", "2")
+	params.Set("cpanel_jsonapi_module", "ZoneEdit")
+	params.Set("cpanel_jsonapi_func", fn)
 
 	fullURL := fmt.Sprintf("%s/json-api/cpanel", c.URL)
-	req, err := http.NewRequest("POST", fullURL, bytes.NewBufferString(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", fullURL, bytes.NewBufferString(params.Encode()))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, &Error{Func: fn, Kind: KindUnavailable, Message: "failed to create request", Err: err}
 	}
-
 	req.Header.Set("Authorization", fmt.Sprintf("cpanel %s:%s", c.User, c.APIKey))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+		return nil, &Error{Func: fn, Kind: KindUnavailable, Message: "request failed", Err: err}
 	}
 	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &Error{Func: fn, Kind: KindUnavailable, StatusCode: resp.StatusCode, Message: "failed to read response", Err: err}
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected HTTP status %d: %s", resp.StatusCode, string(body))
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, &Error{Func: fn, Kind: KindAuth, StatusCode: resp.StatusCode, Message: "credentials rejected"}
+	case resp.StatusCode >= 500:
+		return nil, &Error{Func: fn, Kind: KindUnavailable, StatusCode: resp.StatusCode, Message: fmt.Sprintf("unexpected HTTP status %d", resp.StatusCode)}
+	case resp.StatusCode != http.StatusOK:
+		return nil, &Error{Func: fn, Kind: KindAPI, StatusCode: resp.StatusCode, Message: fmt.Sprintf("unexpected HTTP status %d: %s", resp.StatusCode, string(body))}
 	}
 
-	return nil
-}
+	var result apiResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, &Error{Func: fn, Kind: KindInvalidResponse, StatusCode: resp.StatusCode, Message: "failed to parse response", Err: err}
+	}
 
-func (c *CPanelConfig) DeleteTxtRecord(domain, key, value string) error {
-	// Extract the actual zone and record name
-	zone, recordName := extractZoneAndName(domain)
-	if recordName != "" {
-		// If we have a subdomain, prepend the key to the record name
-		recordName = key + "." + recordName
-	} else {
-		// If no subdomain, just use the key
-		recordName = key
+	// Check if the operation was successful
+	if result.CPanelResult.Error != "" {
+		return nil, &Error{Func: fn, Kind: KindAPI, StatusCode: resp.StatusCode, Message: result.CPanelResult.Error}
+	}
+	if result.CPanelResult.Event.Result != 1 {
+		return nil, &Error{Func: fn, Kind: KindAPI, StatusCode: resp.StatusCode, Message: fmt.Sprintf("event result was %d", result.CPanelResult.Event.Result)}
+	}
+	if len(result.CPanelResult.Data) > 0 {
+		if r := result.CPanelResult.Data[0].Result; r != nil && r.Status != 1 {
+			return nil, &Error{Func: fn, Kind: KindAPI, StatusCode: resp.StatusCode, Message: r.StatusMsg}
+		}
 	}
 
-	fmt.Printf("DEBUG: Using zone='%s', recordName='%s'\n", zone, recordName)
+	return &result, nil
+}
 
-	// 1. Fetch all zone records using cPanel API v2
-	fetchData := url.Values{}
-	fetchData.Set("cpanel_jsonapi_user", c.User)
-	fetchData.Set("cpanel_jsonapi_apiversion", "2")
-	fetchData.Set("cpanel_jsonapi_module", "ZoneEdit")
-	fetchData.Set("cpanel_jsonapi_func", "fetchzone")
-	fetchData.Set("domain", zone)    // Use the extracted zone
-	fetchData.Set("customonly", "0") // Return all records, not just non-essential ones
+// fetchZone returns all records of zone
+func (c *CPanelConfig) fetchZone(ctx context.Context, zone string) ([]zoneRecord, error) {
+	params := url.Values{}
+	params.Set("domain", zone)
+	params.Set("customonly", "0") // Return all records, not just non-essential ones
 
-	fullURL := fmt.Sprintf("%s/json-api/cpanel", c.URL)
-	req, err := http.NewRequest("POST", fullURL, bytes.NewBufferString(fetchData.Encode()))
+	result, err := c.call(ctx, "fetchzone", params)
 	if err != nil {
-		return fmt.Errorf("failed to create fetch request: %w", err)
+		return nil, err
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("cpanel %s:%s", c.User, c.APIKey))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("fetch request failed: %w", err)
+	var records []zoneRecord
+	for _, data := range result.CPanelResult.Data {
+		records = append(records, data.Record...)
 	}
-	defer resp.Body.Close()
+	return records, nil
+}
 
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected HTTP status %d: %s", resp.StatusCode, string(body))
-	}
-
-	// Debug: log the fetch response
-	fmt.Printf("DEBUG: fetchzone response: %s\n", string(body))
-
-	// 2. Parse cPanel API v2 response and find the record
-	var fetchResp struct {
-		CPanelResult struct {
-			Data []struct {
-				Record []struct {
-					Line    int    `json:"Line"` // Capital L as per API docs
-					Name    string `json:"name"`
-					Type    string `json:"type"`
-					TxtData string `json:"txtdata"`
-				} `json:"record"`
-			} `json:"data"`
-		} `json:"cpanelresult"`
-	}
-	if err := json.Unmarshal(body, &fetchResp); err != nil {
-		return fmt.Errorf("failed to parse fetchzone response: %w", err)
-	}
-
-	// Debug: log what we're searching for
-	fmt.Printf("DEBUG: Looking for TXT record with name='%s' and txtdata='%s'\n", recordName+"."+zone+".", value)
-
-	var foundID *int
-	for _, data := range fetchResp.CPanelResult.Data {
-		for _, rec := range data.Record {
-			fmt.Printf("DEBUG: Found record - Line: %d, Name: '%s', Type: '%s', TxtData: '%s'\n",
-				rec.Line, rec.Name, rec.Type, rec.TxtData)
-
-			// Check if this is our TXT record
-			if rec.Type == "TXT" && rec.Name == recordName+"."+zone+"." && rec.TxtData == value {
-				id := rec.Line
-				foundID = &id
-				break
-			}
-		}
-		if foundID != nil {
-			break
+// Ping checks that cPanel is reachable and accepts the credentials with
+// fetchzones, a cheap authenticated call that changes nothing
+func (c *CPanelConfig) Ping(ctx context.Context) error {
+	_, err := c.call(ctx, "fetchzones", url.Values{})
+	return err
+}
+
+// findTxtRecord returns the TXT record named fqdn holding value
+func findTxtRecord(records []zoneRecord, fqdn, value string) (zoneRecord, error) {
+	for _, rec := range records {
+		if rec.Type == "TXT" && strings.EqualFold(rec.Name, fqdn) && txtdata.Match(rec.TxtData, value) {
+			return rec, nil
 		}
 	}
-	if foundID == nil {
-		return fmt.Errorf("TXT record not found for deletion")
-	}
+	return zoneRecord{}, ErrRecordNotFound
+}
 
-	fmt.Printf("DEBUG: Found record to delete with line: %d\n", *foundID)
+func (c *CPanelConfig) CreateTxtRecord(ctx context.Context, domain, key, value string) (err error) {
+	// Extract the actual zone and record name
+	zone, recordName := RecordName(domain, key)
+	change := Change{Op: "create", Zone: zone, Name: recordName + "." + zone + ".", Type: "TXT", NewValue: value}
+	defer func() { c.recordChange(ctx, change, err) }()
 
-	// 3. Remove the record by line using cPanel API v2
-	delData := url.Values{}
-	delData.Set("cpanel_jsonapi_user", c.User)
-	delData.Set("cpanel_jsonapi_apiversion", "2")
-	delData.Set("cpanel_jsonapi_module", "ZoneEdit")
-	delData.Set("cpanel_jsonapi_func", "remove_zone_record")
-	delData.Set("domain", zone) // Use the extracted zone
-	delData.Set("line", fmt.Sprintf("%d", *foundID))
+	data := url.Values{}
+	data.Set("domain", zone)     // Use the extracted zone
+	data.Set("name", recordName) // Use the extracted record name
+	data.Set("type", "TXT")
+	data.Set("txtdata", txtdata.Encode(value))
+	data.Set("ttl", "300")
 
-	delReq, err := http.NewRequest("POST", fullURL, bytes.NewBufferString(delData.Encode()))
+	result, err := c.call(ctx, "add_zone_record", data)
 	if err != nil {
-		return fmt.Errorf("failed to create delete request: %w", err)
+		return err
 	}
-	delReq.Header.Set("Authorization", fmt.Sprintf("cpanel %s:%s", c.User, c.APIKey))
-	delReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	change.Serial = newSerial(result)
+	c.claim(ctx, zone, change.Name, value)
+	return nil
+}
 
-	delResp, err := client.Do(delReq)
-	if err != nil {
-		return fmt.Errorf("delete request failed: %w", err)
-	}
-	defer delResp.Body.Close()
+func (c *CPanelConfig) DeleteTxtRecord(ctx context.Context, domain, key, value string) (err error) {
+	// Extract the actual zone and record name
+	zone, recordName := RecordName(domain, key)
+	change := Change{Op: "delete", Zone: zone, Name: recordName + "." + zone + ".", Type: "TXT", OldValue: value, Forced: forced(ctx)}
+	defer func() { c.recordChange(ctx, change, err) }()
 
-	delBody, _ := io.ReadAll(delResp.Body)
-	if delResp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected HTTP status %d: %s", delResp.StatusCode, string(delBody))
+	// 1. Fetch all zone records and find the record
+	records, err := c.fetchZone(ctx, zone)
+	if err != nil {
+		return err
 	}
-
-	// Debug: log delete response
-	fmt.Printf("DEBUG: remove_zone_record response: %s\n", string(delBody))
-
-	// Parse and validate the delete response
-	var delResult struct {
-		CPanelResult struct {
-			Data []struct {
-				Result struct {
-					NewSerial interface{} `json:"newserial"` // Can be string or int
-					StatusMsg string      `json:"statusmsg"`
-					Status    int         `json:"status"`
-				} `json:"result"`
-			} `json:"data"`
-			Event struct {
-				Result int `json:"result"`
-			} `json:"event"`
-		} `json:"cpanelresult"`
+	rec, err := findTxtRecord(records, recordName+"."+zone+".", value)
+	if err != nil {
+		return err
 	}
-
-	if err := json.Unmarshal(delBody, &delResult); err != nil {
-		return fmt.Errorf("failed to parse remove_zone_record response: %w", err)
+	// The registry holds the value as it was created, which may be spelled
+	// differently from the one given here
+	current := txtdata.Decode(rec.TxtData)
+	if err := c.checkOwner(ctx, zone, change.Name, current); err != nil {
+		return err
 	}
 
-	// Check if the operation was successful
-	if delResult.CPanelResult.Event.Result != 1 {
-		return fmt.Errorf("remove_zone_record failed: event result was %d", delResult.CPanelResult.Event.Result)
-	}
+	// 2. Remove the record by line
+	delData := url.Values{}
+	delData.Set("domain", zone) // Use the extracted zone
+	delData.Set("line", fmt.Sprintf("%d", rec.Line))
 
-	if len(delResult.CPanelResult.Data) > 0 && delResult.CPanelResult.Data[0].Result.Status != 1 {
-		return fmt.Errorf("remove_zone_record failed: %s", delResult.CPanelResult.Data[0].Result.StatusMsg)
+	result, err := c.call(ctx, "remove_zone_record", delData)
+	if err != nil {
+		return err
 	}
 
-	fmt.Printf("DEBUG: Record successfully deleted. New serial: %v\n",
-		delResult.CPanelResult.Data[0].Result.NewSerial)
+	change.Serial = newSerial(result)
+	c.release(ctx, zone, change.Name, current)
 
 	return nil
 }
 
-func (c *CPanelConfig) EditTxtRecord(domain, key, oldValue, newValue string) error {
+func (c *CPanelConfig) EditTxtRecord(ctx context.Context, domain, key, oldValue, newValue string) (err error) {
 	// Extract the actual zone and record name
-	zone, recordName := extractZoneAndName(domain)
-	if recordName != "" {
-		// If we have a subdomain, prepend the key to the record name
-		recordName = key + "." + recordName
-	} else {
-		// If no subdomain, just use the key
-		recordName = key
-	}
-
-	fmt.Printf("DEBUG: Using zone='%s', recordName='%s'\n", zone, recordName)
+	zone, recordName := RecordName(domain, key)
+	change := Change{Op: "edit", Zone: zone, Name: recordName + "." + zone + ".", Type: "TXT", OldValue: oldValue, NewValue: newValue, Forced: forced(ctx)}
+	defer func() { c.recordChange(ctx, change, err) }()
 
-	// 1. Fetch all zone records using cPanel API v2 to find the record to edit
-	fetchData := url.Values{}
-	fetchData.Set("cpanel_jsonapi_user", c.User)
-	fetchData.Set("cpanel_jsonapi_apiversion", "2")
-	fetchData.Set("cpanel_jsonapi_module", "ZoneEdit")
-	fetchData.Set("cpanel_jsonapi_func", "fetchzone")
-	fetchData.Set("domain", zone) // Use the extracted zone
-	fetchData.Set("customonly", "0")
-
-	fullURL := fmt.Sprintf("%s/json-api/cpanel", c.URL)
-	req, err := http.NewRequest("POST", fullURL, bytes.NewBufferString(fetchData.Encode()))
+	// 1. Fetch all zone records to find the record to edit
+	records, err := c.fetchZone(ctx, zone)
 	if err != nil {
-		return fmt.Errorf("failed to create fetch request: %w", err)
+		return err
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("cpanel %s:%s", c.User, c.APIKey))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	rec, err := findTxtRecord(records, recordName+"."+zone+".", oldValue)
 	if err != nil {
-		return fmt.Errorf("fetch request failed: %w", err)
+		return err
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected HTTP status %d: %s", resp.StatusCode, string(body))
-	}
-
-	// Debug: log the fetch response
-	fmt.Printf("DEBUG: fetchzone response: %s\n", string(body))
-
-	// 2. Parse cPanel API v2 response and find the record
-	var fetchResp struct {
-		CPanelResult struct {
-			Data []struct {
-				Record []struct {
-					Line    int    `json:"Line"` // Capital L as per API docs
-					Name    string `json:"name"`
-					Type    string `json:"type"`
-					TxtData string `json:"txtdata"`
-				} `json:"record"`
-			} `json:"data"`
-		} `json:"cpanelresult"`
-	}
-	if err := json.Unmarshal(body, &fetchResp); err != nil {
-		return fmt.Errorf("failed to parse fetchzone response: %w", err)
-	}
-
-	// Debug: log what we're searching for
-	fmt.Printf("DEBUG: Looking for TXT record with name='%s' and txtdata='%s'\n", recordName+"."+zone+".", oldValue)
-
-	var foundLine *int
-	for _, data := range fetchResp.CPanelResult.Data {
-		for _, rec := range data.Record {
-			fmt.Printf("DEBUG: Found record - Line: %d, Name: '%s', Type: '%s', TxtData: '%s'\n",
-				rec.Line, rec.Name, rec.Type, rec.TxtData)
-
-			// Check if this is our TXT record
-			if rec.Type == "TXT" && rec.Name == recordName+"."+zone+"." && rec.TxtData == oldValue {
-				line := rec.Line
-				foundLine = &line
-				break
-			}
-		}
-		if foundLine != nil {
-			break
-		}
+	// The registry holds the value as it was created, which may be spelled
+	// differently from the one given here
+	current := txtdata.Decode(rec.TxtData)
+	if err := c.checkOwner(ctx, zone, change.Name, current); err != nil {
+		return err
 	}
-	if foundLine == nil {
-		return fmt.Errorf("TXT record not found for editing")
-	}
-
-	fmt.Printf("DEBUG: Found record to edit at line: %d\n", *foundLine)
 
-	// 3. Edit the record using cPanel API v2 edit_zone_record
+	// 2. Edit the record using edit_zone_record
 	editData := url.Values{}
-	editData.Set("cpanel_jsonapi_user", c.User)
-	editData.Set("cpanel_jsonapi_apiversion", "2")
-	editData.Set("cpanel_jsonapi_module", "ZoneEdit")
-	editData.Set("cpanel_jsonapi_func", "edit_zone_record")
-	editData.Set("Line", fmt.Sprintf("%d", *foundLine)) // Capital L as per API docs
-	editData.Set("domain", zone)                        // Use the extracted zone
-	editData.Set("name", recordName)                    // Use the extracted record name
+	editData.Set("Line", fmt.Sprintf("%d", rec.Line)) // Capital L as per API docs
+	editData.Set("domain", zone)                      // Use the extracted zone
+	editData.Set("name", recordName)                  // Use the extracted record name
 	editData.Set("type", "TXT")
-	editData.Set("txtdata", newValue)
+	editData.Set("txtdata", txtdata.Encode(newValue))
 	editData.Set("ttl", "300")
 	editData.Set("class", "IN")
 
-	editReq, err := http.NewRequest("POST", fullURL, bytes.NewBufferString(editData.Encode()))
+	result, err := c.call(ctx, "edit_zone_record", editData)
 	if err != nil {
-		return fmt.Errorf("failed to create edit request: %w", err)
+		return err
 	}
-	editReq.Header.Set("Authorization", fmt.Sprintf("cpanel %s:%s", c.User, c.APIKey))
-	editReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	change.Serial = newSerial(result)
+	// A forced edit takes the record over
+	c.release(ctx, zone, change.Name, current)
+	c.claim(ctx, zone, change.Name, newValue)
+	return nil
+}
 
-	editResp, err := client.Do(editReq)
-	if err != nil {
-		return fmt.Errorf("edit request failed: %w", err)
+func (c *CPanelConfig) recordChange(ctx context.Context, change Change, err error) {
+	if c.Changes == nil {
+		return
 	}
-	defer editResp.Body.Close()
+	change.Err = err
+	c.Changes.RecordChange(ctx, change)
+}
 
-	editBody, _ := io.ReadAll(editResp.Body)
-	if editResp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected HTTP status %d: %s", editResp.StatusCode, string(editBody))
+// newSerial returns the zone serial cPanel reports after a change
+func newSerial(result *apiResult) string {
+	if len(result.CPanelResult.Data) == 0 || result.CPanelResult.Data[0].Result == nil {
+		return ""
+	}
+	switch serial := result.CPanelResult.Data[0].Result.NewSerial.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(serial, 'f', -1, 64)
+	default:
+		return fmt.Sprint(serial)
 	}
-
-	// Debug: log edit response
-	fmt.Printf("DEBUG: edit_zone_record response: %s\n", string(editBody))
-
-	return nil
 }
 
 // ListTxtRecords lists all TXT records for a given domain with optional filtering by key
-func (c *CPanelConfig) ListTxtRecords(domain, keyFilter string) ([]TxtRecord, error) {
+func (c *CPanelConfig) ListTxtRecords(ctx context.Context, domain, keyFilter string) ([]TxtRecord, error) {
 	// Extract the actual zone
 	zone, recordPrefix := extractZoneAndName(domain)
 
-	fmt.Printf("DEBUG: Listing TXT records for zone='%s', recordPrefix='%s', keyFilter='%s'\n", zone, recordPrefix, keyFilter)
-
-	// 1. Fetch all zone records using cPanel API v2
-	fetchData := url.Values{}
-	fetchData.Set("cpanel_jsonapi_user", c.User)
-	fetchData.Set("cpanel_jsonapi_apiversion", "2")
-	fetchData.Set("cpanel_jsonapi_module", "ZoneEdit")
-	fetchData.Set("cpanel_jsonapi_func", "fetchzone")
-	fetchData.Set("domain", zone)    // Use the extracted zone
-	fetchData.Set("customonly", "0") // Return all records, not just non-essential ones
-
-	fullURL := fmt.Sprintf("%s/json-api/cpanel", c.URL)
-	req, err := http.NewRequest("POST", fullURL, bytes.NewBufferString(fetchData.Encode()))
+	zoneRecords, err := c.fetchZone(ctx, zone)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create fetch request: %w", err)
-	}
-	req.Header.Set("Authorization", fmt.Sprintf("cpanel %s:%s", c.User, c.APIKey))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("fetch request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected HTTP status %d: %s", resp.StatusCode, string(body))
-	}
-
-	// 2. Parse cPanel API v2 response and find TXT records
-	var fetchResp struct {
-		CPanelResult struct {
-			Data []struct {
-				Record []struct {
-					Line    int    `json:"Line"` // Capital L as per API docs
-					Name    string `json:"name"`
-					Type    string `json:"type"`
-					TxtData string `json:"txtdata"`
-				} `json:"record"`
-			} `json:"data"`
-		} `json:"cpanelresult"`
-	}
-	if err := json.Unmarshal(body, &fetchResp); err != nil {
-		return nil, fmt.Errorf("failed to parse fetchzone response: %w", err)
+		return nil, err
 	}
 
 	var records []TxtRecord
 	zoneSuffix := "." + zone + "."
 
-	for _, data := range fetchResp.CPanelResult.Data {
-		for _, rec := range data.Record {
-			if rec.Type == "TXT" {
-				// Extract the key from the full name
-				key := strings.TrimSuffix(rec.Name, zoneSuffix)
-
-				// If we have a record prefix filter (e.g., from subdomain), check if it matches
-				if recordPrefix != "" {
-					expectedPrefix := keyFilter + "." + recordPrefix
-					if keyFilter != "" && !strings.HasPrefix(key, expectedPrefix) && key != expectedPrefix {
-						continue
-					}
-				} else if keyFilter != "" {
-					// Simple key filter
-					if !strings.HasPrefix(key, keyFilter) && key != keyFilter {
-						continue
-					}
+	for _, rec := range zoneRecords {
+		// The companion records of the "txt" owner registry are bookkeeping,
+		// not records of the zone's users
+		if rec.Type == "TXT" && !isOwnerRecord(zone, rec.Name) {
+			// Extract the key from the full name
+			key := strings.TrimSuffix(rec.Name, zoneSuffix)
+
+			// If we have a record prefix filter (e.g., from subdomain), check if it matches
+			if recordPrefix != "" {
+				expectedPrefix := keyFilter + "." + recordPrefix
+				if keyFilter != "" && !strings.HasPrefix(key, expectedPrefix) && key != expectedPrefix {
+					continue
+				}
+			} else if keyFilter != "" {
+				// Simple key filter
+				if !strings.HasPrefix(key, keyFilter) && key != keyFilter {
+					continue
 				}
-
-				records = append(records, TxtRecord{
-					Line:  rec.Line,
-					Key:   key,
-					Value: rec.TxtData,
-					Name:  rec.Name,
-				})
 			}
+
+			records = append(records, TxtRecord{
+				Line:  rec.Line,
+				Key:   key,
+				Value: txtdata.Decode(rec.TxtData),
+				Name:  rec.Name,
+			})
+		}
+	}
+
+	if c.Owners != nil {
+		owned, err := c.Owners.Owned(ctx, zone)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up record ownership: %w", err)
+		}
+		for i := range records {
+			o := owned[OwnerKey(records[i].Name, records[i].Value)]
+			records[i].Owned = &o
 		}
 	}
 
 	return records, nil
 }
 
+// RecordName returns the zone and the zone-relative record name that key
+// refers to under domain. For example: ("haos.iveronsoft.ro", "_acme-challenge")
+// -> zone: "iveronsoft.ro", name: "_acme-challenge.haos"
+func RecordName(domain, key string) (zone, name string) {
+	zone, name = extractZoneAndName(domain)
+	if name != "" {
+		// If we have a subdomain, prepend the key to the record name
+		return zone, key + "." + name
+	}
+	// If no subdomain, just use the key
+	return zone, key
+}
+
+// SplitFQDN splits a fully qualified record name into the domain and key
+// arguments expected by the record operations. A trailing dot is ignored.
+// For example: "_acme-challenge.haos.iveronsoft.ro." -> domain: "iveronsoft.ro", key: "_acme-challenge.haos"
+func SplitFQDN(fqdn string) (domain, key string) {
+	return extractZoneAndName(strings.TrimSuffix(fqdn, "."))
+}
+
 // extractZoneAndName extracts the zone and record name from a full domain
 // For example: "_acme-challenge.haos.iveronsoft.ro" -> zone: "iveronsoft.ro", name: "_acme-challenge.haos"
 func extractZoneAndName(fullDomain string) (zone, name string) {
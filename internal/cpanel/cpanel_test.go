@@ -0,0 +1,47 @@
+package cpanel
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSlotWaitReportsError(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"cpanelresult":{"event":{"result":1}}}`))
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c, err := NewCPanelConfig(map[string]string{
+		"cpanel_url":            srv.URL,
+		"cpanel_user":           "user",
+		"cpanel_apikey":         "key",
+		"cpanel_max_concurrent": "1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first call takes the only slot until the server answers
+	go c.Ping(context.Background())
+	for len(c.slots) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = c.Ping(ctx)
+	var cpErr *Error
+	if !errors.As(err, &cpErr) || cpErr.Func != "fetchzones" || cpErr.Kind != KindUnavailable {
+		t.Fatalf("Ping while the slot is taken = %#v, want an *Error of kind unavailable", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error %v does not wrap the context's error", err)
+	}
+}
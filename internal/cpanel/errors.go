@@ -0,0 +1,66 @@
+package cpanel
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrRecordNotFound is returned when no TXT record matches a delete or edit
+var ErrRecordNotFound = errors.New("TXT record not found")
+
+// ErrNotOwned is returned when an owner registry is configured and a delete
+// or edit targets a record the proxy did not create. See WithForce.
+var ErrNotOwned = errors.New("TXT record is not owned by the proxy")
+
+// ErrorKind classifies failures talking to cPanel
+type ErrorKind int
+
+const (
+	// KindUnavailable means cPanel could not be reached or did not answer in time
+	KindUnavailable ErrorKind = iota
+	// KindAuth means cPanel rejected the configured credentials
+	KindAuth
+	// KindAPI means cPanel answered but reported the call as failed
+	KindAPI
+	// KindInvalidResponse means the cPanel answer could not be understood
+	KindInvalidResponse
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindUnavailable:
+		return "unavailable"
+	case KindAuth:
+		return "auth"
+	case KindAPI:
+		return "api"
+	case KindInvalidResponse:
+		return "invalid_response"
+	default:
+		return fmt.Sprintf("kind%d", int(k))
+	}
+}
+
+// Error describes a failed cPanel API call
+type Error struct {
+	Func       string // cPanel API function, e.g. "fetchzone"
+	Kind       ErrorKind
+	StatusCode int    // HTTP status of the cPanel response, 0 if there was none
+	Message    string // error reported by cPanel or a short description
+	Err        error  // underlying error, if any
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if e.Err != nil {
+		if msg != "" {
+			msg += ": "
+		}
+		msg += e.Err.Error()
+	}
+	return fmt.Sprintf("cPanel %s failed (%s): %s", e.Func, e.Kind, msg)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,268 @@
+package cpanel
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+
+	"dns-proxy/internal/statefile"
+	"dns-proxy/internal/txtdata"
+)
+
+// OwnerLabel is the name, under each zone, of the companion TXT records
+// kept by the "txt" owner registry
+const OwnerLabel = "_dns-proxy-owner"
+
+// DefaultOwnerID identifies this proxy in companion TXT records unless
+// owner_id says otherwise
+const DefaultOwnerID = "dns-proxy"
+
+// OwnerRegistry keeps track of the TXT records the proxy created. Each
+// claim stands for one record, so a value created twice is owned until
+// both copies are deleted.
+type OwnerRegistry interface {
+	// Owned returns the keys, see OwnerKey, of the records in zone the
+	// proxy owns
+	Owned(ctx context.Context, zone string) (map[string]bool, error)
+	Claim(ctx context.Context, zone, name, value string) error
+	Release(ctx context.Context, zone, name, value string) error
+}
+
+// OwnerKey identifies the record with the given fully qualified name and
+// value in an OwnerRegistry
+func OwnerKey(name, value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return strings.ToLower(strings.TrimSuffix(name, ".")) + ". " + hex.EncodeToString(sum[:])
+}
+
+type forceKey struct{}
+
+// WithForce returns a context under which deletes and edits skip the
+// ownership check
+func WithForce(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceKey{}, true)
+}
+
+func forced(ctx context.Context) bool {
+	force, _ := ctx.Value(forceKey{}).(bool)
+	return force
+}
+
+// newOwnerRegistry returns the registry selected by owner_registry, nil if
+// ownership is not tracked
+func newOwnerRegistry(cfg map[string]string, c *CPanelConfig) (OwnerRegistry, error) {
+	switch cfg["owner_registry"] {
+	case "":
+		return nil, nil
+	case "file":
+		if cfg["owner_file"] == "" {
+			return nil, errors.New("owner_registry=file needs owner_file")
+		}
+		return &FileOwnerRegistry{Path: cfg["owner_file"]}, nil
+	case "txt":
+		id := cfg["owner_id"]
+		if id == "" {
+			id = DefaultOwnerID
+		}
+		if strings.ContainsAny(id, " \t=") {
+			return nil, fmt.Errorf("owner_id must not contain spaces or '=', got %q", id)
+		}
+		return &TXTOwnerRegistry{Client: c, OwnerID: id}, nil
+	default:
+		return nil, fmt.Errorf("owner_registry must be file or txt, got %q", cfg["owner_registry"])
+	}
+}
+
+// checkOwner returns ErrNotOwned if a registry is configured, the change is
+// not forced and the registry does not list the record
+func (c *CPanelConfig) checkOwner(ctx context.Context, zone, name, value string) error {
+	if c.Owners == nil || forced(ctx) {
+		return nil
+	}
+	owned, err := c.Owners.Owned(ctx, zone)
+	if err != nil {
+		return fmt.Errorf("failed to look up record ownership: %w", err)
+	}
+	if !owned[OwnerKey(name, value)] {
+		return ErrNotOwned
+	}
+	return nil
+}
+
+// claim and release update the registry after a change. Failures are
+// logged, the change itself has already been made.
+func (c *CPanelConfig) claim(ctx context.Context, zone, name, value string) {
+	if c.Owners == nil {
+		return
+	}
+	if err := c.Owners.Claim(ctx, zone, name, value); err != nil {
+		log.Printf("owner registry: failed to claim %s %q: %v", name, value, err)
+	}
+}
+
+func (c *CPanelConfig) release(ctx context.Context, zone, name, value string) {
+	if c.Owners == nil {
+		return
+	}
+	if err := c.Owners.Release(ctx, zone, name, value); err != nil {
+		log.Printf("owner registry: failed to release %s %q: %v", name, value, err)
+	}
+}
+
+// FileOwnerRegistry keeps the owned records in a local JSON file, which the
+// API and the CLI may share
+type FileOwnerRegistry struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// ownedRecord is an entry of the file registry
+type ownedRecord struct {
+	Zone  string `json:"zone"`
+	Name  string `json:"name"` // fully qualified with trailing dot
+	Value string `json:"value"`
+}
+
+func (r *FileOwnerRegistry) Owned(ctx context.Context, zone string) (map[string]bool, error) {
+	owned := make(map[string]bool)
+	err := r.update(func(records []ownedRecord) ([]ownedRecord, bool) {
+		for _, rec := range records {
+			if strings.EqualFold(rec.Zone, zone) {
+				owned[OwnerKey(rec.Name, rec.Value)] = true
+			}
+		}
+		return records, false
+	})
+	return owned, err
+}
+
+func (r *FileOwnerRegistry) Claim(ctx context.Context, zone, name, value string) error {
+	return r.update(func(records []ownedRecord) ([]ownedRecord, bool) {
+		return append(records, ownedRecord{Zone: zone, Name: name, Value: value}), true
+	})
+}
+
+func (r *FileOwnerRegistry) Release(ctx context.Context, zone, name, value string) error {
+	return r.update(func(records []ownedRecord) ([]ownedRecord, bool) {
+		for i, rec := range records {
+			if strings.EqualFold(rec.Zone, zone) && strings.EqualFold(rec.Name, name) && rec.Value == value {
+				return append(records[:i], records[i+1:]...), true
+			}
+		}
+		return records, false
+	})
+}
+
+func (r *FileOwnerRegistry) update(fn func([]ownedRecord) ([]ownedRecord, bool)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var records []ownedRecord
+	return statefile.Update(r.Path, &records, func() bool {
+		var changed bool
+		records, changed = fn(records)
+		if records == nil {
+			records = []ownedRecord{}
+		}
+		return changed
+	})
+}
+
+// TXTOwnerRegistry keeps the owned records as companion TXT records in the
+// zone itself, at OwnerLabel.<zone>, so the ownership travels with the zone
+// and is shared by every proxy using the same OwnerID. A companion holds
+// the record's name and the SHA-256 of its value:
+//
+//	"dns-proxy owner=<id> name=<fqdn> sha256=<hex>"
+type TXTOwnerRegistry struct {
+	Client  *CPanelConfig
+	OwnerID string
+}
+
+// ownerName returns the fully qualified name of zone's companion records
+func ownerName(zone string) string {
+	return OwnerLabel + "." + zone + "."
+}
+
+// isOwnerRecord reports whether name is where the "txt" owner registry
+// keeps its companion records
+func isOwnerRecord(zone, name string) bool {
+	return strings.EqualFold(strings.TrimSuffix(name, "."), OwnerLabel+"."+zone)
+}
+
+func (r *TXTOwnerRegistry) companion(name, value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return fmt.Sprintf("dns-proxy owner=%s name=%s sha256=%s", r.OwnerID, strings.ToLower(strings.TrimSuffix(name, "."))+".", hex.EncodeToString(sum[:]))
+}
+
+// parseCompanion returns the OwnerKey a companion value of this owner
+// stands for
+func (r *TXTOwnerRegistry) parseCompanion(value string) (string, bool) {
+	fields := strings.Fields(value)
+	if len(fields) != 4 || fields[0] != "dns-proxy" {
+		return "", false
+	}
+	attrs := make(map[string]string, 3)
+	for _, f := range fields[1:] {
+		k, v, _ := strings.Cut(f, "=")
+		attrs[k] = v
+	}
+	if attrs["owner"] != r.OwnerID || attrs["name"] == "" || attrs["sha256"] == "" {
+		return "", false
+	}
+	return strings.ToLower(attrs["name"]) + " " + attrs["sha256"], true
+}
+
+func (r *TXTOwnerRegistry) Owned(ctx context.Context, zone string) (map[string]bool, error) {
+	records, err := r.Client.fetchZone(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	owned := make(map[string]bool)
+	for _, rec := range records {
+		if rec.Type != "TXT" || !isOwnerRecord(zone, rec.Name) {
+			continue
+		}
+		if key, ok := r.parseCompanion(txtdata.Decode(rec.TxtData)); ok {
+			owned[key] = true
+		}
+	}
+	return owned, nil
+}
+
+func (r *TXTOwnerRegistry) Claim(ctx context.Context, zone, name, value string) error {
+	data := url.Values{}
+	data.Set("domain", zone)
+	data.Set("name", OwnerLabel)
+	data.Set("type", "TXT")
+	data.Set("txtdata", txtdata.Encode(r.companion(name, value)))
+	data.Set("ttl", "300")
+	_, err := r.Client.call(ctx, "add_zone_record", data)
+	return err
+}
+
+func (r *TXTOwnerRegistry) Release(ctx context.Context, zone, name, value string) error {
+	records, err := r.Client.fetchZone(ctx, zone)
+	if err != nil {
+		return err
+	}
+	rec, err := findTxtRecord(records, ownerName(zone), r.companion(name, value))
+	if errors.Is(err, ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data := url.Values{}
+	data.Set("domain", zone)
+	data.Set("line", fmt.Sprintf("%d", rec.Line))
+	_, err = r.Client.call(ctx, "remove_zone_record", data)
+	return err
+}
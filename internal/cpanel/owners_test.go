@@ -0,0 +1,192 @@
+package cpanel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"dns-proxy/internal/txtdata"
+)
+
+// fakeCPanel serves the ZoneEdit functions the client uses from an
+// in-memory zone
+type fakeCPanel struct {
+	mu       sync.Mutex
+	records  []zoneRecord
+	nextLine int
+}
+
+func (f *fakeCPanel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	type result struct {
+		Status    int    `json:"status"`
+		NewSerial string `json:"newserial"`
+	}
+	data := map[string]interface{}{"result": result{Status: 1, NewSerial: strconv.Itoa(f.nextLine)}}
+	switch fn := r.FormValue("cpanel_jsonapi_func"); fn {
+	case "fetchzone":
+		data = map[string]interface{}{"record": f.records}
+	case "add_zone_record":
+		f.nextLine++
+		f.records = append(f.records, zoneRecord{
+			Line:    f.nextLine,
+			Name:    r.FormValue("name") + "." + r.FormValue("domain") + ".",
+			Type:    r.FormValue("type"),
+			TxtData: r.FormValue("txtdata"),
+		})
+	case "edit_zone_record", "remove_zone_record":
+		line, _ := strconv.Atoi(r.FormValue("line") + r.FormValue("Line"))
+		for i, rec := range f.records {
+			if rec.Line != line {
+				continue
+			}
+			if fn == "edit_zone_record" {
+				f.records[i].TxtData = r.FormValue("txtdata")
+			} else {
+				f.records = append(f.records[:i], f.records[i+1:]...)
+			}
+			break
+		}
+	default:
+		http.Error(w, "unknown function "+fn, http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cpanelresult": map[string]interface{}{
+			"data":  []interface{}{data},
+			"event": map[string]int{"result": 1},
+		},
+	})
+}
+
+// add puts a record into the zone as if made outside the proxy
+func (f *fakeCPanel) add(name, txt string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextLine++
+	f.records = append(f.records, zoneRecord{Line: f.nextLine, Name: name, Type: "TXT", TxtData: txt})
+}
+
+func newTestClient(t *testing.T, cfg map[string]string) (*CPanelConfig, *fakeCPanel) {
+	t.Helper()
+	fake := &fakeCPanel{}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	cfg["cpanel_url"] = srv.URL
+	cfg["cpanel_user"] = "user"
+	cfg["cpanel_apikey"] = "key"
+	c, err := NewCPanelConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, fake
+}
+
+func TestOwnerCheckUsesMatchedValue(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestClient(t, map[string]string{"owner_registry": "txt"})
+
+	// Values over 255 bytes are stored chunked; the chunked form, as
+	// cPanel shows it, matches the same record as the logical value
+	long := strings.Repeat("a", 300)
+	if err := c.CreateTxtRecord(ctx, "example.com", "_domainkey", long); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.EditTxtRecord(ctx, "example.com", "_domainkey", txtdata.Encode(long), long+"b"); err != nil {
+		t.Fatalf("edit with the chunked value: %v", err)
+	}
+	if err := c.DeleteTxtRecord(ctx, "example.com", "_domainkey", txtdata.Encode(long+"b")); err != nil {
+		t.Fatalf("delete with the chunked value: %v", err)
+	}
+
+	owned, err := c.Owners.Owned(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owned) != 0 {
+		t.Errorf("registry still lists %v after the delete", owned)
+	}
+}
+
+func TestOwnerCheckRefusesForeignRecords(t *testing.T) {
+	ctx := context.Background()
+	c, fake := newTestClient(t, map[string]string{"owner_registry": "txt"})
+	fake.add("_acme-challenge.example.com.", `"manual"`)
+	var changes changeLog
+	c.Changes = &changes
+
+	err := c.DeleteTxtRecord(ctx, "example.com", "_acme-challenge", "manual")
+	if !errors.Is(err, ErrNotOwned) {
+		t.Fatalf("delete of a record made by hand = %v, want ErrNotOwned", err)
+	}
+	if err := c.DeleteTxtRecord(WithForce(ctx), "example.com", "_acme-challenge", "manual"); err != nil {
+		t.Fatalf("forced delete: %v", err)
+	}
+	if len(changes) != 2 || changes[0].Forced || !changes[1].Forced {
+		t.Errorf("recorded changes %+v, want the second one marked forced", changes)
+	}
+}
+
+// changeLog collects the recorded changes
+type changeLog []Change
+
+func (l *changeLog) RecordChange(ctx context.Context, c Change) {
+	*l = append(*l, c)
+}
+
+func TestListHidesOwnerRecords(t *testing.T) {
+	ctx := context.Background()
+	c, fake := newTestClient(t, map[string]string{"owner_registry": "txt"})
+	fake.add("_acme-challenge.example.com.", `"manual"`)
+	if err := c.CreateTxtRecord(ctx, "example.com", "_acme-challenge", "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := c.ListTxtRecords(ctx, "example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"manual": false, "token": true}
+	if len(records) != len(want) {
+		t.Fatalf("listed %d records, want %d: %+v", len(records), len(want), records)
+	}
+	for _, rec := range records {
+		owned, ok := want[rec.Value]
+		if !ok || rec.Owned == nil || *rec.Owned != owned {
+			t.Errorf("record %s %q owned=%v, want one of %v", rec.Name, rec.Value, rec.Owned, want)
+		}
+	}
+}
+
+func TestFileRegistryReleaseMatchesZone(t *testing.T) {
+	ctx := context.Background()
+	r := &FileOwnerRegistry{Path: filepath.Join(t.TempDir(), "owners.json")}
+	// The same record claimed in a zone and in its delegated subzone
+	name := "_acme-challenge.sub.example.com."
+	for _, zone := range []string{"example.com", "sub.example.com"} {
+		if err := r.Claim(ctx, zone, name, "token"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.Release(ctx, "SUB.Example.com", strings.ToUpper(name), "token"); err != nil {
+		t.Fatal(err)
+	}
+	key := OwnerKey(name, "token")
+	if owned, err := r.Owned(ctx, "sub.example.com"); err != nil || owned[key] {
+		t.Errorf("sub.example.com still owns the record: %v, %v", owned, err)
+	}
+	if owned, err := r.Owned(ctx, "example.com"); err != nil || !owned[key] {
+		t.Errorf("example.com lost the record: %v, %v", owned, err)
+	}
+}
@@ -0,0 +1,333 @@
+package dnsclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is the per-query timeout used when Client.Timeout is zero
+const DefaultTimeout = 5 * time.Second
+
+// Client sends DNS queries to a recursive resolver and to authoritative nameservers
+type Client struct {
+	// Resolver is the recursive resolver ("host:port") used to find the
+	// authoritative nameservers of a zone and their addresses.
+	Resolver string
+	// Port is the port queried on authoritative nameservers. Defaults to 53.
+	Port string
+	// Timeout bounds each individual query. Defaults to DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Nameserver is an authoritative nameserver of a zone
+type Nameserver struct {
+	Host string // NS target, e.g. "ns1.example.com."
+	Addr string // "ip:port" to query, empty if Err is set
+	Err  error  // why Host could not be resolved
+}
+
+// TXTAnswer is the result of a TXT query against a single server
+type TXTAnswer struct {
+	Values        []string // one entry per record, character-strings joined
+	TTL           uint32
+	Rcode         uint8
+	Authoritative bool
+}
+
+// NewClient creates a client using resolver, or the system resolver when empty
+func NewClient(resolver string) *Client {
+	if resolver == "" {
+		resolver = SystemResolver()
+	}
+	return &Client{Resolver: withPort(resolver, "53")}
+}
+
+// SystemResolver returns the first nameserver from /etc/resolv.conf, falling back to 127.0.0.1:53
+func SystemResolver() string {
+	file, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "127.0.0.1:53"
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return withPort(fields[1], "53")
+		}
+	}
+	return "127.0.0.1:53"
+}
+
+func withPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+}
+
+func (c *Client) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return DefaultTimeout
+}
+
+func (c *Client) port() string {
+	if c.Port != "" {
+		return c.Port
+	}
+	return "53"
+}
+
+// Query sends a single question to server and returns the response
+func (c *Client) Query(ctx context.Context, server, name string, qtype uint16, recursion bool) (*Message, error) {
+	req := &Message{
+		Header: Header{
+			ID:               uint16(rand.UintN(1 << 16)),
+			RecursionDesired: recursion,
+		},
+		Questions: []Question{{Name: Fqdn(name), Type: qtype, Class: ClassINET}},
+	}
+	return c.Exchange(ctx, server, req)
+}
+
+// Exchange sends req to server over UDP and retries over TCP when the answer is truncated
+func (c *Client) Exchange(ctx context.Context, server string, req *Message) (*Message, error) {
+	packed, err := req.Pack()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.exchange(ctx, "udp", server, packed, req.ID)
+	if err == nil && resp.Truncated {
+		resp, err = c.exchange(ctx, "tcp", server, packed, req.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query %s: %w", server, err)
+	}
+	return resp, nil
+}
+
+func (c *Client) exchange(ctx context.Context, network, server string, packed []byte, id uint16) (*Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if network == "tcp" {
+		frame := binary.BigEndian.AppendUint16(nil, uint16(len(packed)))
+		if _, err := conn.Write(append(frame, packed...)); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+		return checkResponse(buf, id)
+	}
+
+	if _, err := conn.Write(packed); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := checkResponse(buf[:n], id)
+		if errors.Is(err, errIDMismatch) {
+			continue // stray datagram, keep waiting for ours
+		}
+		return resp, err
+	}
+}
+
+var errIDMismatch = errors.New("response ID mismatch")
+
+func checkResponse(buf []byte, id uint16) (*Message, error) {
+	resp, err := Unpack(buf)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ID != id || !resp.Response {
+		return nil, errIDMismatch
+	}
+	return resp, nil
+}
+
+// LookupNS returns the NS targets of zone as seen by the recursive resolver
+func (c *Client) LookupNS(ctx context.Context, zone string) ([]string, error) {
+	resp, err := c.Query(ctx, c.Resolver, zone, TypeNS, true)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode != RcodeSuccess {
+		return nil, fmt.Errorf("NS lookup for %s failed: %s", zone, RcodeString(resp.Rcode))
+	}
+	var hosts []string
+	for _, rr := range resp.Answers {
+		if rr.Type == TypeNS && strings.EqualFold(rr.Name, Fqdn(zone)) {
+			hosts = append(hosts, rr.Target)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no NS records found for %s", zone)
+	}
+	return hosts, nil
+}
+
+// LookupAddrs resolves host to its IPv4 and IPv6 addresses through the recursive resolver
+func (c *Client) LookupAddrs(ctx context.Context, host string) ([]net.IP, error) {
+	var ips []net.IP
+	var lastErr error
+	for _, qtype := range []uint16{TypeA, TypeAAAA} {
+		resp, err := c.Query(ctx, c.Resolver, host, qtype, true)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, rr := range resp.Answers {
+			if rr.Type == qtype && rr.IP != nil {
+				ips = append(ips, rr.IP)
+			}
+		}
+	}
+	if len(ips) == 0 {
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+	return ips, nil
+}
+
+// Nameservers returns one queryable address for every authoritative
+// nameserver of zone. Hosts that do not resolve are returned with Err set,
+// so one broken nameserver does not hide the others; it is an error only
+// if none of them resolves.
+func (c *Client) Nameservers(ctx context.Context, zone string) ([]Nameserver, error) {
+	hosts, err := c.LookupNS(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	var servers []Nameserver
+	var resolved int
+	for _, host := range hosts {
+		ips, err := c.LookupAddrs(ctx, host)
+		if err != nil {
+			servers = append(servers, Nameserver{Host: host, Err: fmt.Errorf("failed to resolve nameserver %s: %w", host, err)})
+			continue
+		}
+		// Prefer IPv4, many hosts have no IPv6 route
+		ip := ips[0]
+		for _, candidate := range ips {
+			if candidate.To4() != nil {
+				ip = candidate
+				break
+			}
+		}
+		servers = append(servers, Nameserver{Host: host, Addr: net.JoinHostPort(ip.String(), c.port())})
+		resolved++
+	}
+	if resolved == 0 {
+		return nil, fmt.Errorf("none of the nameservers of %s resolve: %w", zone, servers[0].Err)
+	}
+	return servers, nil
+}
+
+// LookupTXT queries server for the TXT records of name
+func (c *Client) LookupTXT(ctx context.Context, server, name string, recursion bool) (*TXTAnswer, error) {
+	resp, err := c.Query(ctx, server, name, TypeTXT, recursion)
+	if err != nil {
+		return nil, err
+	}
+	answer := &TXTAnswer{Rcode: resp.Rcode, Authoritative: resp.Authoritative}
+	for _, rr := range resp.Answers {
+		if rr.Type != TypeTXT || !strings.EqualFold(rr.Name, Fqdn(name)) {
+			continue
+		}
+		answer.Values = append(answer.Values, strings.Join(rr.TXT, ""))
+		answer.TTL = rr.TTL
+	}
+	if resp.Rcode != RcodeSuccess && resp.Rcode != RcodeNXDomain {
+		return answer, fmt.Errorf("TXT lookup for %s on %s failed: %s", name, server, RcodeString(resp.Rcode))
+	}
+	return answer, nil
+}
+
+// LookupSOA queries server for the SOA record of zone
+func (c *Client) LookupSOA(ctx context.Context, server, zone string, recursion bool) (*SOA, error) {
+	resp, err := c.Query(ctx, server, zone, TypeSOA, recursion)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode != RcodeSuccess {
+		return nil, fmt.Errorf("SOA lookup for %s on %s failed: %s", zone, server, RcodeString(resp.Rcode))
+	}
+	for _, rr := range resp.Answers {
+		if rr.Type == TypeSOA && rr.SOA != nil {
+			return rr.SOA, nil
+		}
+	}
+	return nil, fmt.Errorf("no SOA record for %s on %s", zone, server)
+}
+
+// Fqdn returns name with a trailing dot
+func Fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// RcodeString returns the mnemonic for a response code
+func RcodeString(rcode uint8) string {
+	switch rcode {
+	case RcodeSuccess:
+		return "NOERROR"
+	case RcodeFormErr:
+		return "FORMERR"
+	case RcodeServFail:
+		return "SERVFAIL"
+	case RcodeNXDomain:
+		return "NXDOMAIN"
+	case RcodeNotImp:
+		return "NOTIMP"
+	case RcodeRefused:
+		return "REFUSED"
+	case RcodeYXDomain:
+		return "YXDOMAIN"
+	case RcodeYXRRSet:
+		return "YXRRSET"
+	case RcodeNXRRSet:
+		return "NXRRSET"
+	case RcodeNotAuth:
+		return "NOTAUTH"
+	case RcodeNotZone:
+		return "NOTZONE"
+	default:
+		return fmt.Sprintf("RCODE%d", rcode)
+	}
+}
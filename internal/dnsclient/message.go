@@ -0,0 +1,407 @@
+package dnsclient
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Resource record types used by the client
+const (
+	TypeA     uint16 = 1
+	TypeNS    uint16 = 2
+	TypeCNAME uint16 = 5
+	TypeSOA   uint16 = 6
+	TypeTXT   uint16 = 16
+	TypeAAAA  uint16 = 28
+	TypeTSIG  uint16 = 250
+	TypeANY   uint16 = 255
+)
+
+// Classes. NONE and ANY carry special meaning in UPDATE messages (RFC 2136)
+const (
+	ClassINET uint16 = 1
+	ClassNONE uint16 = 254
+	ClassANY  uint16 = 255
+)
+
+// Opcodes
+const (
+	OpcodeQuery  uint8 = 0
+	OpcodeUpdate uint8 = 5
+)
+
+// Response codes
+const (
+	RcodeSuccess  uint8 = 0
+	RcodeFormErr  uint8 = 1
+	RcodeServFail uint8 = 2
+	RcodeNXDomain uint8 = 3
+	RcodeNotImp   uint8 = 4
+	RcodeRefused  uint8 = 5
+	RcodeYXDomain uint8 = 6
+	RcodeYXRRSet  uint8 = 7
+	RcodeNXRRSet  uint8 = 8
+	RcodeNotAuth  uint8 = 9
+	RcodeNotZone  uint8 = 10
+)
+
+var errShortMessage = errors.New("dns message too short")
+
+// Header is the fixed part of a DNS message
+type Header struct {
+	ID                 uint16
+	Response           bool
+	Opcode             uint8
+	Authoritative      bool
+	Truncated          bool
+	RecursionDesired   bool
+	RecursionAvailable bool
+	Rcode              uint8
+}
+
+// Question is an entry of the question section
+type Question struct {
+	Name  string
+	Type  uint16
+	Class uint16
+}
+
+// SOA holds the decoded fields of an SOA record
+type SOA struct {
+	MName   string
+	RName   string
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	Minimum uint32
+}
+
+// Resource is a resource record. Data always holds the raw rdata; the typed
+// fields are filled in when the record type is known.
+type Resource struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  []byte
+
+	Target string   // NS and CNAME
+	TXT    []string // TXT character-strings
+	IP     net.IP   // A and AAAA
+	SOA    *SOA
+}
+
+// Message is a DNS message
+type Message struct {
+	Header
+	Questions  []Question
+	Answers    []Resource
+	Authority  []Resource
+	Additional []Resource
+}
+
+// Pack encodes the message in wire format. Names are written uncompressed.
+func (m *Message) Pack() ([]byte, error) {
+	buf := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(buf[0:], m.ID)
+	var flags uint16
+	if m.Response {
+		flags |= 1 << 15
+	}
+	flags |= uint16(m.Opcode&0xF) << 11
+	if m.Authoritative {
+		flags |= 1 << 10
+	}
+	if m.Truncated {
+		flags |= 1 << 9
+	}
+	if m.RecursionDesired {
+		flags |= 1 << 8
+	}
+	if m.RecursionAvailable {
+		flags |= 1 << 7
+	}
+	flags |= uint16(m.Rcode & 0xF)
+	binary.BigEndian.PutUint16(buf[2:], flags)
+	binary.BigEndian.PutUint16(buf[4:], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(buf[6:], uint16(len(m.Answers)))
+	binary.BigEndian.PutUint16(buf[8:], uint16(len(m.Authority)))
+	binary.BigEndian.PutUint16(buf[10:], uint16(len(m.Additional)))
+
+	var err error
+	for _, q := range m.Questions {
+		if buf, err = appendName(buf, q.Name); err != nil {
+			return nil, err
+		}
+		buf = binary.BigEndian.AppendUint16(buf, q.Type)
+		buf = binary.BigEndian.AppendUint16(buf, q.Class)
+	}
+	for _, section := range [][]Resource{m.Answers, m.Authority, m.Additional} {
+		for i := range section {
+			if buf, err = appendResource(buf, &section[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf, nil
+}
+
+func appendResource(buf []byte, rr *Resource) ([]byte, error) {
+	var err error
+	if buf, err = appendName(buf, rr.Name); err != nil {
+		return nil, err
+	}
+	buf = binary.BigEndian.AppendUint16(buf, rr.Type)
+	buf = binary.BigEndian.AppendUint16(buf, rr.Class)
+	buf = binary.BigEndian.AppendUint32(buf, rr.TTL)
+
+	data := rr.Data
+	if data == nil {
+		if data, err = packRData(rr); err != nil {
+			return nil, err
+		}
+	}
+	if len(data) > 0xFFFF {
+		return nil, fmt.Errorf("rdata for %s too long", rr.Name)
+	}
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(data)))
+	return append(buf, data...), nil
+}
+
+// packRData builds rdata from the typed fields of a record
+func packRData(rr *Resource) ([]byte, error) {
+	switch rr.Type {
+	case TypeA:
+		ip := rr.IP.To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid A address for %s", rr.Name)
+		}
+		return []byte(ip), nil
+	case TypeAAAA:
+		ip := rr.IP.To16()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid AAAA address for %s", rr.Name)
+		}
+		return []byte(ip), nil
+	case TypeNS, TypeCNAME:
+		return appendName(nil, rr.Target)
+	case TypeTXT:
+		var data []byte
+		for _, s := range rr.TXT {
+			if len(s) > 255 {
+				return nil, fmt.Errorf("TXT character-string for %s longer than 255 bytes", rr.Name)
+			}
+			data = append(data, byte(len(s)))
+			data = append(data, s...)
+		}
+		return data, nil
+	case TypeSOA:
+		if rr.SOA == nil {
+			return nil, nil
+		}
+		data, err := appendName(nil, rr.SOA.MName)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = appendName(data, rr.SOA.RName); err != nil {
+			return nil, err
+		}
+		for _, v := range []uint32{rr.SOA.Serial, rr.SOA.Refresh, rr.SOA.Retry, rr.SOA.Expire, rr.SOA.Minimum} {
+			data = binary.BigEndian.AppendUint32(data, v)
+		}
+		return data, nil
+	default:
+		return nil, nil
+	}
+}
+
+// appendName writes name as a sequence of labels terminated by the root label
+func appendName(buf []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return append(buf, 0), nil
+	}
+	if len(name) > 253 {
+		return nil, fmt.Errorf("name %q too long", name)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return nil, fmt.Errorf("invalid label in name %q", name)
+		}
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+	}
+	return append(buf, 0), nil
+}
+
+// Unpack decodes a wire format message
+func Unpack(msg []byte) (*Message, error) {
+	if len(msg) < 12 {
+		return nil, errShortMessage
+	}
+	m := &Message{}
+	m.ID = binary.BigEndian.Uint16(msg[0:])
+	flags := binary.BigEndian.Uint16(msg[2:])
+	m.Response = flags&(1<<15) != 0
+	m.Opcode = uint8(flags>>11) & 0xF
+	m.Authoritative = flags&(1<<10) != 0
+	m.Truncated = flags&(1<<9) != 0
+	m.RecursionDesired = flags&(1<<8) != 0
+	m.RecursionAvailable = flags&(1<<7) != 0
+	m.Rcode = uint8(flags & 0xF)
+
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	counts := []int{
+		int(binary.BigEndian.Uint16(msg[6:])),
+		int(binary.BigEndian.Uint16(msg[8:])),
+		int(binary.BigEndian.Uint16(msg[10:])),
+	}
+
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		name, next, err := readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(msg) {
+			return nil, errShortMessage
+		}
+		m.Questions = append(m.Questions, Question{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(msg[next:]),
+			Class: binary.BigEndian.Uint16(msg[next+2:]),
+		})
+		off = next + 4
+	}
+
+	sections := []*[]Resource{&m.Answers, &m.Authority, &m.Additional}
+	for i, section := range sections {
+		for j := 0; j < counts[i]; j++ {
+			rr, next, err := readResource(msg, off)
+			if err != nil {
+				return nil, err
+			}
+			*section = append(*section, rr)
+			off = next
+		}
+	}
+	return m, nil
+}
+
+func readResource(msg []byte, off int) (Resource, int, error) {
+	var rr Resource
+	name, off, err := readName(msg, off)
+	if err != nil {
+		return rr, 0, err
+	}
+	if off+10 > len(msg) {
+		return rr, 0, errShortMessage
+	}
+	rr.Name = name
+	rr.Type = binary.BigEndian.Uint16(msg[off:])
+	rr.Class = binary.BigEndian.Uint16(msg[off+2:])
+	rr.TTL = binary.BigEndian.Uint32(msg[off+4:])
+	rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	if off+rdlen > len(msg) {
+		return rr, 0, errShortMessage
+	}
+	rr.Data = msg[off : off+rdlen]
+	if err := unpackRData(msg, off, &rr); err != nil {
+		return rr, 0, fmt.Errorf("invalid %d record for %s: %w", rr.Type, rr.Name, err)
+	}
+	return rr, off + rdlen, nil
+}
+
+// unpackRData fills the typed fields of rr. Names inside rdata may be
+// compressed, so they are read relative to the whole message.
+func unpackRData(msg []byte, off int, rr *Resource) error {
+	if len(rr.Data) == 0 {
+		return nil
+	}
+	var err error
+	switch rr.Type {
+	case TypeA:
+		if len(rr.Data) != net.IPv4len {
+			return errors.New("bad A length")
+		}
+		rr.IP = net.IP(append([]byte(nil), rr.Data...))
+	case TypeAAAA:
+		if len(rr.Data) != net.IPv6len {
+			return errors.New("bad AAAA length")
+		}
+		rr.IP = net.IP(append([]byte(nil), rr.Data...))
+	case TypeNS, TypeCNAME:
+		rr.Target, _, err = readName(msg, off)
+	case TypeTXT:
+		data := rr.Data
+		for len(data) > 0 {
+			n := int(data[0])
+			if 1+n > len(data) {
+				return errShortMessage
+			}
+			rr.TXT = append(rr.TXT, string(data[1:1+n]))
+			data = data[1+n:]
+		}
+	case TypeSOA:
+		soa := &SOA{}
+		var next int
+		if soa.MName, next, err = readName(msg, off); err != nil {
+			return err
+		}
+		if soa.RName, next, err = readName(msg, next); err != nil {
+			return err
+		}
+		if next+20 > len(msg) {
+			return errShortMessage
+		}
+		soa.Serial = binary.BigEndian.Uint32(msg[next:])
+		soa.Refresh = binary.BigEndian.Uint32(msg[next+4:])
+		soa.Retry = binary.BigEndian.Uint32(msg[next+8:])
+		soa.Expire = binary.BigEndian.Uint32(msg[next+12:])
+		soa.Minimum = binary.BigEndian.Uint32(msg[next+16:])
+		rr.SOA = soa
+	}
+	return err
+}
+
+// readName reads a possibly compressed name starting at off and returns it
+// in fully qualified form along with the offset just past it.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for hops := 0; ; hops++ {
+		if off >= len(msg) || hops > 127 {
+			return "", 0, errShortMessage
+		}
+		n := int(msg[off])
+		switch n & 0xC0 {
+		case 0x00:
+			if n == 0 {
+				if next < 0 {
+					next = off + 1
+				}
+				return strings.Join(labels, ".") + ".", next, nil
+			}
+			if off+1+n > len(msg) {
+				return "", 0, errShortMessage
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		case 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, errShortMessage
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+		default:
+			return "", 0, errors.New("unsupported label type")
+		}
+	}
+}
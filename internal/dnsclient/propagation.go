@@ -0,0 +1,52 @@
+package dnsclient
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultPollInterval is the delay between propagation checks
+const DefaultPollInterval = 5 * time.Second
+
+// WaitForTXT blocks until every one of servers, as returned by
+// Nameservers, reports value for name (present) or stops reporting it
+// (!present), or ctx is done. Servers that did not resolve are skipped.
+func (c *Client) WaitForTXT(ctx context.Context, servers []Nameserver, name, value string, present bool, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		pending := c.pendingServers(ctx, servers, name, value, present)
+		if len(pending) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for %s on %s: %w", name, strings.Join(pending, ", "), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// pendingServers returns the hosts whose answer for name does not match the expected state yet
+func (c *Client) pendingServers(ctx context.Context, servers []Nameserver, name, value string, present bool) []string {
+	var pending []string
+	for _, ns := range servers {
+		if ns.Err != nil {
+			continue
+		}
+		answer, err := c.LookupTXT(ctx, ns.Addr, name, false)
+		if err != nil || slices.Contains(answer.Values, value) != present {
+			pending = append(pending, strings.TrimSuffix(ns.Host, "."))
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
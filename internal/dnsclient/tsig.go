@@ -0,0 +1,277 @@
+package dnsclient
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// HmacSHA256 is the TSIG algorithm name for HMAC-SHA256 (RFC 8945)
+const HmacSHA256 = "hmac-sha256."
+
+// DefaultFudge is the permitted clock skew, in seconds, for signed messages
+const DefaultFudge = 300
+
+// TSIG error codes, carried in the TSIG record rather than the header
+const (
+	TSIGBadSig  uint16 = 16
+	TSIGBadKey  uint16 = 17
+	TSIGBadTime uint16 = 18
+)
+
+// TSIG verification failures. Each maps to the TSIG error code of the same name.
+var (
+	ErrNoTSIG   = errors.New("message is not signed")
+	ErrBadKey   = errors.New("unknown TSIG key or algorithm")
+	ErrBadSig   = errors.New("TSIG signature mismatch")
+	ErrBadTime  = errors.New("TSIG time outside the permitted window")
+	errBadTSIG  = errors.New("malformed TSIG record")
+	errTSIGLast = errors.New("TSIG record must be the last additional record")
+)
+
+// TSIGKey is a shared secret used to sign and verify messages
+type TSIGKey struct {
+	Name      string // key name, e.g. "certbot."
+	Algorithm string // only HmacSHA256 is supported
+	Secret    []byte
+}
+
+// TSIG is the decoded rdata of a TSIG record
+type TSIG struct {
+	KeyName    string
+	Algorithm  string
+	TimeSigned uint64
+	Fudge      uint16
+	MAC        []byte
+	OriginalID uint16
+	Error      uint16
+	OtherData  []byte
+}
+
+// VerifyTSIG checks the TSIG record that ends msg using the key returned by
+// lookup. requestMAC is nil for requests and the MAC of the signed request
+// when verifying a response. It returns the decoded TSIG record, which is also returned on
+// ErrBadSig and ErrBadTime so the caller can build a signed error response.
+func VerifyTSIG(msg, requestMAC []byte, lookup func(name string) (*TSIGKey, bool), now time.Time) (*TSIG, error) {
+	body, tsig, err := splitTSIG(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := lookup(canonicalName(tsig.KeyName))
+	if !ok || canonicalName(tsig.Algorithm) != HmacSHA256 || canonicalName(key.Algorithm) != HmacSHA256 {
+		return tsig, ErrBadKey
+	}
+
+	expected := tsigMAC(key, requestMAC, body, tsig)
+	if !hmac.Equal(expected, tsig.MAC) {
+		return tsig, ErrBadSig
+	}
+
+	signed := int64(tsig.TimeSigned)
+	if delta := now.Unix() - signed; delta > int64(tsig.Fudge) || -delta > int64(tsig.Fudge) {
+		return tsig, ErrBadTime
+	}
+	return tsig, nil
+}
+
+// SignTSIG appends a TSIG record signed with key to the packed message msg.
+// For responses, requestMAC is the MAC of the signed request.
+func SignTSIG(msg []byte, key *TSIGKey, requestMAC []byte, now time.Time, tsigError uint16) ([]byte, error) {
+	if len(msg) < 12 {
+		return nil, errShortMessage
+	}
+	tsig := &TSIG{
+		KeyName:    canonicalName(key.Name),
+		Algorithm:  canonicalName(key.Algorithm),
+		TimeSigned: uint64(now.Unix()),
+		Fudge:      DefaultFudge,
+		OriginalID: binary.BigEndian.Uint16(msg[0:]),
+		Error:      tsigError,
+	}
+	if tsigError == TSIGBadTime {
+		// Tell the client our time, RFC 8945 section 5.2.3
+		tsig.OtherData = make([]byte, 6)
+		putUint48(tsig.OtherData, uint64(now.Unix()))
+	}
+	if tsigError == TSIGBadSig || tsigError == TSIGBadKey {
+		// Responses to unverifiable requests are not signed
+		tsig.MAC = nil
+	} else {
+		tsig.MAC = tsigMAC(key, requestMAC, msg, tsig)
+	}
+	return appendTSIG(msg, tsig)
+}
+
+// tsigMAC computes the MAC over the request MAC (for responses), the
+// message without its TSIG record and the TSIG variables (RFC 8945, 4.3.3)
+func tsigMAC(key *TSIGKey, requestMAC, body []byte, tsig *TSIG) []byte {
+	h := hmac.New(sha256.New, key.Secret)
+	if requestMAC != nil {
+		h.Write(binary.BigEndian.AppendUint16(nil, uint16(len(requestMAC))))
+		h.Write(requestMAC)
+	}
+	h.Write(body)
+
+	keyName, _ := appendName(nil, canonicalName(tsig.KeyName))
+	algorithm, _ := appendName(nil, canonicalName(tsig.Algorithm))
+	vars := append([]byte(nil), keyName...)
+	vars = binary.BigEndian.AppendUint16(vars, ClassANY)
+	vars = binary.BigEndian.AppendUint32(vars, 0) // TTL
+	vars = append(vars, algorithm...)
+	vars = appendUint48(vars, tsig.TimeSigned)
+	vars = binary.BigEndian.AppendUint16(vars, tsig.Fudge)
+	vars = binary.BigEndian.AppendUint16(vars, tsig.Error)
+	vars = binary.BigEndian.AppendUint16(vars, uint16(len(tsig.OtherData)))
+	vars = append(vars, tsig.OtherData...)
+	h.Write(vars)
+	return h.Sum(nil)
+}
+
+// splitTSIG returns msg without its trailing TSIG record, with ARCOUNT
+// decremented and the original ID restored, and the decoded TSIG record.
+func splitTSIG(msg []byte) ([]byte, *TSIG, error) {
+	if len(msg) < 12 {
+		return nil, nil, errShortMessage
+	}
+	arcount := int(binary.BigEndian.Uint16(msg[10:]))
+	if arcount == 0 {
+		return nil, nil, ErrNoTSIG
+	}
+
+	// Walk to the start of the last record
+	off := 12
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[4:])); i++ {
+		_, next, err := readName(msg, off)
+		if err != nil {
+			return nil, nil, err
+		}
+		off = next + 4
+	}
+	total := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + arcount
+	var last Resource
+	lastOff := 0
+	for i := 0; i < total; i++ {
+		rr, next, err := readResource(msg, off)
+		if err != nil {
+			return nil, nil, err
+		}
+		if rr.Type == TypeTSIG && i != total-1 {
+			return nil, nil, errTSIGLast
+		}
+		last, lastOff = rr, off
+		off = next
+	}
+	if last.Type != TypeTSIG {
+		return nil, nil, ErrNoTSIG
+	}
+
+	tsig, err := parseTSIG(last)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body := append([]byte(nil), msg[:lastOff]...)
+	binary.BigEndian.PutUint16(body[0:], tsig.OriginalID)
+	binary.BigEndian.PutUint16(body[10:], uint16(arcount-1))
+	return body, tsig, nil
+}
+
+// parseTSIG decodes TSIG rdata. The algorithm name is never compressed.
+func parseTSIG(rr Resource) (*TSIG, error) {
+	data := rr.Data
+	algorithm, off, err := readName(data, 0)
+	if err != nil {
+		return nil, errBadTSIG
+	}
+	if off+10 > len(data) {
+		return nil, errBadTSIG
+	}
+	tsig := &TSIG{KeyName: rr.Name, Algorithm: algorithm}
+	tsig.TimeSigned = uint48(data[off:])
+	tsig.Fudge = binary.BigEndian.Uint16(data[off+6:])
+	macLen := int(binary.BigEndian.Uint16(data[off+8:]))
+	off += 10
+	if off+macLen+6 > len(data) {
+		return nil, errBadTSIG
+	}
+	tsig.MAC = append([]byte(nil), data[off:off+macLen]...)
+	off += macLen
+	tsig.OriginalID = binary.BigEndian.Uint16(data[off:])
+	tsig.Error = binary.BigEndian.Uint16(data[off+2:])
+	otherLen := int(binary.BigEndian.Uint16(data[off+4:]))
+	off += 6
+	if off+otherLen != len(data) {
+		return nil, errBadTSIG
+	}
+	tsig.OtherData = append([]byte(nil), data[off:]...)
+	return tsig, nil
+}
+
+// appendTSIG appends tsig as the last additional record of msg
+func appendTSIG(msg []byte, tsig *TSIG) ([]byte, error) {
+	algorithm, err := appendName(nil, tsig.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	data := append([]byte(nil), algorithm...)
+	data = appendUint48(data, tsig.TimeSigned)
+	data = binary.BigEndian.AppendUint16(data, tsig.Fudge)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(tsig.MAC)))
+	data = append(data, tsig.MAC...)
+	data = binary.BigEndian.AppendUint16(data, tsig.OriginalID)
+	data = binary.BigEndian.AppendUint16(data, tsig.Error)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(tsig.OtherData)))
+	data = append(data, tsig.OtherData...)
+
+	out := append([]byte(nil), msg...)
+	out, err = appendResource(out, &Resource{Name: tsig.KeyName, Type: TypeTSIG, Class: ClassANY, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	arcount := binary.BigEndian.Uint16(out[10:])
+	binary.BigEndian.PutUint16(out[10:], arcount+1)
+	return out, nil
+}
+
+// canonicalName returns name in lower case with a trailing dot
+func canonicalName(name string) string {
+	return strings.ToLower(Fqdn(name))
+}
+
+func uint48(b []byte) uint64 {
+	return uint64(b[0])<<40 | uint64(b[1])<<32 | uint64(b[2])<<24 | uint64(b[3])<<16 | uint64(b[4])<<8 | uint64(b[5])
+}
+
+func putUint48(b []byte, v uint64) {
+	for i := 5; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+}
+
+func appendUint48(b []byte, v uint64) []byte {
+	var buf [6]byte
+	putUint48(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// TSIGErrorString returns the mnemonic for a TSIG error code
+func TSIGErrorString(code uint16) string {
+	switch code {
+	case 0:
+		return "NOERROR"
+	case TSIGBadSig:
+		return "BADSIG"
+	case TSIGBadKey:
+		return "BADKEY"
+	case TSIGBadTime:
+		return "BADTIME"
+	default:
+		return fmt.Sprintf("TSIG%d", code)
+	}
+}
@@ -0,0 +1,131 @@
+// Package health periodically checks that the configured cPanel accounts
+// are reachable and accept their credentials, for the readiness endpoint.
+package health
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultInterval is how often accounts are checked unless configured otherwise
+const DefaultInterval = time.Minute
+
+// checkTimeout bounds a single account check
+const checkTimeout = 10 * time.Second
+
+// Pinger makes a cheap authenticated call, implemented by *cpanel.CPanelConfig
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Status is the result of the most recent check of an account
+type Status struct {
+	Account   string
+	Err       error // nil if the check passed
+	CheckedAt time.Time
+	Latency   time.Duration
+	LastOK    time.Time // zero if no check has passed yet
+}
+
+// Checker checks every account on an interval and keeps the latest results
+type Checker struct {
+	Accounts map[string]Pinger
+	Interval time.Duration
+	// OnChange, if set, is called when an account starts failing its
+	// checks, with the error, and when it passes again, with nil
+	OnChange func(account string, err error)
+
+	mu     sync.RWMutex
+	status map[string]Status
+}
+
+// NewChecker returns a checker for accounts, keyed by the label they are
+// reported under
+func NewChecker(accounts map[string]Pinger, interval time.Duration) *Checker {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Checker{Accounts: accounts, Interval: interval, status: make(map[string]Status)}
+}
+
+// Run checks all accounts right away and then every Interval until ctx is done
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		c.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check checks all accounts concurrently and records the results. A change
+// between passing and failing is logged with the full error.
+func (c *Checker) Check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for name, p := range c.Accounts {
+		wg.Add(1)
+		go func(name string, p Pinger) {
+			defer wg.Done()
+			c.checkAccount(ctx, name, p)
+		}(name, p)
+	}
+	wg.Wait()
+}
+
+func (c *Checker) checkAccount(ctx context.Context, name string, p Pinger) {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	start := time.Now()
+	err := p.Ping(ctx)
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev, checked := c.status[name]
+	s := Status{Account: name, Err: err, CheckedAt: now, Latency: now.Sub(start), LastOK: prev.LastOK}
+	if err == nil {
+		s.LastOK = now
+	}
+	c.status[name] = s
+
+	switch {
+	case err != nil && (!checked || prev.Err == nil):
+		log.Printf("cPanel account %s is not ready: %v", name, err)
+	case err == nil && checked && prev.Err != nil:
+		log.Printf("cPanel account %s is ready again", name)
+	default:
+		return
+	}
+	if c.OnChange != nil {
+		c.OnChange(name, err)
+	}
+}
+
+// Ready reports whether every account passed its most recent check, along
+// with the status of each account sorted by name. Accounts that have not
+// been checked yet count as not ready.
+func (c *Checker) Ready() (bool, []Status) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ready := true
+	statuses := make([]Status, 0, len(c.Accounts))
+	for name := range c.Accounts {
+		s, ok := c.status[name]
+		if !ok || s.Err != nil {
+			ready = false
+		}
+		if !ok {
+			s = Status{Account: name}
+		}
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Account < statuses[j].Account })
+	return ready, statuses
+}
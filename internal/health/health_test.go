@@ -0,0 +1,50 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// pinger fails while err is set
+type pinger struct {
+	err error
+}
+
+func (p *pinger) Ping(ctx context.Context) error {
+	return p.err
+}
+
+func TestOnChangeReportsTransitions(t *testing.T) {
+	p := &pinger{}
+	c := NewChecker(map[string]Pinger{"user": p}, time.Minute)
+	var changes []string
+	c.OnChange = func(account string, err error) {
+		changes = append(changes, fmt.Sprintf("%s %v", account, err))
+	}
+
+	down := errors.New("credentials rejected")
+	for _, err := range []error{nil, nil, down, down, nil, nil, down} {
+		p.err = err
+		c.Check(context.Background())
+	}
+	want := []string{"user credentials rejected", "user <nil>", "user credentials rejected"}
+	if fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Errorf("OnChange calls %q, want %q", changes, want)
+	}
+}
+
+func TestOnChangeReportsInitialFailure(t *testing.T) {
+	p := &pinger{err: errors.New("connection refused")}
+	c := NewChecker(map[string]Pinger{"user": p}, time.Minute)
+	calls := 0
+	c.OnChange = func(account string, err error) { calls++ }
+
+	c.Check(context.Background())
+	c.Check(context.Background())
+	if calls != 1 {
+		t.Errorf("OnChange called %d times for a failing account, want 1", calls)
+	}
+}
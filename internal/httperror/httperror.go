@@ -0,0 +1,118 @@
+// Package httperror defines the JSON error body shared by all HTTP
+// endpoints, with stable codes clients can act on, and maps the errors of
+// the validation, auth and cPanel layers to it.
+package httperror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"dns-proxy/internal/auth"
+	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/validate"
+)
+
+// Error codes. They are part of the API and do not change.
+const (
+	CodeInvalidRequest        = "invalid_request"         // body is not the expected JSON
+	CodeInvalidField          = "invalid_field"           // a field failed validation, see Field
+	CodeUnauthorized          = "unauthorized"            // missing or wrong credentials
+	CodeForbidden             = "forbidden"               // the token's scope does not cover the record
+	CodeNotFound              = "not_found"               // no such record or endpoint
+	CodeMethodNotAllowed      = "method_not_allowed"      // the endpoint does not take the method, see the Allow header
+	CodeNotOwned              = "not_owned"               // the record was not created by the proxy, see force
+	CodePayloadTooLarge       = "payload_too_large"       // request body over the limit
+	CodeRateLimited           = "rate_limited"            // retry after the Retry-After header
+	CodeOverloaded            = "overloaded"              // the server is too busy, retry later
+	CodeCanceled              = "canceled"                // the request was canceled before it finished
+	CodeCPanelAuth            = "cpanel_auth_failed"      // cPanel rejected the proxy's credentials
+	CodeCPanelUnavailable     = "cpanel_unavailable"      // cPanel could not be reached
+	CodeCPanelTimeout         = "cpanel_timeout"          // cPanel did not answer in time
+	CodeCPanelRejected        = "cpanel_rejected"         // cPanel refused the change
+	CodeCPanelInvalidResponse = "cpanel_invalid_response" // cPanel's answer could not be understood
+	CodeInternal              = "internal_error"
+)
+
+// Error is the body of every error response
+type Error struct {
+	Status    int    `json:"-"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Field     string `json:"field,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	Retryable bool   `json:"retryable"`
+
+	// LegacyCode is sent as "error" for clients of the acme-dns protocol,
+	// which expect {"error": "<code>"}
+	LegacyCode string `json:"error,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// New returns a non-retryable error
+func New(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// From maps err to the error sent to clients. Messages are safe to show;
+// details of cPanel failures are left for the server log.
+func From(err error) *Error {
+	var httpErr *Error
+	var fieldErr *validate.FieldError
+	var scopeErr *auth.ScopeError
+	var tooLarge *http.MaxBytesError
+	var cpErr *cpanel.Error
+	switch {
+	case errors.As(err, &httpErr):
+		e := *httpErr
+		return &e
+	case errors.As(err, &tooLarge):
+		return New(http.StatusRequestEntityTooLarge, CodePayloadTooLarge, "request body too large")
+	case errors.As(err, &fieldErr):
+		if fieldErr.Field == "body" {
+			return &Error{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Message: fieldErr.Error()}
+		}
+		return &Error{Status: http.StatusBadRequest, Code: CodeInvalidField, Message: fieldErr.Error(), Field: fieldErr.Field}
+	case errors.As(err, &scopeErr):
+		return New(http.StatusForbidden, CodeForbidden, "token is not allowed to "+scopeErr.Op+" "+scopeErr.Name)
+	case errors.Is(err, cpanel.ErrRecordNotFound):
+		return New(http.StatusNotFound, CodeNotFound, "TXT record not found")
+	case errors.Is(err, cpanel.ErrNotOwned):
+		return New(http.StatusConflict, CodeNotOwned, "TXT record was not created by the proxy")
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Status: http.StatusGatewayTimeout, Code: CodeCPanelTimeout, Message: "cPanel did not respond in time", Retryable: true}
+	case errors.Is(err, context.Canceled):
+		return &Error{Status: http.StatusServiceUnavailable, Code: CodeCanceled, Message: "request canceled", Retryable: true}
+	case errors.As(err, &cpErr):
+		switch cpErr.Kind {
+		case cpanel.KindAuth:
+			return New(http.StatusBadGateway, CodeCPanelAuth, "cPanel rejected the proxy credentials")
+		case cpanel.KindUnavailable:
+			return &Error{Status: http.StatusServiceUnavailable, Code: CodeCPanelUnavailable, Message: "cPanel is unavailable", Retryable: true}
+		case cpanel.KindAPI:
+			return New(http.StatusBadGateway, CodeCPanelRejected, "cPanel rejected the request")
+		default:
+			return &Error{Status: http.StatusBadGateway, Code: CodeCPanelInvalidResponse, Message: "unexpected response from cPanel", Retryable: true}
+		}
+	default:
+		return New(http.StatusInternalServerError, CodeInternal, "internal error")
+	}
+}
+
+// Write sends e as JSON with its status, tagged with the request's ID
+func Write(w http.ResponseWriter, r *http.Request, e *Error) {
+	body := *e
+	body.RequestID = RequestIDFromContext(r.Context())
+	status := e.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
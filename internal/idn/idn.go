@@ -0,0 +1,63 @@
+// Package idn converts internationalized domain names between the Unicode
+// form users type (U-labels) and the ASCII form cPanel and DNS use (A-labels).
+package idn
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// profile follows the IDNA2008 lookup rules but, unlike idna.Lookup, allows
+// underscore labels such as _acme-challenge and _domainkey.
+var profile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.Transitional(false),
+	idna.StrictDomainName(false),
+)
+
+// ToASCII converts name to its lower-case A-label form. A trailing dot and
+// a leading "*" wildcard label are preserved. Empty input is returned as-is.
+func ToASCII(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	trimmed := strings.TrimSuffix(name, ".")
+	wildcard := trimmed == "*" || strings.HasPrefix(trimmed, "*.")
+	if wildcard {
+		trimmed = strings.TrimPrefix(strings.TrimPrefix(trimmed, "*"), ".")
+	}
+
+	ascii := ""
+	if trimmed != "" {
+		var err error
+		if ascii, err = profile.ToASCII(trimmed); err != nil {
+			return "", fmt.Errorf("invalid internationalized domain name %q: %w", name, err)
+		}
+	}
+
+	if wildcard {
+		ascii = strings.TrimSuffix("*."+ascii, ".")
+	}
+	if strings.HasSuffix(name, ".") {
+		ascii += "."
+	}
+	return ascii, nil
+}
+
+// ToUnicode converts the A-labels in name to U-labels for display. Labels
+// that cannot be decoded are left unchanged.
+func ToUnicode(name string) string {
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if !strings.HasPrefix(strings.ToLower(label), "xn--") {
+			continue
+		}
+		if u, err := profile.ToUnicode(label); err == nil {
+			labels[i] = u
+		}
+	}
+	return strings.Join(labels, ".")
+}
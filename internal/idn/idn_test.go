@@ -0,0 +1,49 @@
+package idn
+
+import "testing"
+
+func TestToASCII(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"", ""},
+		{"example.com", "example.com"},
+		{"Example.COM.", "example.com."},
+		{"bücher.de", "xn--bcher-kva.de"},
+		{"BÜCHER.de.", "xn--bcher-kva.de."},
+		{"xn--bcher-kva.de", "xn--bcher-kva.de"},
+		{"_acme-challenge.bücher.de", "_acme-challenge.xn--bcher-kva.de"},
+		{"*.bücher.de", "*.xn--bcher-kva.de"},
+		{"*", "*"},
+		{"*.", "*."},
+	}
+	for _, tt := range tests {
+		got, err := ToASCII(tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("ToASCII(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	for _, name := range []string{"xn--zz.de", "‮example.com"} {
+		if got, err := ToASCII(name); err == nil {
+			t.Errorf("ToASCII(%q) = %q, want an error", name, got)
+		}
+	}
+}
+
+func TestToUnicode(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"xn--bcher-kva.de", "bücher.de"},
+		{"_acme-challenge.XN--BCHER-KVA.de.", "_acme-challenge.bücher.de."},
+		{"example.com", "example.com"},
+		// Labels that do not decode are left alone
+		{"xn--zz.de", "xn--zz.de"},
+	}
+	for _, tt := range tests {
+		if got := ToUnicode(tt.name); got != tt.want {
+			t.Errorf("ToUnicode(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
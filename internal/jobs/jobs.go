@@ -0,0 +1,417 @@
+// Package jobs runs record changes in the background for API callers that
+// can't wait for cPanel. The queue is saved to disk on every change so
+// jobs survive restarts, failed attempts are retried with backoff and
+// jobs for the same zone run one at a time in submission order.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dns-proxy/internal/audit"
+	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/httperror"
+	"dns-proxy/internal/leases"
+	"dns-proxy/internal/statefile"
+)
+
+// Operations
+const (
+	OpCreate = "create"
+	OpEdit   = "edit"
+	OpDelete = "delete"
+)
+
+// Job states
+const (
+	StatusQueued    = "queued"  // waiting for a worker, or for its next attempt
+	StatusRunning   = "running" // an attempt is in progress
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed" // a permanent error, or out of attempts
+)
+
+// Defaults for the Queue settings
+const (
+	DefaultWorkers     = 2
+	DefaultMaxAttempts = 5
+	DefaultTimeout     = 30 * time.Second
+	DefaultRetention   = 24 * time.Hour
+)
+
+// maxBackoff caps the delay between attempts
+const maxBackoff = time.Minute
+
+// Job is a record change and its progress
+type Job struct {
+	ID       string `json:"id"`
+	Op       string `json:"op"`
+	Zone     string `json:"zone"`
+	Name     string `json:"name"`                // relative to Zone
+	OldValue string `json:"old_value,omitempty"` // the value to delete or replace
+	NewValue string `json:"new_value,omitempty"` // the value to create or replace with
+
+	// ExpiresIn gives a created record a lease of that many seconds,
+	// counted from when the record is created
+	ExpiresIn int64 `json:"expires_in,omitempty"`
+	// Force skips the ownership check of an edit or delete
+	Force bool `json:"force,omitempty"`
+
+	// Who submitted the job, for authorization of status requests and
+	// for the audit log
+	TokenID    string `json:"token_id,omitempty"`
+	Identity   string `json:"identity,omitempty"`
+	ClientCert string `json:"client_cert,omitempty"`
+	SourceIP   string `json:"source_ip,omitempty"`
+	RequestID  string `json:"request_id,omitempty"`
+
+	Status      string           `json:"status"`
+	Attempts    int              `json:"attempts"`
+	Error       *httperror.Error `json:"error,omitempty"` // last error, final if Status is failed
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+	NextAttempt *time.Time       `json:"next_attempt,omitempty"`
+	FinishedAt  *time.Time       `json:"finished_at,omitempty"`
+}
+
+func (j *Job) done() bool {
+	return j.Status == StatusSucceeded || j.Status == StatusFailed
+}
+
+// lockZone is the cPanel zone the job changes. Jobs are serialized on it
+// rather than on Zone, which may name a subdomain of the same cPanel zone.
+func (j *Job) lockZone() string {
+	zone, _ := cpanel.RecordName(j.Zone, j.Name)
+	return zone
+}
+
+// Service applies record changes, implemented by *cpanel.CPanelConfig
+type Service interface {
+	ListTxtRecords(ctx context.Context, domain, keyFilter string) ([]cpanel.TxtRecord, error)
+	CreateTxtRecord(ctx context.Context, domain, key, value string) error
+	EditTxtRecord(ctx context.Context, domain, key, oldValue, newValue string) error
+	DeleteTxtRecord(ctx context.Context, domain, key, value string) error
+}
+
+// state is the file format of the queue
+type state struct {
+	Jobs []*Job `json:"jobs"`
+}
+
+// Queue holds the jobs and runs them on a pool of workers
+type Queue struct {
+	Path        string
+	Service     Service
+	Workers     int
+	MaxAttempts int
+	Timeout     time.Duration // per attempt
+	Retention   time.Duration // how long finished jobs can be looked up
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	jobs    map[string]*Job
+	order   []*Job          // by submission
+	busy    map[string]bool // zones with a running job
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// Open loads the queue saved at path. Jobs that were running when the
+// process stopped are queued again. Their change may have reached cPanel
+// before the stop, which run checks for as with any repeated attempt.
+func Open(path string, svc Service) (*Queue, error) {
+	q := &Queue{
+		Path:        path,
+		Service:     svc,
+		Workers:     DefaultWorkers,
+		MaxAttempts: DefaultMaxAttempts,
+		Timeout:     DefaultTimeout,
+		Retention:   DefaultRetention,
+		jobs:        make(map[string]*Job),
+		busy:        make(map[string]bool),
+	}
+	q.cond = sync.NewCond(&q.mu)
+
+	var st state
+	if err := statefile.Load(path, &st); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(st.Jobs, func(i, j int) bool { return st.Jobs[i].CreatedAt.Before(st.Jobs[j].CreatedAt) })
+	for _, j := range st.Jobs {
+		if j.Status == StatusRunning {
+			j.Status = StatusQueued
+		}
+		q.jobs[j.ID] = j
+		q.order = append(q.order, j)
+	}
+	return q, nil
+}
+
+// Start starts the workers
+func (q *Queue) Start() {
+	for i := 0; i < q.Workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+}
+
+// Drain stops the workers after their current attempt. Queued jobs stay
+// in the file and run after the next start.
+func (q *Queue) Drain() {
+	q.mu.Lock()
+	q.stopped = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+// Submit queues j, filling in its ID and state, and returns a copy
+func (q *Queue) Submit(j Job) (Job, error) {
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+	now := time.Now().UTC()
+	j.ID = id
+	j.Status = StatusQueued
+	j.Attempts = 0
+	j.Error = nil
+	j.CreatedAt, j.UpdatedAt = now, now
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return Job{}, &httperror.Error{Status: http.StatusServiceUnavailable, Code: httperror.CodeOverloaded, Message: "shutting down", Retryable: true}
+	}
+	q.jobs[j.ID] = &j
+	q.order = append(q.order, &j)
+	if err := q.save(); err != nil {
+		delete(q.jobs, j.ID)
+		q.order = q.order[:len(q.order)-1]
+		return Job{}, err
+	}
+	q.cond.Broadcast()
+	return j, nil
+}
+
+// Get returns a copy of the job with the given ID
+func (q *Queue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+func (q *Queue) worker() {
+	defer q.wg.Done()
+	for {
+		q.mu.Lock()
+		j := q.waitForJob()
+		if j == nil {
+			q.mu.Unlock()
+			return
+		}
+		j.Status = StatusRunning
+		j.Attempts++
+		j.NextAttempt = nil
+		j.UpdatedAt = time.Now().UTC()
+		q.busy[j.lockZone()] = true
+		q.saveOrLog()
+		run := *j
+		q.mu.Unlock()
+
+		err := q.run(run)
+
+		q.mu.Lock()
+		delete(q.busy, j.lockZone())
+		q.finishAttempt(j, err)
+		q.saveOrLog()
+		q.cond.Broadcast()
+		q.mu.Unlock()
+	}
+}
+
+// waitForJob blocks until a job can run or the queue is stopped, in which
+// case it returns nil. It must be called with q.mu held.
+func (q *Queue) waitForJob() *Job {
+	for !q.stopped {
+		j, wait := q.next(time.Now())
+		if j != nil {
+			return j
+		}
+		if wait > 0 {
+			// Wake up when the earliest retry is due
+			t := time.AfterFunc(wait, q.cond.Broadcast)
+			q.cond.Wait()
+			t.Stop()
+		} else {
+			q.cond.Wait()
+		}
+	}
+	return nil
+}
+
+// next returns the oldest queued job that may run now. A zone is blocked
+// while one of its jobs runs or an earlier job waits for its retry, so the
+// changes to a zone are applied in order. If nothing can run, wait is how
+// long until the next retry is due, 0 if none is.
+func (q *Queue) next(now time.Time) (j *Job, wait time.Duration) {
+	blocked := make(map[string]bool, len(q.busy))
+	for zone := range q.busy {
+		blocked[zone] = true
+	}
+	for _, j := range q.order {
+		zone := j.lockZone()
+		if j.Status != StatusQueued || blocked[zone] {
+			continue
+		}
+		if j.NextAttempt != nil && j.NextAttempt.After(now) {
+			blocked[zone] = true
+			if d := j.NextAttempt.Sub(now); wait == 0 || d < wait {
+				wait = d
+			}
+			continue
+		}
+		return j, 0
+	}
+	return nil, wait
+}
+
+// run makes one attempt at the change, attributed to the job's submitter
+func (q *Queue) run(j Job) error {
+	ctx := audit.WithActor(context.Background(), audit.Actor{Identity: j.Identity, ClientCert: j.ClientCert, SourceIP: j.SourceIP})
+	if j.ExpiresIn > 0 {
+		ctx = leases.WithTTL(ctx, time.Duration(j.ExpiresIn)*time.Second)
+	}
+	if j.Force {
+		ctx = cpanel.WithForce(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, q.Timeout)
+	defer cancel()
+
+	// An earlier attempt, cut off by a timeout or a restart, may have
+	// made the change without the job learning about it
+	retry := j.Attempts > 1
+
+	switch j.Op {
+	case OpCreate:
+		if retry {
+			exists, err := q.exists(ctx, j)
+			if err != nil {
+				return err
+			}
+			if exists {
+				log.Printf("job %s: %s.%s already has the value, an earlier attempt created it", j.ID, j.Name, j.Zone)
+				return nil
+			}
+		}
+		return q.Service.CreateTxtRecord(ctx, j.Zone, j.Name, j.NewValue)
+	case OpEdit:
+		return q.Service.EditTxtRecord(ctx, j.Zone, j.Name, j.OldValue, j.NewValue)
+	case OpDelete:
+		err := q.Service.DeleteTxtRecord(ctx, j.Zone, j.Name, j.OldValue)
+		if retry && errors.Is(err, cpanel.ErrRecordNotFound) {
+			log.Printf("job %s: %s.%s no longer has the value, an earlier attempt deleted it", j.ID, j.Name, j.Zone)
+			return nil
+		}
+		return err
+	}
+	return fmt.Errorf("unknown job operation %q", j.Op)
+}
+
+// exists reports whether the record a create job makes is already in the zone
+func (q *Queue) exists(ctx context.Context, j Job) (bool, error) {
+	zone, name := cpanel.RecordName(j.Zone, j.Name)
+	records, err := q.Service.ListTxtRecords(ctx, zone, "")
+	if err != nil {
+		return false, err
+	}
+	fqdn := name + "." + zone + "."
+	for _, rec := range records {
+		if strings.EqualFold(rec.Name, fqdn) && rec.Value == j.NewValue {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// finishAttempt records the outcome of an attempt and schedules a retry
+// for errors that may go away
+func (q *Queue) finishAttempt(j *Job, err error) {
+	now := time.Now().UTC()
+	j.UpdatedAt = now
+	if err == nil {
+		j.Status = StatusSucceeded
+		j.Error = nil
+		j.FinishedAt = &now
+		log.Printf("job %s: %s %s.%s succeeded after %d attempt(s)", j.ID, j.Op, j.Name, j.Zone, j.Attempts)
+		return
+	}
+
+	j.Error = httperror.From(err)
+	j.Error.RequestID = j.RequestID
+	if j.Error.Retryable && j.Attempts < q.MaxAttempts {
+		next := now.Add(backoff(j.Attempts))
+		j.Status = StatusQueued
+		j.NextAttempt = &next
+		log.Printf("job %s: attempt %d failed, retrying at %s: %v", j.ID, j.Attempts, next.Format(time.RFC3339), err)
+		return
+	}
+	j.Status = StatusFailed
+	j.FinishedAt = &now
+	log.Printf("job %s: %s %s.%s failed after %d attempt(s): %v", j.ID, j.Op, j.Name, j.Zone, j.Attempts, err)
+}
+
+// backoff returns the delay after the given number of failed attempts:
+// 2s, 4s, 8s, ... up to maxBackoff
+func backoff(attempts int) time.Duration {
+	d := 2 * time.Second
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// save drops finished jobs past the retention period and writes the
+// queue. It must be called with q.mu held.
+func (q *Queue) save() error {
+	cutoff := time.Now().Add(-q.Retention)
+	kept := q.order[:0]
+	for _, j := range q.order {
+		if j.done() && j.FinishedAt != nil && j.FinishedAt.Before(cutoff) {
+			delete(q.jobs, j.ID)
+			continue
+		}
+		kept = append(kept, j)
+	}
+	q.order = kept
+	return statefile.Save(q.Path, state{Jobs: q.order})
+}
+
+func (q *Queue) saveOrLog() {
+	if err := q.save(); err != nil {
+		log.Printf("jobs: %v", err)
+	}
+}
+
+func newID() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate job ID: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
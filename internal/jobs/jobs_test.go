@@ -0,0 +1,372 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/httperror"
+	"dns-proxy/internal/statefile"
+)
+
+// fakeService keeps TXT records in memory and fails calls with the
+// errors queued in fail
+type fakeService struct {
+	mu      sync.Mutex
+	records map[string]bool // "name.zone. value"
+	calls   []string
+	fail    []error
+	block   chan struct{} // if set, changes wait for it to be closed
+	running int
+	maxRun  int
+}
+
+func (s *fakeService) change(op, domain, key, value string) error {
+	s.mu.Lock()
+	zone, name := cpanel.RecordName(domain, key)
+	s.calls = append(s.calls, op+" "+name+"."+zone+" "+value)
+	s.running++
+	if s.running > s.maxRun {
+		s.maxRun = s.running
+	}
+	block := s.block
+	s.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+	if len(s.fail) > 0 {
+		err := s.fail[0]
+		s.fail = s.fail[1:]
+		return err
+	}
+	if s.records == nil {
+		s.records = make(map[string]bool)
+	}
+	k := name + "." + zone + ". " + value
+	switch op {
+	case "create":
+		s.records[k] = true
+	case "delete":
+		if !s.records[k] {
+			return cpanel.ErrRecordNotFound
+		}
+		delete(s.records, k)
+	}
+	return nil
+}
+
+func (s *fakeService) ListTxtRecords(ctx context.Context, domain, keyFilter string) ([]cpanel.TxtRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, "list "+domain)
+	var records []cpanel.TxtRecord
+	for k := range s.records {
+		name, value, _ := strings.Cut(k, " ")
+		records = append(records, cpanel.TxtRecord{Name: name, Value: value})
+	}
+	return records, nil
+}
+
+func (s *fakeService) CreateTxtRecord(ctx context.Context, domain, key, value string) error {
+	return s.change("create", domain, key, value)
+}
+
+func (s *fakeService) EditTxtRecord(ctx context.Context, domain, key, oldValue, newValue string) error {
+	return s.change("edit", domain, key, oldValue+"->"+newValue)
+}
+
+func (s *fakeService) DeleteTxtRecord(ctx context.Context, domain, key, value string) error {
+	return s.change("delete", domain, key, value)
+}
+
+func (s *fakeService) callLog() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+var errUnavailable = &cpanel.Error{Func: "add_zone_record", Kind: cpanel.KindUnavailable, Message: "connection refused"}
+
+// waitDone waits until the job has finished and returns it
+func waitDone(t *testing.T, q *Queue, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if j, _ := q.Get(id); j.done() {
+			return j
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return Job{}
+}
+
+func openQueue(t *testing.T, svc Service) *Queue {
+	t.Helper()
+	q, err := Open(filepath.Join(t.TempDir(), "jobs.json"), svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestBackoff(t *testing.T) {
+	want := []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 32 * time.Second, time.Minute, time.Minute}
+	for i, d := range want {
+		if got := backoff(i + 1); got != d {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, d)
+		}
+	}
+}
+
+func TestFinishAttempt(t *testing.T) {
+	q := &Queue{MaxAttempts: 3}
+	tests := []struct {
+		err      error
+		attempts int
+		status   string
+		code     string
+	}{
+		{nil, 1, StatusSucceeded, ""},
+		{errUnavailable, 1, StatusQueued, "cpanel_unavailable"},
+		{context.DeadlineExceeded, 2, StatusQueued, "cpanel_timeout"},
+		{&cpanel.Error{Kind: cpanel.KindInvalidResponse}, 1, StatusQueued, "cpanel_invalid_response"},
+		// Out of attempts
+		{errUnavailable, 3, StatusFailed, "cpanel_unavailable"},
+		// Errors a retry does not fix
+		{cpanel.ErrNotOwned, 1, StatusFailed, "not_owned"},
+		{cpanel.ErrRecordNotFound, 1, StatusFailed, "not_found"},
+		{&cpanel.Error{Kind: cpanel.KindAuth}, 1, StatusFailed, "cpanel_auth_failed"},
+		{&cpanel.Error{Kind: cpanel.KindAPI}, 1, StatusFailed, "cpanel_rejected"},
+	}
+	for _, tt := range tests {
+		j := &Job{ID: "x", Status: StatusRunning, Attempts: tt.attempts, RequestID: "req-1"}
+		before := time.Now()
+		q.finishAttempt(j, tt.err)
+		if j.Status != tt.status {
+			t.Errorf("%v after %d attempts: status %s, want %s", tt.err, tt.attempts, j.Status, tt.status)
+			continue
+		}
+		code := ""
+		if j.Error != nil {
+			code = j.Error.Code
+			if j.Error.RequestID != "req-1" {
+				t.Errorf("%v: error lacks the request ID", tt.err)
+			}
+		}
+		if code != tt.code {
+			t.Errorf("%v: code %q, want %q", tt.err, code, tt.code)
+		}
+		switch tt.status {
+		case StatusQueued:
+			if j.NextAttempt == nil || j.NextAttempt.Before(before.Add(backoff(tt.attempts))) || j.FinishedAt != nil {
+				t.Errorf("%v: next attempt %v, finished %v", tt.err, j.NextAttempt, j.FinishedAt)
+			}
+		default:
+			if j.NextAttempt != nil || j.FinishedAt == nil {
+				t.Errorf("%v: next attempt %v, finished %v", tt.err, j.NextAttempt, j.FinishedAt)
+			}
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	svc := &fakeService{fail: []error{errUnavailable}}
+	q := openQueue(t, svc)
+	q.Start()
+	defer q.Drain()
+
+	j, err := q.Submit(Job{Op: OpCreate, Zone: "example.com", Name: "_acme-challenge", NewValue: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first attempt fails and the second waits for the backoff
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, _ := q.Get(j.ID)
+		if got.Attempts == 1 && got.Status == StatusQueued {
+			if got.NextAttempt == nil || got.Error == nil || !got.Error.Retryable {
+				t.Fatalf("after the failed attempt: %+v", got)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %+v was not scheduled for a retry", got)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	got := waitDone(t, q, j.ID)
+	if got.Status != StatusSucceeded || got.Attempts != 2 || got.Error != nil {
+		t.Errorf("job %+v, want succeeded on the second attempt", got)
+	}
+	// The retry looked for the record before creating it again
+	want := "create _acme-challenge.example.com token,list example.com,create _acme-challenge.example.com token"
+	if calls := strings.Join(svc.callLog(), ","); calls != want {
+		t.Errorf("calls %s, want %s", calls, want)
+	}
+}
+
+func TestZoneSerialization(t *testing.T) {
+	svc := &fakeService{block: make(chan struct{})}
+	q := openQueue(t, svc)
+	q.Workers = 4
+	q.Start()
+	defer q.Drain()
+
+	// sub.example.com is part of the example.com cPanel zone
+	var ids []string
+	for _, zone := range []string{"example.com", "sub.example.com", "example.com"} {
+		j, err := q.Submit(Job{Op: OpCreate, Zone: zone, Name: "_acme-challenge", NewValue: "v" + string(rune('1'+len(ids)))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, j.ID)
+	}
+	other, _ := q.Submit(Job{Op: OpCreate, Zone: "example.org", Name: "_acme-challenge", NewValue: "o"})
+
+	// example.org runs next to the first example.com job
+	deadline := time.Now().Add(5 * time.Second)
+	for len(svc.callLog()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if calls := svc.callLog(); len(calls) != 2 {
+		t.Fatalf("%d changes started at once, want 2: %v", len(calls), calls)
+	}
+	close(svc.block)
+
+	for _, id := range append(ids, other.ID) {
+		waitDone(t, q, id)
+	}
+	var order []string
+	for _, c := range svc.callLog() {
+		if !strings.Contains(c, "example.org") {
+			order = append(order, c)
+		}
+	}
+	want := "create _acme-challenge.example.com v1,create _acme-challenge.sub.example.com v2,create _acme-challenge.example.com v3"
+	if got := strings.Join(order, ","); got != want {
+		t.Errorf("example.com changes %s, want them in submission order %s", got, want)
+	}
+}
+
+func TestNextKeepsZoneOrder(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Minute)
+	q := &Queue{busy: map[string]bool{"example.net": true}}
+	q.order = []*Job{
+		{ID: "running", Zone: "example.net", Status: StatusQueued},
+		{ID: "waiting", Zone: "sub.example.com", Status: StatusQueued, NextAttempt: &later},
+		{ID: "behind", Zone: "example.com", Status: StatusQueued},
+		{ID: "done", Zone: "example.org", Status: StatusSucceeded},
+	}
+	// Nothing may run: example.net is busy and example.com waits for a retry
+	if j, wait := q.next(now); j != nil || wait != time.Minute {
+		t.Fatalf("next = %v, %v, want nothing for a minute", j, wait)
+	}
+	if j, _ := q.next(later); j == nil || j.ID != "waiting" {
+		t.Errorf("next once the retry is due = %v, want the waiting job", j)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	svc := &fakeService{}
+	q, err := Open(path, svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Not started, so the jobs stay queued
+	var ids []string
+	for _, v := range []string{"a", "b"} {
+		j, err := q.Submit(Job{Op: OpCreate, Zone: "example.com", Name: "_acme-challenge", NewValue: v, TokenID: "certbot"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, j.ID)
+	}
+
+	reopened, err := Open(path, svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range ids {
+		j, ok := reopened.Get(id)
+		if !ok || j.Status != StatusQueued || j.TokenID != "certbot" || reopened.order[i].ID != id {
+			t.Errorf("reopened job %d: %+v, %v", i, j, ok)
+		}
+	}
+	reopened.Start()
+	defer reopened.Drain()
+	for _, id := range ids {
+		if j := waitDone(t, reopened, id); j.Status != StatusSucceeded {
+			t.Errorf("job %+v", j)
+		}
+	}
+}
+
+func TestRequeueInterrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	created := time.Now().UTC().Add(-time.Minute)
+	// Both attempts reached cPanel before the process stopped
+	st := state{Jobs: []*Job{
+		{ID: "create", Op: OpCreate, Zone: "sub.example.com", Name: "_acme-challenge", NewValue: "token", Status: StatusRunning, Attempts: 1, CreatedAt: created},
+		{ID: "delete", Op: OpDelete, Zone: "example.com", Name: "_acme-challenge", OldValue: "old", Status: StatusRunning, Attempts: 1, CreatedAt: created.Add(time.Second)},
+		{ID: "finished", Op: OpCreate, Zone: "example.com", Name: "x", NewValue: "y", Status: StatusSucceeded, Attempts: 1, CreatedAt: created},
+	}}
+	if err := statefile.Save(path, st); err != nil {
+		t.Fatal(err)
+	}
+	svc := &fakeService{records: map[string]bool{"_acme-challenge.sub.example.com. token": true}}
+
+	q, err := Open(path, svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"create", "delete"} {
+		if j, _ := q.Get(id); j.Status != StatusQueued {
+			t.Errorf("job %s reopened as %s, want queued", id, j.Status)
+		}
+	}
+	q.Start()
+	defer q.Drain()
+
+	for _, id := range []string{"create", "delete"} {
+		if j := waitDone(t, q, id); j.Status != StatusSucceeded || j.Attempts != 2 {
+			t.Errorf("job %+v, want succeeded on the second attempt", j)
+		}
+	}
+	for _, c := range svc.callLog() {
+		if strings.HasPrefix(c, "create") {
+			t.Errorf("the record was created a second time: %v", svc.callLog())
+		}
+	}
+	if len(svc.records) != 1 {
+		t.Errorf("zone holds %v, want the one record", svc.records)
+	}
+}
+
+func TestSubmitAfterDrain(t *testing.T) {
+	q := openQueue(t, &fakeService{})
+	q.Start()
+	q.Drain()
+	_, err := q.Submit(Job{Op: OpCreate, Zone: "example.com", Name: "x", NewValue: "y"})
+	var httpErr *httperror.Error
+	if !errors.As(err, &httpErr) || httpErr.Status != http.StatusServiceUnavailable || !httpErr.Retryable {
+		t.Errorf("Submit after Drain = %v, want a retryable 503", err)
+	}
+	if len(q.order) != 0 {
+		t.Errorf("queue holds %d jobs after a refused submit", len(q.order))
+	}
+}
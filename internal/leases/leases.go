@@ -0,0 +1,246 @@
+// Package leases lets TXT records created through the proxy expire. A
+// lease remembers the exact name and value of a record the proxy created
+// with an expiry; once it has expired, the janitor deletes that record and
+// nothing else, so records made by hand under the same name are kept.
+package leases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/statefile"
+)
+
+// DefaultInterval is how often the janitor looks for expired leases unless
+// configured otherwise
+const DefaultInterval = time.Minute
+
+// deleteTimeout bounds the deletion of a single expired record
+const deleteTimeout = 30 * time.Second
+
+// Lease is a TXT record created by the proxy that is deleted once it expires
+type Lease struct {
+	Zone      string    `json:"zone"` // cPanel zone
+	Name      string    `json:"name"` // fully qualified with trailing dot
+	Value     string    `json:"value"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Key returns the record name relative to Zone, as taken by the cPanel
+// client's record functions
+func (l *Lease) Key() string {
+	return strings.TrimSuffix(strings.TrimSuffix(l.Name, "."), "."+l.Zone)
+}
+
+func (l *Lease) matches(name, value string) bool {
+	return strings.EqualFold(l.Name, name) && l.Value == value
+}
+
+type ttlKey struct{}
+
+// WithTTL returns a context under which records created through a
+// cpanel.CPanelConfig that has the store as a recorder get a lease of ttl
+func WithTTL(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, ttlKey{}, ttl)
+}
+
+// TTLFromContext returns the TTL set by WithTTL
+func TTLFromContext(ctx context.Context) (time.Duration, bool) {
+	ttl, ok := ctx.Value(ttlKey{}).(time.Duration)
+	return ttl, ok && ttl > 0
+}
+
+// Store keeps the leases in a JSON file. The file may be shared by the API
+// and the CLI, every update holds a lock on a companion ".lock" file.
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Open returns the store at path, checking that it can be read
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+	if _, err := s.List(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// List returns all leases, soonest expiry first
+func (s *Store) List() ([]Lease, error) {
+	var leases []Lease
+	err := s.update(func(l []Lease) ([]Lease, bool) {
+		leases = l
+		return l, false
+	})
+	sort.SliceStable(leases, func(i, j int) bool { return leases[i].ExpiresAt.Before(leases[j].ExpiresAt) })
+	return leases, err
+}
+
+// Add stores l, replacing a lease on the same record
+func (s *Store) Add(l Lease) error {
+	return s.update(func(leases []Lease) ([]Lease, bool) {
+		for i := range leases {
+			if leases[i].matches(l.Name, l.Value) {
+				leases[i] = l
+				return leases, true
+			}
+		}
+		return append(leases, l), true
+	})
+}
+
+// Remove drops the lease on the record with the given name and value, if any
+func (s *Store) Remove(name, value string) error {
+	return s.update(func(leases []Lease) ([]Lease, bool) {
+		kept := leases[:0]
+		for _, l := range leases {
+			if !l.matches(name, value) {
+				kept = append(kept, l)
+			}
+		}
+		return kept, len(kept) != len(leases)
+	})
+}
+
+// RecordChange implements cpanel.ChangeRecorder to keep the leases in step
+// with the records: a create made under WithTTL adds a lease, an edit
+// moves the lease to the new value and a delete drops it. Failures are
+// logged, the change itself has already been made.
+func (s *Store) RecordChange(ctx context.Context, c cpanel.Change) {
+	if c.Type != "TXT" {
+		return
+	}
+	var err error
+	switch {
+	case c.Op == "create" && c.Err == nil:
+		ttl, ok := TTLFromContext(ctx)
+		if !ok {
+			return
+		}
+		now := time.Now().UTC()
+		err = s.Add(Lease{Zone: c.Zone, Name: c.Name, Value: c.NewValue, CreatedAt: now, ExpiresAt: now.Add(ttl)})
+	case c.Op == "edit" && c.Err == nil:
+		err = s.update(func(leases []Lease) ([]Lease, bool) {
+			changed := false
+			for i := range leases {
+				if leases[i].matches(c.Name, c.OldValue) {
+					leases[i].Value = c.NewValue
+					changed = true
+				}
+			}
+			return leases, changed
+		})
+	case c.Op == "delete" && (c.Err == nil || errors.Is(c.Err, cpanel.ErrRecordNotFound)):
+		err = s.Remove(c.Name, c.OldValue)
+	}
+	if err != nil {
+		log.Printf("leases: failed to record %s of %s: %v", c.Op, c.Name, err)
+	}
+}
+
+// update loads the leases under the lock and passes them to fn, saving
+// its result if fn reports a change
+func (s *Store) update(fn func([]Lease) ([]Lease, bool)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var leases []Lease
+	return statefile.Update(s.path, &leases, func() bool {
+		var changed bool
+		leases, changed = fn(leases)
+		if leases == nil {
+			leases = []Lease{}
+		}
+		return changed
+	})
+}
+
+// Deleter deletes TXT records, implemented by *cpanel.CPanelConfig
+type Deleter interface {
+	DeleteTxtRecord(ctx context.Context, domain, key, value string) error
+}
+
+// Janitor deletes the records of expired leases
+type Janitor struct {
+	Store    *Store
+	Service  Deleter
+	Interval time.Duration
+}
+
+// NewJanitor returns a janitor for the leases in store
+func NewJanitor(store *Store, svc Deleter, interval time.Duration) *Janitor {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Janitor{Store: store, Service: svc, Interval: interval}
+}
+
+// Run collects expired leases right away and then every Interval until ctx
+// is done
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := j.Collect(ctx, false); err != nil {
+			log.Printf("leases: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect deletes the record of every expired lease and drops the lease.
+// A record that is already gone, or was changed to another value, only
+// loses its lease. Leases whose deletion fails otherwise are kept for the
+// next run. With dryRun nothing is deleted. Collect returns the expired
+// leases it handled.
+func (j *Janitor) Collect(ctx context.Context, dryRun bool) ([]Lease, error) {
+	leases, err := j.Store.List()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var expired []Lease
+	var errs []error
+	for _, l := range leases {
+		if l.ExpiresAt.After(now) {
+			break
+		}
+		if dryRun {
+			expired = append(expired, l)
+			continue
+		}
+		if err := j.delete(ctx, l); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete expired record %s %q: %w", l.Name, l.Value, err))
+			continue
+		}
+		expired = append(expired, l)
+	}
+	return expired, errors.Join(errs...)
+}
+
+func (j *Janitor) delete(ctx context.Context, l Lease) error {
+	ctx, cancel := context.WithTimeout(ctx, deleteTimeout)
+	defer cancel()
+
+	err := j.Service.DeleteTxtRecord(ctx, l.Zone, l.Key(), l.Value)
+	if err != nil && !errors.Is(err, cpanel.ErrRecordNotFound) {
+		return err
+	}
+	if err == nil {
+		log.Printf("Deleted expired TXT record %s %q", l.Name, l.Value)
+	}
+	return j.Store.Remove(l.Name, l.Value)
+}
@@ -0,0 +1,178 @@
+package leases
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"dns-proxy/internal/cpanel"
+)
+
+// fakeDeleter records deletions and answers with the error set for a value
+type fakeDeleter struct {
+	mu      sync.Mutex
+	deleted []string
+	errs    map[string]error // by value
+}
+
+func (d *fakeDeleter) DeleteTxtRecord(ctx context.Context, domain, key, value string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.errs[value]; err != nil {
+		return err
+	}
+	d.deleted = append(d.deleted, key+"."+domain+" "+value)
+	return nil
+}
+
+func openStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "leases.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// values returns the values of the leases in s
+func values(t *testing.T, s *Store) string {
+	t.Helper()
+	leases, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v []string
+	for _, l := range leases {
+		v = append(v, l.Value)
+	}
+	return strings.Join(v, ",")
+}
+
+func lease(value string, expiresAt time.Time) Lease {
+	return Lease{Zone: "example.com", Name: "_acme-challenge.sub.example.com.", Value: value, ExpiresAt: expiresAt}
+}
+
+func TestCollect(t *testing.T) {
+	now := time.Now()
+	s := openStore(t)
+	for _, l := range []Lease{
+		lease("later", now.Add(time.Hour)),
+		lease("expired", now.Add(-time.Minute)),
+		lease("gone", now.Add(-2*time.Minute)),
+		lease("failing", now.Add(-3*time.Minute)),
+		lease("foreign", now.Add(-4*time.Minute)),
+	} {
+		if err := s.Add(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := &fakeDeleter{errs: map[string]error{
+		"gone":    cpanel.ErrRecordNotFound,
+		"failing": &cpanel.Error{Func: "remove_zone_record", Kind: cpanel.KindUnavailable, Message: "down"},
+		"foreign": cpanel.ErrNotOwned,
+	}}
+	j := NewJanitor(s, d, 0)
+	if j.Interval != DefaultInterval {
+		t.Errorf("Interval = %v, want the default", j.Interval)
+	}
+
+	// A dry run reports the expired leases, soonest first, and changes nothing
+	expired, err := j.Collect(context.Background(), true)
+	if err != nil || len(expired) != 4 || expired[0].Value != "foreign" {
+		t.Fatalf("dry run = %v, %v", expired, err)
+	}
+	if len(d.deleted) != 0 || values(t, s) != "foreign,failing,gone,expired,later" {
+		t.Fatalf("dry run deleted %v, leases %s", d.deleted, values(t, s))
+	}
+
+	expired, err = j.Collect(context.Background(), false)
+	var handled []string
+	for _, l := range expired {
+		handled = append(handled, l.Value)
+	}
+	if strings.Join(handled, ",") != "gone,expired" {
+		t.Errorf("collected %v, want gone and expired", handled)
+	}
+	// Only the record that was there is deleted, by its key in the zone
+	if strings.Join(d.deleted, ",") != "_acme-challenge.sub.example.com expired" {
+		t.Errorf("deleted %v", d.deleted)
+	}
+	if err == nil || !strings.Contains(err.Error(), `"failing"`) || !errors.Is(err, cpanel.ErrNotOwned) {
+		t.Errorf("err = %v, want the failing and foreign deletions", err)
+	}
+	// Failed deletions keep their lease for the next run
+	if got := values(t, s); got != "foreign,failing,later" {
+		t.Errorf("leases left: %s, want foreign, failing and later", got)
+	}
+
+	delete(d.errs, "failing")
+	if _, err := j.Collect(context.Background(), false); !errors.Is(err, cpanel.ErrNotOwned) {
+		t.Errorf("second run: %v", err)
+	}
+	if got := values(t, s); got != "foreign,later" {
+		t.Errorf("leases left after the second run: %s", got)
+	}
+}
+
+func TestRecordChange(t *testing.T) {
+	s := openStore(t)
+	name := "_acme-challenge.example.com."
+	ctx := WithTTL(context.Background(), time.Hour)
+
+	// Creates only get a lease under WithTTL
+	s.RecordChange(context.Background(), cpanel.Change{Op: "create", Zone: "example.com", Name: name, Type: "TXT", NewValue: "manual"})
+	s.RecordChange(ctx, cpanel.Change{Op: "create", Zone: "example.com", Name: name, Type: "TXT", NewValue: "a"})
+	s.RecordChange(ctx, cpanel.Change{Op: "create", Zone: "example.com", Name: name, Type: "TXT", NewValue: "failed", Err: errors.New("cPanel is down")})
+	leases, _ := s.List()
+	if len(leases) != 1 || leases[0].Value != "a" || leases[0].Key() != "_acme-challenge" {
+		t.Fatalf("leases after the creates: %+v", leases)
+	}
+	if d := leases[0].ExpiresAt.Sub(leases[0].CreatedAt); d != time.Hour {
+		t.Errorf("lease of %v, want an hour", d)
+	}
+
+	// An edit moves the lease to the new value, names match in any case
+	s.RecordChange(context.Background(), cpanel.Change{Op: "edit", Zone: "example.com", Name: strings.ToUpper(name), Type: "TXT", OldValue: "a", NewValue: "b"})
+	if got := values(t, s); got != "b" {
+		t.Errorf("leases after the edit: %s, want b", got)
+	}
+	// A failed edit does not
+	s.RecordChange(context.Background(), cpanel.Change{Op: "edit", Zone: "example.com", Name: name, Type: "TXT", OldValue: "b", NewValue: "c", Err: cpanel.ErrNotOwned})
+	if got := values(t, s); got != "b" {
+		t.Errorf("leases after a failed edit: %s, want b", got)
+	}
+
+	// A failed delete keeps the lease, one of a missing record drops it
+	s.RecordChange(context.Background(), cpanel.Change{Op: "delete", Zone: "example.com", Name: name, Type: "TXT", OldValue: "b", Err: errors.New("timeout")})
+	if got := values(t, s); got != "b" {
+		t.Errorf("leases after a failed delete: %s, want b", got)
+	}
+	s.RecordChange(context.Background(), cpanel.Change{Op: "delete", Zone: "example.com", Name: name, Type: "TXT", OldValue: "b", Err: cpanel.ErrRecordNotFound})
+	if got := values(t, s); got != "" {
+		t.Errorf("leases after the delete: %s, want none", got)
+	}
+}
+
+func TestStoreShared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases.json")
+	a, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	a.Add(lease("from-a", now))
+	b.Add(lease("from-b", now.Add(-time.Second)))
+	// Adding the same record again replaces its lease
+	a.Add(lease("from-a", now.Add(time.Hour)))
+	if got := values(t, b); got != "from-b,from-a" {
+		t.Errorf("leases seen by b: %s", got)
+	}
+}
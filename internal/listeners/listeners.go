@@ -0,0 +1,233 @@
+// Package listeners opens the sockets dns-proxy-api serves on: TCP
+// addresses, Unix domain sockets and sockets passed in by systemd socket
+// activation.
+package listeners
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Spec describes one listener. The textual forms are
+//
+//	[tls://]host:port       TCP, TLS with the tls:// prefix
+//	unix:/path/to/socket    Unix domain socket, always plain HTTP
+//	[tls+]systemd[:name]    sockets passed by systemd, all or those named name
+type Spec struct {
+	Network string // "tcp", "unix" or "systemd"
+	Address string // host:port, socket path or LISTEN_FDNAMES name
+	TLS     bool
+}
+
+func (s Spec) String() string {
+	var str string
+	switch s.Network {
+	case "unix":
+		str = "unix:" + s.Address
+	case "systemd":
+		str = "systemd"
+		if s.Address != "" {
+			str += ":" + s.Address
+		}
+		if s.TLS {
+			str = "tls+" + str
+		}
+		return str
+	default:
+		str = s.Address
+	}
+	if s.TLS {
+		str = "tls://" + str
+	}
+	return str
+}
+
+// ParseSpec parses a single listener spec
+func ParseSpec(s string) (Spec, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "unix:"):
+		path := strings.TrimPrefix(s, "unix:")
+		if path == "" {
+			return Spec{}, fmt.Errorf("listener %q: missing socket path", s)
+		}
+		return Spec{Network: "unix", Address: path}, nil
+	case s == "systemd" || s == "tls+systemd" || strings.HasPrefix(s, "systemd:") || strings.HasPrefix(s, "tls+systemd:"):
+		rest, tls := strings.CutPrefix(s, "tls+")
+		_, name, _ := strings.Cut(rest, ":")
+		return Spec{Network: "systemd", Address: name, TLS: tls}, nil
+	}
+
+	addr, tls := strings.CutPrefix(s, "tls://")
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return Spec{}, fmt.Errorf("listener %q: %w", s, err)
+	}
+	return Spec{Network: "tcp", Address: addr, TLS: tls}, nil
+}
+
+// ParseSpecs parses a comma separated list of listener specs
+func ParseSpecs(s string) ([]Spec, error) {
+	var specs []Spec
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		spec, err := ParseSpec(part)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+	if len(specs) == 0 {
+		return nil, errors.New("no listeners configured")
+	}
+	return specs, nil
+}
+
+// UnixOptions sets the permissions of Unix domain sockets
+type UnixOptions struct {
+	Mode  os.FileMode // 0 keeps the umask default
+	Owner string      // user name or ID, empty to keep
+	Group string      // group name or ID, empty to keep
+}
+
+// Open opens the listeners for spec. A systemd spec can yield several.
+func Open(spec Spec, unixOpts UnixOptions) ([]net.Listener, error) {
+	switch spec.Network {
+	case "tcp":
+		l, err := net.Listen("tcp", spec.Address)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	case "unix":
+		l, err := listenUnix(spec.Address, unixOpts)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	case "systemd":
+		return systemdListeners(spec.Address)
+	}
+	return nil, fmt.Errorf("unknown listener network %q", spec.Network)
+}
+
+// listenUnix listens on path, replacing a stale socket left by a previous
+// run, and applies the configured mode and ownership
+func listenUnix(path string, opts UnixOptions) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := setSocketPermissions(path, opts); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func setSocketPermissions(path string, opts UnixOptions) error {
+	if opts.Mode != 0 {
+		if err := os.Chmod(path, opts.Mode); err != nil {
+			return err
+		}
+	}
+	if opts.Owner == "" && opts.Group == "" {
+		return nil
+	}
+	uid, gid := -1, -1
+	if opts.Owner != "" {
+		id, err := lookupID(opts.Owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("socket owner: %w", err)
+		}
+		uid = id
+	}
+	if opts.Group != "" {
+		id, err := lookupID(opts.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("socket group: %w", err)
+		}
+		gid = id
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// lookupID accepts a numeric ID or resolves a name with lookup
+func lookupID(nameOrID string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(nameOrID); err == nil {
+		return id, nil
+	}
+	s, err := lookup(nameOrID)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(s)
+}
+
+// systemdFirstFD is the first file descriptor passed by systemd (SD_LISTEN_FDS_START)
+const systemdFirstFD = 3
+
+// systemdListeners returns the sockets passed with LISTEN_FDS, all of them
+// or only those named name in LISTEN_FDNAMES
+func systemdListeners(name string) ([]net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, errors.New("systemd listener requested but no sockets were passed (LISTEN_PID not set for this process)")
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, errors.New("systemd listener requested but LISTEN_FDS is empty")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	var ls []net.Listener
+	for i := 0; i < count; i++ {
+		fdName := ""
+		if i < len(names) {
+			fdName = names[i]
+		}
+		if name != "" && fdName != name {
+			continue
+		}
+		fd := systemdFirstFD + i
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), "systemd:"+fdName)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %d: %w", fd, err)
+		}
+		ls = append(ls, l)
+	}
+	if len(ls) == 0 {
+		return nil, fmt.Errorf("no systemd socket named %q in LISTEN_FDNAMES", name)
+	}
+	return ls, nil
+}
@@ -0,0 +1,185 @@
+package listeners
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseSpec(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Spec
+	}{
+		{"127.0.0.1:5000", Spec{Network: "tcp", Address: "127.0.0.1:5000"}},
+		{" :5000 ", Spec{Network: "tcp", Address: ":5000"}},
+		{"tls://[::1]:8443", Spec{Network: "tcp", Address: "[::1]:8443", TLS: true}},
+		{"unix:/run/dns-proxy/api.sock", Spec{Network: "unix", Address: "/run/dns-proxy/api.sock"}},
+		{"systemd", Spec{Network: "systemd"}},
+		{"systemd:api", Spec{Network: "systemd", Address: "api"}},
+		{"tls+systemd:api-tls", Spec{Network: "systemd", Address: "api-tls", TLS: true}},
+	}
+	for _, tt := range tests {
+		got, err := ParseSpec(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSpec(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+			continue
+		}
+		// String gives back a spec that parses to the same thing
+		if again, err := ParseSpec(got.String()); err != nil || again != got {
+			t.Errorf("ParseSpec(%q) = %+v, %v, want %+v", got.String(), again, err, got)
+		}
+	}
+
+	for _, in := range []string{"unix:", "5000", "tls://localhost", "http://localhost:5000"} {
+		if spec, err := ParseSpec(in); err == nil {
+			t.Errorf("ParseSpec(%q) = %+v, want an error", in, spec)
+		}
+	}
+}
+
+func TestParseSpecs(t *testing.T) {
+	specs, err := ParseSpecs("127.0.0.1:5000, unix:/run/api.sock,,tls+systemd")
+	if err != nil || len(specs) != 3 || specs[1].Network != "unix" || !specs[2].TLS {
+		t.Errorf("ParseSpecs = %+v, %v", specs, err)
+	}
+	if _, err := ParseSpecs(" , "); err == nil {
+		t.Error("ParseSpecs accepted an empty list")
+	}
+	if _, err := ParseSpecs("127.0.0.1:5000,bad"); err == nil {
+		t.Error("ParseSpecs accepted an invalid entry")
+	}
+}
+
+func TestOpenUnix(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api.sock")
+	spec := Spec{Network: "unix", Address: path}
+	opts := UnixOptions{Mode: 0o660, Owner: strconv.Itoa(os.Getuid()), Group: strconv.Itoa(os.Getgid())}
+
+	ls, err := Open(spec, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o660 {
+		t.Errorf("socket mode %v, want 0660", fi.Mode().Perm())
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	conn.Close()
+
+	// A socket left behind by a crashed run is replaced. Closing a
+	// listener removes its socket, so make it forget to.
+	ls[0].(*net.UnixListener).SetUnlinkOnClose(false)
+	ls[0].Close()
+	if ls, err = Open(spec, UnixOptions{}); err != nil {
+		t.Fatalf("open over a stale socket: %v", err)
+	}
+	ls[0].Close()
+
+	// Other files are never removed
+	file := filepath.Join(dir, "not-a-socket")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(Spec{Network: "unix", Address: file}, UnixOptions{}); err == nil || !strings.Contains(err.Error(), "is not a socket") {
+		t.Errorf("open over a regular file: %v", err)
+	}
+
+	if _, err := Open(Spec{Network: "unix", Address: filepath.Join(dir, "other.sock")}, UnixOptions{Owner: "no-such-user-dns-proxy"}); err == nil || !strings.Contains(err.Error(), "socket owner") {
+		t.Errorf("unknown owner: %v", err)
+	}
+}
+
+func TestOpenTCP(t *testing.T) {
+	ls, err := Open(Spec{Network: "tcp", Address: "127.0.0.1:0"}, UnixOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls[0].Close()
+	if _, err := Open(Spec{Network: "tcp", Address: ls[0].Addr().String()}, UnixOptions{}); err == nil {
+		t.Error("opened a TCP port twice")
+	}
+	if _, err := Open(Spec{Network: "sctp"}, UnixOptions{}); err == nil {
+		t.Error("opened an unknown network")
+	}
+}
+
+func TestSystemdWithoutSockets(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	if _, err := Open(Spec{Network: "systemd"}, UnixOptions{}); err == nil || !strings.Contains(err.Error(), "LISTEN_PID") {
+		t.Errorf("sockets meant for another process: %v", err)
+	}
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "0")
+	if _, err := Open(Spec{Network: "systemd"}, UnixOptions{}); err == nil || !strings.Contains(err.Error(), "LISTEN_FDS") {
+		t.Errorf("no sockets: %v", err)
+	}
+}
+
+// TestSystemdSockets runs the test binary again with two sockets passed the
+// way systemd passes them, as fds 3 and 4 named in LISTEN_FDNAMES
+func TestSystemdSockets(t *testing.T) {
+	var files []*os.File
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		f, err := l.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		files = append(files, f)
+		addrs = append(addrs, l.Addr().String())
+	}
+
+	for name, want := range map[string]string{
+		"":        addrs[0] + "," + addrs[1],
+		"metrics": addrs[1],
+		"other":   `no systemd socket named "other" in LISTEN_FDNAMES`,
+	} {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestSystemdChild$")
+		cmd.ExtraFiles = files
+		cmd.Env = append(os.Environ(), "LISTENERS_TEST_CHILD="+name, "LISTEN_FDS=2", "LISTEN_FDNAMES=api:metrics")
+		out, _ := cmd.CombinedOutput()
+		if !strings.Contains(string(out), "opened: "+want+"\n") {
+			t.Errorf("systemd:%s: child printed %s, want %s", name, out, want)
+		}
+	}
+}
+
+// TestSystemdChild opens the sockets passed by TestSystemdSockets and
+// prints their addresses
+func TestSystemdChild(t *testing.T) {
+	name, ok := os.LookupEnv("LISTENERS_TEST_CHILD")
+	if !ok {
+		t.Skip("only run by TestSystemdSockets")
+	}
+	// LISTEN_PID can only be known once the process runs
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	ls, err := Open(Spec{Network: "systemd", Address: name}, UnixOptions{})
+	if err != nil {
+		os.Stdout.WriteString("opened: " + err.Error() + "\n")
+		return
+	}
+	var addrs []string
+	for _, l := range ls {
+		addrs = append(addrs, l.Addr().String())
+	}
+	os.Stdout.WriteString("opened: " + strings.Join(addrs, ",") + "\n")
+}
@@ -0,0 +1,142 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Webhook headers
+const (
+	SignatureHeader = "X-DNS-Proxy-Signature" // t=<unix time>,v1=<hex HMAC-SHA256>
+	EventHeader     = "X-DNS-Proxy-Event"
+	DeliveryHeader  = "X-DNS-Proxy-Delivery" // the event ID, the same on every retry
+)
+
+var httpClient = &http.Client{}
+
+// WebhookSink POSTs each event as JSON. With a secret, the body is signed
+// with HMAC-SHA256 over "<timestamp>.<body>", so the receiver can check
+// both the origin and the age of a delivery.
+type WebhookSink struct {
+	URL    string
+	Secret []byte
+}
+
+func newWebhookSink(f map[string]string) (*WebhookSink, error) {
+	if err := checkURL(f["url"]); err != nil {
+		return nil, err
+	}
+	return &WebhookSink{URL: f["url"], Secret: []byte(f["secret"])}, nil
+}
+
+func (s *WebhookSink) Send(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return &permanentError{err}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dns-proxy")
+	req.Header.Set(EventHeader, e.Type)
+	req.Header.Set(DeliveryHeader, e.ID)
+	if len(s.Secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(s.Secret, time.Now(), body))
+	}
+	return post(req)
+}
+
+// Sign returns the SignatureHeader value for body sent at t
+func Sign(secret []byte, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NtfySink pushes each event as a plain text message, the way ntfy and
+// similar services take them: the summary as the body, the event type as
+// the title and, for failures, a high priority
+type NtfySink struct {
+	URL      string // including the topic, e.g. https://ntfy.sh/dns-proxy
+	Token    string // sent as a bearer token if set
+	Priority string // overrides the default priority, 1-5 or min, low, default, high, urgent
+}
+
+func newNtfySink(f map[string]string) (*NtfySink, error) {
+	if err := checkURL(f["url"]); err != nil {
+		return nil, err
+	}
+	return &NtfySink{URL: f["url"], Token: f["token"], Priority: f["priority"]}, nil
+}
+
+func (s *NtfySink) Send(ctx context.Context, e Event) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewBufferString(e.Summary()))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("User-Agent", "dns-proxy")
+	req.Header.Set("Title", "dns-proxy: "+e.Type)
+	tags := "dns"
+	priority := s.Priority
+	if e.Failure() {
+		tags = "warning,dns"
+		if priority == "" {
+			priority = "high"
+		}
+	}
+	req.Header.Set("Tags", tags)
+	if priority != "" {
+		req.Header.Set("Priority", priority)
+	}
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	return post(req)
+}
+
+// post sends req and checks for a 2xx answer. Client errors other than
+// timeouts and rate limits are permanent.
+func post(req *http.Request) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("unexpected HTTP status %d", resp.StatusCode)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return &permanentError{err}
+	}
+	return err
+}
+
+func checkURL(v string) error {
+	if v == "" {
+		return errors.New("url is required")
+	}
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http or https URL, got %q", v)
+	}
+	return nil
+}
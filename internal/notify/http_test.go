@@ -0,0 +1,187 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// received is a request seen by a test server
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// recordingServer answers every request with status and hands it to the
+// returned channel
+func recordingServer(t *testing.T, status int) (*httptest.Server, <-chan received) {
+	t.Helper()
+	ch := make(chan received, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		ch <- received{header: r.Header.Clone(), body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, ch
+}
+
+// verify checks header against body the way a webhook receiver should
+func verify(secret []byte, header string, body []byte, maxAge time.Duration) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("missing timestamp")
+	}
+	if age := time.Since(time.Unix(sec, 0)); age > maxAge || age < -maxAge {
+		return errors.New("timestamp out of range")
+	}
+	want := Sign(secret, time.Unix(sec, 0), body)
+	if !hmac.Equal([]byte(want), []byte("t="+ts+",v1="+sig)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+func TestWebhookSigned(t *testing.T) {
+	srv, ch := recordingServer(t, http.StatusNoContent)
+	sink, err := newWebhookSink(map[string]string{"url": srv.URL, "secret": "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := Event{ID: "abc123", Type: EventCreated, Time: time.Now().UTC(), Zone: "example.com", Name: "_acme-challenge.example.com.", RecordType: "TXT", NewValue: "token"}
+	if err := sink.Send(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	got := <-ch
+
+	if err := verify([]byte("s3cret"), got.header.Get(SignatureHeader), got.body, time.Minute); err != nil {
+		t.Errorf("signature %q: %v", got.header.Get(SignatureHeader), err)
+	}
+	if err := verify([]byte("wrong"), got.header.Get(SignatureHeader), got.body, time.Minute); err == nil {
+		t.Error("signature verified with the wrong secret")
+	}
+	if err := verify([]byte("s3cret"), got.header.Get(SignatureHeader), append(got.body, ' '), time.Minute); err == nil {
+		t.Error("signature verified for a modified body")
+	}
+	if h := got.header.Get(EventHeader); h != EventCreated {
+		t.Errorf("%s = %q, want %q", EventHeader, h, EventCreated)
+	}
+	if h := got.header.Get(DeliveryHeader); h != "abc123" {
+		t.Errorf("%s = %q, want the event ID", DeliveryHeader, h)
+	}
+
+	var sent Event
+	if err := json.Unmarshal(got.body, &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent.Name != e.Name || sent.NewValue != e.NewValue || sent.Type != e.Type {
+		t.Errorf("body %s does not match the event", got.body)
+	}
+}
+
+func TestWebhookUnsigned(t *testing.T) {
+	srv, ch := recordingServer(t, http.StatusOK)
+	sink, err := newWebhookSink(map[string]string{"url": srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), Event{ID: "x", Type: EventTest}); err != nil {
+		t.Fatal(err)
+	}
+	if h := (<-ch).header.Get(SignatureHeader); h != "" {
+		t.Errorf("unsigned webhook sent %s: %q", SignatureHeader, h)
+	}
+}
+
+func TestPostStatus(t *testing.T) {
+	tests := []struct {
+		status    int
+		ok        bool
+		permanent bool
+	}{
+		{http.StatusOK, true, false},
+		{http.StatusAccepted, true, false},
+		{http.StatusBadRequest, false, true},
+		{http.StatusUnauthorized, false, true},
+		{http.StatusRequestTimeout, false, false},
+		{http.StatusTooManyRequests, false, false},
+		{http.StatusBadGateway, false, false},
+	}
+	for _, tt := range tests {
+		srv, _ := recordingServer(t, tt.status)
+		sink := &WebhookSink{URL: srv.URL}
+		err := sink.Send(context.Background(), Event{ID: "x", Type: EventTest})
+		var permanent *permanentError
+		if (err == nil) != tt.ok || errors.As(err, &permanent) != tt.permanent {
+			t.Errorf("status %d: err = %v, want ok=%v permanent=%v", tt.status, err, tt.ok, tt.permanent)
+		}
+	}
+}
+
+func TestNtfy(t *testing.T) {
+	srv, ch := recordingServer(t, http.StatusOK)
+	sink, err := newNtfySink(map[string]string{"url": srv.URL + "/dns-proxy", "token": "tk_abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := Event{ID: "1", Type: EventCreated, Name: "_acme-challenge.example.com.", RecordType: "TXT", NewValue: "token"}
+	if err := sink.Send(context.Background(), created); err != nil {
+		t.Fatal(err)
+	}
+	got := <-ch
+	if string(got.body) != created.Summary() {
+		t.Errorf("body %q, want %q", got.body, created.Summary())
+	}
+	if h := got.header.Get("Title"); h != "dns-proxy: record.created" {
+		t.Errorf("Title = %q", h)
+	}
+	if h := got.header.Get("Tags"); h != "dns" {
+		t.Errorf("Tags = %q, want dns", h)
+	}
+	if h := got.header.Get("Priority"); h != "" {
+		t.Errorf("Priority = %q for a change, want none", h)
+	}
+	if h := got.header.Get("Authorization"); h != "Bearer tk_abc" {
+		t.Errorf("Authorization = %q", h)
+	}
+
+	failed := Event{ID: "2", Type: EventFailed, Name: "_acme-challenge.example.com.", RecordType: "TXT", Error: "delete: not owned"}
+	if err := sink.Send(context.Background(), failed); err != nil {
+		t.Fatal(err)
+	}
+	got = <-ch
+	if h := got.header.Get("Priority"); h != "high" {
+		t.Errorf("Priority = %q for a failure, want high", h)
+	}
+	if h := got.header.Get("Tags"); h != "warning,dns" {
+		t.Errorf("Tags = %q for a failure, want warning,dns", h)
+	}
+
+	sink.Priority = "low"
+	if err := sink.Send(context.Background(), failed); err != nil {
+		t.Fatal(err)
+	}
+	if h := (<-ch).header.Get("Priority"); h != "low" {
+		t.Errorf("Priority = %q with priority=low, want low", h)
+	}
+}
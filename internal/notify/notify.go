@@ -0,0 +1,388 @@
+// Package notify tells operators about record changes and cPanel failures
+// through configurable sinks: a signed JSON webhook, SMTP email and
+// ntfy-style HTTP push. Events are queued per sink and delivered in the
+// background with retries, so a slow or unreachable sink never holds up
+// the DNS operation that caused the event.
+package notify
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dns-proxy/internal/audit"
+	"dns-proxy/internal/cpanel"
+)
+
+// Event types
+const (
+	EventCreated         = "record.created"
+	EventEdited          = "record.edited"
+	EventDeleted         = "record.deleted"
+	EventFailed          = "record.failed"    // a create, edit or delete that did not succeed
+	EventCPanelError     = "cpanel.error"     // a cPanel account started failing the readiness check
+	EventCPanelRecovered = "cpanel.recovered" // a cPanel account passes the readiness check again
+	EventTest            = "test"             // sent by `dns-proxy-api notify test`
+)
+
+const (
+	queueSize      = 100              // events waiting per sink before new ones are dropped
+	maxAttempts    = 5                // deliveries per event and sink
+	attemptTimeout = 10 * time.Second // bounds a single delivery
+	drainTimeout   = 10 * time.Second // how long Drain waits for queued events
+)
+
+// Event is a notification, sent as JSON by the webhook sink
+type Event struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Zone       string    `json:"zone,omitempty"`
+	Name       string    `json:"name,omitempty"` // fully qualified with trailing dot
+	RecordType string    `json:"record_type,omitempty"`
+	OldValue   string    `json:"old_value,omitempty"`
+	NewValue   string    `json:"new_value,omitempty"`
+	Serial     string    `json:"serial,omitempty"`
+	Forced     bool      `json:"forced,omitempty"`  // the ownership check was skipped
+	Account    string    `json:"account,omitempty"` // cPanel account of a cpanel.* event
+	Error      string    `json:"error,omitempty"`
+	Identity   string    `json:"identity,omitempty"`
+	SourceIP   string    `json:"source_ip,omitempty"`
+}
+
+// Failure reports whether the event is about something that went wrong
+func (e *Event) Failure() bool {
+	return e.Type == EventFailed || e.Type == EventCPanelError
+}
+
+// Summary describes the event in one line, for email subjects and pushes
+func (e *Event) Summary() string {
+	switch e.Type {
+	case EventCreated:
+		return fmt.Sprintf("Created %s %s %q", e.RecordType, e.Name, e.NewValue)
+	case EventEdited:
+		return fmt.Sprintf("Changed %s %s from %q to %q", e.RecordType, e.Name, e.OldValue, e.NewValue)
+	case EventDeleted:
+		return fmt.Sprintf("Deleted %s %s %q", e.RecordType, e.Name, e.OldValue)
+	case EventFailed:
+		return fmt.Sprintf("Failed to change %s %s: %s", e.RecordType, e.Name, e.Error)
+	case EventCPanelError:
+		return fmt.Sprintf("cPanel account %s is not ready: %s", e.Account, e.Error)
+	case EventCPanelRecovered:
+		return fmt.Sprintf("cPanel account %s is ready again", e.Account)
+	case EventTest:
+		return "Test notification from dns-proxy"
+	}
+	return e.Type
+}
+
+// Details lists the event's fields as "key: value" lines
+func (e *Event) Details() string {
+	var b strings.Builder
+	add := func(k, v string) {
+		if v != "" {
+			fmt.Fprintf(&b, "%s: %s\n", k, v)
+		}
+	}
+	add("Event", e.Type)
+	add("Time", e.Time.Format(time.RFC3339))
+	add("Zone", e.Zone)
+	add("Name", e.Name)
+	add("Type", e.RecordType)
+	add("Old value", e.OldValue)
+	add("New value", e.NewValue)
+	add("Serial", e.Serial)
+	if e.Forced {
+		add("Forced", "yes, the ownership check was skipped")
+	}
+	add("cPanel account", e.Account)
+	add("Error", e.Error)
+	add("By", e.Identity)
+	add("Source IP", e.SourceIP)
+	add("ID", e.ID)
+	return b.String()
+}
+
+// Sink delivers events to one destination
+type Sink interface {
+	Send(ctx context.Context, e Event) error
+}
+
+// permanentError marks a delivery failure that retrying won't fix, such as
+// a 4xx response
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// sinkQueue holds the events waiting for one sink
+type sinkQueue struct {
+	name   string
+	sink   Sink
+	events []string // path.Match patterns of the event types to send
+	queue  chan Event
+}
+
+func (q *sinkQueue) wants(eventType string) bool {
+	for _, pattern := range q.events {
+		if ok, _ := path.Match(pattern, eventType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Notifier turns record changes and cPanel failures into events and hands
+// them to the sinks. It implements cpanel.ChangeRecorder; HealthChanged
+// takes the readiness transitions of the health checker.
+type Notifier struct {
+	// DefaultActor is reported for changes made outside a request
+	DefaultActor audit.Actor
+
+	sinks   []*sinkQueue
+	mu      sync.RWMutex
+	stopped bool
+	stop    chan struct{} // closed when Drain gives up, to cut retries short
+	wg      sync.WaitGroup
+}
+
+// Load returns a notifier for the notify.<name>.* sinks in cfg, or nil if
+// there are none
+func Load(cfg map[string]string) (*Notifier, error) {
+	fields := make(map[string]map[string]string)
+	for k, v := range cfg {
+		rest, ok := strings.CutPrefix(k, "notify.")
+		if !ok {
+			continue
+		}
+		name, field, ok := strings.Cut(rest, ".")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s: expected notify.<name>.<setting>", k)
+		}
+		if fields[name] == nil {
+			fields[name] = make(map[string]string)
+		}
+		fields[name][field] = v
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	n := &Notifier{stop: make(chan struct{})}
+	for _, name := range names {
+		q, err := newSinkQueue(name, fields[name])
+		if err != nil {
+			return nil, err
+		}
+		n.sinks = append(n.sinks, q)
+	}
+	return n, nil
+}
+
+func newSinkQueue(name string, f map[string]string) (*sinkQueue, error) {
+	var sink Sink
+	var err error
+	switch f["type"] {
+	case "webhook":
+		sink, err = newWebhookSink(f)
+	case "smtp":
+		sink, err = newSMTPSink(f)
+	case "ntfy":
+		sink, err = newNtfySink(f)
+	case "":
+		err = errors.New("type is required")
+	default:
+		err = fmt.Errorf("unknown type %q, expected webhook, smtp or ntfy", f["type"])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("notify.%s: %w", name, err)
+	}
+
+	q := &sinkQueue{name: name, sink: sink, events: []string{"*"}, queue: make(chan Event, queueSize)}
+	if v := f["events"]; v != "" {
+		q.events = nil
+		for _, pattern := range strings.Split(v, ",") {
+			pattern = strings.TrimSpace(pattern)
+			if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+				return nil, fmt.Errorf("notify.%s.events: invalid pattern %q", name, pattern)
+			}
+			q.events = append(q.events, pattern)
+		}
+	}
+	return q, nil
+}
+
+// Start starts a delivery worker per sink
+func (n *Notifier) Start() {
+	for _, q := range n.sinks {
+		n.wg.Add(1)
+		go n.deliver(q)
+	}
+}
+
+// Drain stops accepting events and waits a bounded time for the queued
+// ones to be delivered, retries included. Whatever is left then is dropped.
+func (n *Notifier) Drain() {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	n.stopped = true
+	for _, q := range n.sinks {
+		close(q.queue)
+	}
+	n.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(drainTimeout):
+		close(n.stop)
+		log.Printf("notify: gave up on undelivered notifications after %s", drainTimeout)
+	}
+}
+
+// Publish queues e for every sink whose filter matches it. It never
+// blocks: when a sink's queue is full the event is dropped for that sink.
+func (n *Notifier) Publish(e Event) {
+	if e.ID == "" {
+		e.ID = newEventID()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.stopped {
+		return
+	}
+	for _, q := range n.sinks {
+		if !q.wants(e.Type) {
+			continue
+		}
+		select {
+		case q.queue <- e:
+		default:
+			log.Printf("notify.%s: queue full, dropped %s event %s", q.name, e.Type, e.ID)
+		}
+	}
+}
+
+// Test sends a test event to every sink right away, without filters or
+// retries, and returns each sink's error by name
+func (n *Notifier) Test(ctx context.Context) map[string]error {
+	e := Event{ID: newEventID(), Type: EventTest, Time: time.Now().UTC(), Identity: n.DefaultActor.Identity}
+	results := make(map[string]error, len(n.sinks))
+	for _, q := range n.sinks {
+		ctx, cancel := context.WithTimeout(ctx, attemptTimeout)
+		results[q.name] = q.sink.Send(ctx, e)
+		cancel()
+	}
+	return results
+}
+
+// RecordChange implements cpanel.ChangeRecorder
+func (n *Notifier) RecordChange(ctx context.Context, c cpanel.Change) {
+	actor, ok := audit.ActorFromContext(ctx)
+	if !ok {
+		actor = n.DefaultActor
+	}
+	e := Event{
+		Zone:       c.Zone,
+		Name:       c.Name,
+		RecordType: c.Type,
+		OldValue:   c.OldValue,
+		NewValue:   c.NewValue,
+		Serial:     c.Serial,
+		Forced:     c.Forced,
+		Identity:   actor.Identity,
+		SourceIP:   actor.SourceIP,
+	}
+	switch {
+	case c.Err != nil:
+		e.Type = EventFailed
+		e.Error = fmt.Sprintf("%s: %v", c.Op, c.Err)
+	case c.Op == "create":
+		e.Type = EventCreated
+	case c.Op == "edit":
+		e.Type = EventEdited
+	case c.Op == "delete":
+		e.Type = EventDeleted
+	default:
+		return
+	}
+	n.Publish(e)
+}
+
+// HealthChanged publishes a cPanel account failing its readiness check, or
+// passing it again if err is nil. Single failed calls are not published:
+// those made for a record change are reported as record.failed already.
+func (n *Notifier) HealthChanged(account string, err error) {
+	if err != nil {
+		n.Publish(Event{Type: EventCPanelError, Account: account, Error: err.Error()})
+		return
+	}
+	n.Publish(Event{Type: EventCPanelRecovered, Account: account})
+}
+
+// deliver sends the queued events of q, retrying failed deliveries with
+// backoff until they succeed, fail permanently or run out of attempts
+func (n *Notifier) deliver(q *sinkQueue) {
+	defer n.wg.Done()
+	for e := range q.queue {
+		for attempt := 1; ; attempt++ {
+			ctx, cancel := context.WithTimeout(context.Background(), attemptTimeout)
+			err := q.sink.Send(ctx, e)
+			cancel()
+			if err == nil {
+				break
+			}
+			var permanent *permanentError
+			if errors.As(err, &permanent) || attempt == maxAttempts {
+				log.Printf("notify.%s: failed to deliver %s event %s after %d attempt(s): %v", q.name, e.Type, e.ID, attempt, err)
+				break
+			}
+			select {
+			case <-time.After(backoff(attempt)):
+				continue
+			case <-n.stop:
+			}
+			log.Printf("notify.%s: failed to deliver %s event %s, shutting down: %v", q.name, e.Type, e.ID, err)
+			break
+		}
+	}
+}
+
+// backoff returns the delay after the given number of failed attempts:
+// 1s, 2s, 4s, ...
+func backoff(attempts int) time.Duration {
+	return time.Second << (attempts - 1)
+}
+
+func newEventID() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
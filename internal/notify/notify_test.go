@@ -0,0 +1,104 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"dns-proxy/internal/audit"
+	"dns-proxy/internal/cpanel"
+)
+
+func TestNotifierDelivers(t *testing.T) {
+	all, allCh := recordingServer(t, http.StatusOK)
+	failures, failuresCh := recordingServer(t, http.StatusOK)
+	n, err := Load(map[string]string{
+		"notify.all.type":        "webhook",
+		"notify.all.url":         all.URL,
+		"notify.failures.type":   "webhook",
+		"notify.failures.url":    failures.URL,
+		"notify.failures.events": "record.failed, cpanel.*",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.DefaultActor = audit.Actor{Identity: "unix:root"}
+	n.Start()
+
+	ctx := audit.WithActor(context.Background(), audit.Actor{Identity: "token:certbot", SourceIP: "192.0.2.10"})
+	n.RecordChange(ctx, cpanel.Change{Op: "create", Zone: "example.com", Name: "_acme-challenge.example.com.", Type: "TXT", NewValue: "token"})
+	n.RecordChange(context.Background(), cpanel.Change{Op: "delete", Zone: "example.com", Name: "_acme-challenge.example.com.", Type: "TXT", OldValue: "token", Forced: true, Err: cpanel.ErrRecordNotFound})
+	n.HealthChanged("user", errors.New("credentials rejected"))
+	n.HealthChanged("user", nil)
+	n.Drain()
+
+	events := func(ch <-chan received) []Event {
+		var events []Event
+		for len(ch) > 0 {
+			var e Event
+			if err := json.Unmarshal((<-ch).body, &e); err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, e)
+		}
+		return events
+	}
+	types := func(events []Event) string {
+		var types []string
+		for _, e := range events {
+			types = append(types, e.Type)
+		}
+		return strings.Join(types, " ")
+	}
+
+	got := events(allCh)
+	if want := "record.created record.failed cpanel.error cpanel.recovered"; types(got) != want {
+		t.Fatalf("all: got %q, want %q", types(got), want)
+	}
+	if got[0].Identity != "token:certbot" || got[0].SourceIP != "192.0.2.10" {
+		t.Errorf("created by %q from %q, want the request's actor", got[0].Identity, got[0].SourceIP)
+	}
+	if got[1].Identity != "unix:root" || !got[1].Forced || !strings.HasPrefix(got[1].Error, "delete: ") {
+		t.Errorf("failed event %+v, want the default actor, forced and the error", got[1])
+	}
+	if got[2].Account != "user" || got[2].Error != "credentials rejected" {
+		t.Errorf("cpanel.error event %+v", got[2])
+	}
+	if got[0].ID == "" || got[0].ID == got[1].ID || got[0].Time.IsZero() {
+		t.Errorf("events need distinct IDs and a time: %+v", got[:2])
+	}
+
+	if want := "record.failed cpanel.error cpanel.recovered"; types(events(failuresCh)) != want {
+		t.Errorf("failures: want %q", want)
+	}
+
+	// Events published after Drain are dropped
+	n.HealthChanged("user", errors.New("late"))
+	if len(allCh) != 0 {
+		t.Error("event delivered after Drain")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	if n, err := Load(map[string]string{"cpanel_user": "user"}); n != nil || err != nil {
+		t.Errorf("Load without sinks = %v, %v, want nil, nil", n, err)
+	}
+	tests := []struct {
+		cfg  map[string]string
+		want string
+	}{
+		{map[string]string{"notify.x": "webhook"}, "expected notify.<name>.<setting>"},
+		{map[string]string{"notify.x.url": "https://example.com"}, "notify.x: type is required"},
+		{map[string]string{"notify.x.type": "sms"}, `unknown type "sms"`},
+		{map[string]string{"notify.x.type": "webhook", "notify.x.url": "ftp://example.com"}, "url must be an http or https URL"},
+		{map[string]string{"notify.x.type": "ntfy", "notify.x.url": "https://ntfy.sh/x", "notify.x.events": "record.[, "}, "notify.x.events: invalid pattern"},
+	}
+	for _, tt := range tests {
+		if _, err := Load(tt.cfg); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Load(%v) = %v, want %q", tt.cfg, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// SMTPSink emails each event. STARTTLS is used whenever the server offers
+// it; credentials are only sent over TLS or to localhost.
+type SMTPSink struct {
+	Addr     string // host:port, usually port 587
+	From     string
+	To       []string
+	Username string
+	Password string
+	RootCAs  *x509.CertPool // verifies the server's certificate, nil for the system CAs
+}
+
+func newSMTPSink(f map[string]string) (*SMTPSink, error) {
+	if _, _, err := net.SplitHostPort(f["addr"]); err != nil {
+		return nil, fmt.Errorf("addr must be host:port, got %q", f["addr"])
+	}
+	if f["from"] == "" {
+		return nil, errors.New("from is required")
+	}
+	s := &SMTPSink{Addr: f["addr"], From: f["from"], Username: f["username"], Password: f["password"]}
+	for _, to := range strings.Split(f["to"], ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			s.To = append(s.To, to)
+		}
+	}
+	if len(s.To) == 0 {
+		return nil, errors.New("to is required")
+	}
+	if path := f["tls_ca"]; path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls_ca: %w", err)
+		}
+		s.RootCAs = x509.NewCertPool()
+		if !s.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", path)
+		}
+	}
+	return s, nil
+}
+
+func (s *SMTPSink) Send(ctx context.Context, e Event) error {
+	host, _, _ := net.SplitHostPort(s.Addr)
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host, RootCAs: s.RootCAs}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return &permanentError{err}
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.message(e)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message returns the email for e
+func (s *SMTPSink) message(e Event) []byte {
+	// Header values come from validated names, but values and errors
+	// may hold anything
+	oneLine := strings.NewReplacer("\r", " ", "\n", " ")
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&b, "Subject: [dns-proxy] %s\r\n", oneLine.Replace(e.Summary()))
+	fmt.Fprintf(&b, "Date: %s\r\n", e.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@dns-proxy>\r\n", e.ID)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(e.Details(), "\n", "\r\n"))
+	return b.Bytes()
+}
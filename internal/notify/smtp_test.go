@@ -0,0 +1,264 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/pem"
+	"net"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mail is a message accepted by fakeSMTP
+type mail struct {
+	from string
+	to   []string
+	data string
+	auth string // decoded AUTH PLAIN credentials, "" without AUTH
+	tls  bool   // whether the message was sent after STARTTLS
+}
+
+// fakeSMTP is a minimal SMTP server. It offers STARTTLS if tlsConfig is
+// set and AUTH PLAIN once the connection is encrypted.
+type fakeSMTP struct {
+	ln        net.Listener
+	tlsConfig *tls.Config
+
+	mu   sync.Mutex
+	mail []mail
+}
+
+func newFakeSMTP(t *testing.T, tlsConfig *tls.Config) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{ln: ln, tlsConfig: tlsConfig}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP")
+
+	var m mail
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO":
+			ext := []string{"250-localhost", "250-8BITMIME"}
+			if s.tlsConfig != nil && !m.tls {
+				ext = append(ext, "250-STARTTLS")
+			}
+			if m.tls {
+				ext = append(ext, "250-AUTH PLAIN")
+			}
+			for _, l := range ext {
+				tp.PrintfLine("%s", l)
+			}
+			tp.PrintfLine("250 HELP")
+		case "STARTTLS":
+			tp.PrintfLine("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			tp = textproto.NewConn(conn)
+			m = mail{tls: true}
+		case "AUTH":
+			mech, resp, _ := strings.Cut(arg, " ")
+			creds, err := base64.StdEncoding.DecodeString(resp)
+			if mech != "PLAIN" || err != nil {
+				tp.PrintfLine("504 unsupported")
+				continue
+			}
+			m.auth = strings.ReplaceAll(string(creds), "\x00", ":")
+			tp.PrintfLine("235 Authenticated")
+		case "MAIL":
+			m.from = address(arg)
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			m.to = append(m.to, address(arg))
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 Go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			m.data = string(data)
+			s.mu.Lock()
+			s.mail = append(s.mail, m)
+			s.mu.Unlock()
+			tp.PrintfLine("250 Queued")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("500 unknown command")
+		}
+	}
+}
+
+// address returns the <address> of a MAIL FROM or RCPT TO argument
+func address(arg string) string {
+	_, rest, _ := strings.Cut(arg, "<")
+	addr, _, _ := strings.Cut(rest, ">")
+	return addr
+}
+
+func (s *fakeSMTP) received() []mail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]mail(nil), s.mail...)
+}
+
+var testEvent = Event{
+	ID:         "abc123",
+	Type:       EventCreated,
+	Time:       time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+	Name:       "_acme-challenge.example.com.",
+	RecordType: "TXT",
+	NewValue:   "token\r\nBcc: evil@example.com",
+}
+
+func TestSMTPPlain(t *testing.T) {
+	srv := newFakeSMTP(t, nil)
+	sink, err := newSMTPSink(map[string]string{
+		"addr": srv.ln.Addr().String(),
+		"from": "dns-proxy@example.com",
+		"to":   "admin@example.com, oncall@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	got := srv.received()
+	if len(got) != 1 {
+		t.Fatalf("received %d messages, want 1", len(got))
+	}
+	m := got[0]
+	if m.from != "dns-proxy@example.com" || strings.Join(m.to, ",") != "admin@example.com,oncall@example.com" {
+		t.Errorf("envelope from %q to %q", m.from, m.to)
+	}
+	if m.tls || m.auth != "" {
+		t.Errorf("tls=%v auth=%q without STARTTLS or credentials", m.tls, m.auth)
+	}
+
+	msg, err := textproto.NewReader(bufio.NewReader(strings.NewReader(m.data))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Get("Bcc") != "" {
+		t.Error("a value with a line break added a header")
+	}
+	if want := "[dns-proxy] " + strings.ReplaceAll(testEvent.Summary(), "\r\n", "  "); msg.Get("Subject") != want {
+		t.Errorf("Subject = %q, want %q", msg.Get("Subject"), want)
+	}
+	if msg.Get("Message-Id") != "<abc123@dns-proxy>" {
+		t.Errorf("Message-ID = %q", msg.Get("Message-Id"))
+	}
+	if !strings.Contains(m.data, "Name: _acme-challenge.example.com.\n") {
+		t.Errorf("body lacks the event details:\n%s", m.data)
+	}
+}
+
+func TestSMTPStartTLS(t *testing.T) {
+	// httptest's certificate is valid for 127.0.0.1
+	https := httptest.NewTLSServer(nil)
+	https.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: https.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	srv := newFakeSMTP(t, &tls.Config{Certificates: https.TLS.Certificates})
+
+	cfg := map[string]string{
+		"addr":     srv.ln.Addr().String(),
+		"from":     "dns-proxy@example.com",
+		"to":       "admin@example.com",
+		"username": "dns-proxy",
+		"password": "smtp_password",
+	}
+	// Without tls_ca the test certificate is not trusted
+	sink, err := newSMTPSink(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testEvent); err == nil {
+		t.Fatal("sent over TLS to a server with an untrusted certificate")
+	}
+
+	cfg["tls_ca"] = caFile
+	if sink, err = newSMTPSink(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testEvent); err != nil {
+		t.Fatal(err)
+	}
+	got := srv.received()
+	if len(got) != 1 {
+		t.Fatalf("received %d messages, want 1", len(got))
+	}
+	if !got[0].tls || got[0].auth != ":dns-proxy:smtp_password" {
+		t.Errorf("tls=%v auth=%q, want credentials sent over TLS", got[0].tls, got[0].auth)
+	}
+}
+
+func TestSMTPConfig(t *testing.T) {
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	valid := map[string]string{"addr": "smtp.example.com:587", "from": "a@example.com", "to": "b@example.com"}
+	tests := []struct {
+		field, value string
+		want         string
+	}{
+		{"addr", "smtp.example.com", "addr must be host:port"},
+		{"from", "", "from is required"},
+		{"to", " , ", "to is required"},
+		{"tls_ca", filepath.Join(t.TempDir(), "missing.pem"), "failed to read tls_ca"},
+		{"tls_ca", notPEM, "no certificates found"},
+	}
+	for _, tt := range tests {
+		f := make(map[string]string)
+		for k, v := range valid {
+			f[k] = v
+		}
+		f[tt.field] = tt.value
+		if _, err := newSMTPSink(f); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s=%q: err = %v, want %q", tt.field, tt.value, err, tt.want)
+		}
+	}
+}
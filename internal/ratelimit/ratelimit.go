@@ -0,0 +1,80 @@
+// Package ratelimit implements keyed token buckets, used to limit API
+// requests per token and per client address.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many Allow calls pass between sweeps of idle buckets
+const sweepEvery = 1024
+
+// Limiter holds one token bucket per key. Each bucket holds up to Burst
+// tokens and refills at Rate tokens per second; a request takes one token.
+type Limiter struct {
+	Rate  float64
+	Burst int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	calls   int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New returns a limiter refilling rate tokens per second up to burst
+func New(rate float64, burst int) *Limiter {
+	return &Limiter{Rate: rate, Burst: burst, buckets: make(map[string]*bucket)}
+}
+
+// Allow takes a token from key's bucket. If the bucket is empty it returns
+// false and how long until a token is available.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.calls++
+	if l.calls%sweepEvery == 0 {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.refill(now, l.Rate, l.Burst)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if l.Rate <= 0 {
+		return false, time.Hour
+	}
+	wait := time.Duration(math.Ceil((1 - b.tokens) / l.Rate * float64(time.Second)))
+	return false, wait
+}
+
+func (b *bucket) refill(now time.Time, rate float64, burst int) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(burst), b.tokens+elapsed*rate)
+		b.last = now
+	}
+}
+
+// sweep drops buckets that have refilled completely, they behave exactly
+// like a new bucket and only use memory
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		b.refill(now, l.Rate, l.Burst)
+		if b.tokens >= float64(l.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	l := New(2, 3) // 2 per second, bursts of 3
+	now := time.Unix(1700000000, 0)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a", now); !ok {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	ok, wait := l.Allow("a", now)
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("after the burst: %v, %v, want refused for 500ms", ok, wait)
+	}
+	// Other keys have buckets of their own
+	if ok, _ := l.Allow("b", now); !ok {
+		t.Error("b refused because of a")
+	}
+
+	// Half a second refills one token
+	if ok, _ := l.Allow("a", now.Add(250*time.Millisecond)); ok {
+		t.Error("allowed before a token was refilled")
+	}
+	if ok, _ := l.Allow("a", now.Add(500*time.Millisecond)); !ok {
+		t.Error("refused after a token was refilled")
+	}
+
+	// A bucket never holds more than the burst
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		l.Allow("a", later)
+	}
+	if ok, _ := l.Allow("a", later); ok {
+		t.Error("bucket refilled beyond the burst")
+	}
+}
+
+func TestAllowWithoutRate(t *testing.T) {
+	l := New(0, 1)
+	now := time.Unix(1700000000, 0)
+	if ok, _ := l.Allow("a", now); !ok {
+		t.Fatal("first request refused")
+	}
+	if ok, wait := l.Allow("a", now.Add(time.Hour)); ok || wait != time.Hour {
+		t.Errorf("second request: %v, %v, want refused, never refilled", ok, wait)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l := New(1, 1)
+	now := time.Unix(1700000000, 0)
+	for i := 0; i < sweepEvery-1; i++ {
+		l.Allow(fmt.Sprint(i), now)
+	}
+	if len(l.buckets) != sweepEvery-1 {
+		t.Fatalf("%d buckets, want %d", len(l.buckets), sweepEvery-1)
+	}
+	// The sweep drops every bucket that refilled, but keeps empty ones
+	later := now.Add(time.Second)
+	l.buckets["empty"] = &bucket{tokens: 0, last: later}
+	l.Allow("new", later)
+	if len(l.buckets) != 2 || l.buckets["empty"] == nil || l.buckets["new"] == nil {
+		t.Errorf("after the sweep %d buckets remain, want empty and new", len(l.buckets))
+	}
+}
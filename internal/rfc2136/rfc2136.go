@@ -0,0 +1,584 @@
+// Package rfc2136 implements a DNS UPDATE (RFC 2136) listener that applies
+// TSIG-signed TXT record changes through the cPanel record operations.
+//
+// Only TXT records are managed. Prerequisites and updates for other types
+// are refused, as are the "name is in use" prerequisites, which would need
+// knowledge of every record type in the zone.
+package rfc2136
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"dns-proxy/internal/audit"
+	"dns-proxy/internal/auth"
+	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/dnsclient"
+)
+
+// updateTimeout bounds the cPanel calls made for a single UPDATE message
+const updateTimeout = 60 * time.Second
+
+// maxUDPHandlers bounds the UDP messages handled at once. Further packets
+// wait in the socket buffer, and are dropped by the kernel once it is full.
+const maxUDPHandlers = 64
+
+// maxTCPConns bounds the TCP connections served at once. Further
+// connections wait in the listen backlog until one is closed.
+const maxTCPConns = 64
+
+// Records is the subset of cPanel operations used by the listener
+type Records interface {
+	ListTxtRecords(ctx context.Context, domain, keyFilter string) ([]cpanel.TxtRecord, error)
+	CreateTxtRecord(ctx context.Context, domain, key, value string) error
+	DeleteTxtRecord(ctx context.Context, domain, key, value string) error
+}
+
+// Server listens for UPDATE messages on UDP and TCP
+type Server struct {
+	Addr string
+	Keys map[string]*dnsclient.TSIGKey // by lower-case key name with trailing dot
+	// Scopes limits the zones and names a key may update, by key name as
+	// in Keys. Keys without a scope may update any zone of the account.
+	Scopes  map[string]auth.Scope
+	Records Records
+	// AllowUnsigned accepts updates without a TSIG record. Only meant for
+	// listeners bound to a trusted interface.
+	AllowUnsigned bool
+
+	mu sync.Mutex // UPDATE messages are applied one at a time
+
+	stateMu  sync.Mutex
+	draining bool
+	inFlight sync.WaitGroup // UPDATE messages being applied
+}
+
+// ListenAndServe serves UDP and TCP on s.Addr until one of the listeners fails
+func (s *Server) ListenAndServe() error {
+	pc, err := net.ListenPacket("udp", s.Addr)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	errc := make(chan error, 2)
+	go func() { errc <- s.serveUDP(pc) }()
+	go func() { errc <- s.serveTCP(ln) }()
+	return <-errc
+}
+
+func (s *Server) serveUDP(pc net.PacketConn) error {
+	buf := make([]byte, 65535)
+	slots := make(chan struct{}, maxUDPHandlers)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		req := append([]byte(nil), buf[:n]...)
+		slots <- struct{}{}
+		go func() {
+			defer func() { <-slots }()
+			if resp := s.Handle(req, addr); resp != nil {
+				pc.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCP(ln net.Listener) error {
+	slots := make(chan struct{}, maxTCPConns)
+	for {
+		slots <- struct{}{}
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer func() { <-slots }()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		conn.SetDeadline(time.Now().Add(updateTimeout + 10*time.Second))
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		resp := s.Handle(req, conn.RemoteAddr())
+		if resp == nil {
+			return
+		}
+		frame := binary.BigEndian.AppendUint16(nil, uint16(len(resp)))
+		if _, err := conn.Write(append(frame, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+// Handle processes a single wire format message and returns the response
+// to send, or nil if the message should be dropped
+func (s *Server) Handle(req []byte, from net.Addr) []byte {
+	msg, err := dnsclient.Unpack(req)
+	if err != nil || msg.Response {
+		// Not worth answering, we cannot even echo the header reliably
+		return nil
+	}
+
+	resp := &dnsclient.Message{
+		Header:    dnsclient.Header{ID: msg.ID, Response: true, Opcode: msg.Opcode},
+		Questions: msg.Questions,
+	}
+
+	if msg.Opcode != dnsclient.OpcodeUpdate {
+		resp.Rcode = dnsclient.RcodeNotImp
+		return s.pack(resp, nil, nil, 0)
+	}
+
+	// Authenticate
+	var key *dnsclient.TSIGKey
+	tsig, err := dnsclient.VerifyTSIG(req, nil, s.lookupKey, time.Now())
+	switch {
+	case err == nil:
+		key, _ = s.lookupKey(strings.ToLower(tsig.KeyName))
+	case errors.Is(err, dnsclient.ErrNoTSIG) && s.AllowUnsigned:
+	case errors.Is(err, dnsclient.ErrNoTSIG):
+		log.Printf("rfc2136: %s: refused unsigned update", from)
+		resp.Rcode = dnsclient.RcodeRefused
+		return s.pack(resp, nil, nil, 0)
+	case errors.Is(err, dnsclient.ErrBadKey), errors.Is(err, dnsclient.ErrBadSig), errors.Is(err, dnsclient.ErrBadTime):
+		log.Printf("rfc2136: %s: TSIG verification failed: %v", from, err)
+		resp.Rcode = dnsclient.RcodeNotAuth
+		signKey := &dnsclient.TSIGKey{Name: tsig.KeyName, Algorithm: tsig.Algorithm}
+		tsigErr := dnsclient.TSIGBadKey
+		switch {
+		case errors.Is(err, dnsclient.ErrBadSig):
+			tsigErr = dnsclient.TSIGBadSig
+		case errors.Is(err, dnsclient.ErrBadTime):
+			// The signature is valid, so the error response is signed
+			tsigErr = dnsclient.TSIGBadTime
+			signKey, _ = s.lookupKey(strings.ToLower(tsig.KeyName))
+		}
+		return s.pack(resp, signKey, tsig.MAC, tsigErr)
+	default:
+		resp.Rcode = dnsclient.RcodeFormErr
+		return s.pack(resp, nil, nil, 0)
+	}
+
+	var requestMAC []byte
+	if tsig != nil {
+		requestMAC = tsig.MAC
+	}
+
+	keyName := "unsigned"
+	if key != nil {
+		keyName = key.Name
+	}
+	resp.Rcode = s.apply(msg, from, keyName)
+	return s.pack(resp, key, requestMAC, 0)
+}
+
+// Drain waits for the UPDATE messages being applied and answers further
+// ones with SERVFAIL. It is called on shutdown.
+func (s *Server) Drain() {
+	s.stateMu.Lock()
+	s.draining = true
+	s.stateMu.Unlock()
+	s.inFlight.Wait()
+}
+
+// begin registers an UPDATE about to be applied, unless the server is
+// draining. The caller calls s.inFlight.Done when it is done.
+func (s *Server) begin() bool {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.draining {
+		return false
+	}
+	s.inFlight.Add(1)
+	return true
+}
+
+func (s *Server) lookupKey(name string) (*dnsclient.TSIGKey, bool) {
+	key, ok := s.Keys[name]
+	return key, ok
+}
+
+// pack encodes resp, signing it with key when one is given
+func (s *Server) pack(resp *dnsclient.Message, key *dnsclient.TSIGKey, requestMAC []byte, tsigErr uint16) []byte {
+	out, err := resp.Pack()
+	if err != nil {
+		log.Printf("rfc2136: failed to encode response: %v", err)
+		return nil
+	}
+	if key == nil {
+		return out
+	}
+	signed, err := dnsclient.SignTSIG(out, key, requestMAC, time.Now(), tsigErr)
+	if err != nil {
+		log.Printf("rfc2136: failed to sign response: %v", err)
+		return out
+	}
+	return signed
+}
+
+// apply checks the zone, the prerequisites and the updates of msg and
+// applies the updates. It returns the response code.
+func (s *Server) apply(msg *dnsclient.Message, from net.Addr, keyName string) uint8 {
+	// Zone section: exactly one SOA question (RFC 2136, 3.1)
+	if len(msg.Questions) != 1 || msg.Questions[0].Type != dnsclient.TypeSOA {
+		return dnsclient.RcodeFormErr
+	}
+	zone := strings.ToLower(strings.TrimSuffix(msg.Questions[0].Name, "."))
+	if zone == "" {
+		return dnsclient.RcodeNotAuth
+	}
+
+	scope, scoped := s.Scopes[keyName]
+	if scoped && !scope.AllowsZone(auth.OpCreate, zone) && !scope.AllowsZone(auth.OpDelete, zone) {
+		log.Printf("rfc2136: %s (key %s): refused update of zone %s outside the key's scope", from, keyName, zone)
+		return dnsclient.RcodeRefused
+	}
+
+	changes, rcode := parseUpdates(zone, msg.Questions[0].Class, msg.Authority)
+	if rcode != dnsclient.RcodeSuccess {
+		return rcode
+	}
+	if scoped {
+		for _, change := range changes {
+			if !scope.Allows(change.scopeOp(), change.name, "TXT") {
+				log.Printf("rfc2136: %s (key %s): refused %s outside the key's scope", from, keyName, change)
+				return dnsclient.RcodeRefused
+			}
+		}
+	}
+
+	if !s.begin() {
+		log.Printf("rfc2136: %s (key %s): refused update of zone %s while shutting down", from, keyName, zone)
+		return dnsclient.RcodeServFail
+	}
+	defer s.inFlight.Done()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	identity := "tsig:" + keyName
+	if keyName == "unsigned" {
+		identity = "unsigned"
+	}
+	ip := from.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	ctx := audit.WithActor(context.Background(), audit.Actor{Identity: identity, SourceIP: ip})
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
+
+	rrsets := &rrsetCache{records: s.Records, zone: zone, ctx: ctx}
+	if rcode := checkPrerequisites(zone, msg.Questions[0].Class, msg.Answers, rrsets); rcode != dnsclient.RcodeSuccess {
+		log.Printf("rfc2136: %s (key %s): prerequisite failed for zone %s: %s", from, keyName, zone, dnsclient.RcodeString(rcode))
+		return rcode
+	}
+
+	// An UPDATE is applied entirely or not at all (RFC 2136, 3.4.2), so
+	// a failed change undoes the ones before it
+	var done []applied
+	for _, change := range changes {
+		if err := change.apply(ctx, s.Records, rrsets, &done); err != nil {
+			log.Printf("rfc2136: %s (key %s): %s failed: %v", from, keyName, change, err)
+			if err := rollback(ctx, s.Records, done); err != nil {
+				log.Printf("rfc2136: %s (key %s): rollback of zone %s incomplete: %v", from, keyName, zone, err)
+			} else if len(done) > 0 {
+				log.Printf("rfc2136: %s (key %s): rolled back %d change(s) to zone %s", from, keyName, len(done), zone)
+			}
+			if errors.Is(err, cpanel.ErrNotOwned) {
+				return dnsclient.RcodeRefused
+			}
+			return dnsclient.RcodeServFail
+		}
+		log.Printf("rfc2136: %s (key %s): %s", from, keyName, change)
+	}
+	return dnsclient.RcodeSuccess
+}
+
+// applied is a record created or deleted in cPanel while applying an UPDATE
+type applied struct {
+	created bool
+	name    string // lower-case owner name without trailing dot
+	value   string
+}
+
+// rollback reverts done, last change first. It gets a fresh timeout, as
+// the update may have failed by running out of time, and skips the
+// ownership check to restore records the proxy does not own.
+func rollback(ctx context.Context, records Records, done []applied) error {
+	ctx, cancel := context.WithTimeout(cpanel.WithForce(context.WithoutCancel(ctx)), updateTimeout)
+	defer cancel()
+
+	var errs []error
+	for i := len(done) - 1; i >= 0; i-- {
+		a := done[i]
+		domain, key := cpanel.SplitFQDN(a.name)
+		if a.created {
+			if err := records.DeleteTxtRecord(ctx, domain, key, a.value); err != nil {
+				errs = append(errs, fmt.Errorf("delete TXT %s %q: %w", a.name, a.value, err))
+			}
+		} else if err := records.CreateTxtRecord(ctx, domain, key, a.value); err != nil {
+			errs = append(errs, fmt.Errorf("restore TXT %s %q: %w", a.name, a.value, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rrsetCache fetches the TXT RRsets of a zone from cPanel on first use
+type rrsetCache struct {
+	records Records
+	zone    string
+	ctx     context.Context
+	values  map[string][]string // by lower-case name without trailing dot
+	err     error
+}
+
+// get returns the TXT values currently published at name
+func (c *rrsetCache) get(name string) ([]string, error) {
+	if c.values == nil && c.err == nil {
+		records, err := c.records.ListTxtRecords(c.ctx, c.zone, "")
+		if err != nil {
+			c.err = err
+			return nil, err
+		}
+		c.values = make(map[string][]string)
+		for _, record := range records {
+			fqdn := strings.ToLower(strings.TrimSuffix(record.Name, "."))
+			c.values[fqdn] = append(c.values[fqdn], record.Value)
+		}
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.values[name], nil
+}
+
+func (c *rrsetCache) set(name string, values []string) {
+	if c.values != nil {
+		c.values[name] = values
+	}
+}
+
+// inZone reports whether name (lower-case, no trailing dot) is zone or below it
+func inZone(name, zone string) bool {
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+func ownerName(rr dnsclient.Resource) string {
+	return strings.ToLower(strings.TrimSuffix(rr.Name, "."))
+}
+
+// checkPrerequisites evaluates the prerequisite section (RFC 2136, 3.2)
+func checkPrerequisites(zone string, zoneClass uint16, prereqs []dnsclient.Resource, rrsets *rrsetCache) uint8 {
+	// Value dependent prerequisites are compared as whole RRsets
+	required := make(map[string][]string)
+
+	for _, rr := range prereqs {
+		name := ownerName(rr)
+		if rr.TTL != 0 {
+			return dnsclient.RcodeFormErr
+		}
+		if !inZone(name, zone) {
+			return dnsclient.RcodeNotZone
+		}
+		if rr.Type != dnsclient.TypeTXT {
+			// Name in use checks and other types need the whole zone
+			return dnsclient.RcodeNotImp
+		}
+
+		switch rr.Class {
+		case dnsclient.ClassANY: // RRset exists (value independent)
+			if len(rr.Data) != 0 {
+				return dnsclient.RcodeFormErr
+			}
+			values, err := rrsets.get(name)
+			if err != nil {
+				return dnsclient.RcodeServFail
+			}
+			if len(values) == 0 {
+				return dnsclient.RcodeNXRRSet
+			}
+		case dnsclient.ClassNONE: // RRset does not exist
+			if len(rr.Data) != 0 {
+				return dnsclient.RcodeFormErr
+			}
+			values, err := rrsets.get(name)
+			if err != nil {
+				return dnsclient.RcodeServFail
+			}
+			if len(values) != 0 {
+				return dnsclient.RcodeYXRRSet
+			}
+		case zoneClass: // RRset exists (value dependent)
+			required[name] = append(required[name], strings.Join(rr.TXT, ""))
+		default:
+			return dnsclient.RcodeFormErr
+		}
+	}
+
+	for name, want := range required {
+		have, err := rrsets.get(name)
+		if err != nil {
+			return dnsclient.RcodeServFail
+		}
+		if !sameValues(want, have) {
+			return dnsclient.RcodeNXRRSet
+		}
+	}
+	return dnsclient.RcodeSuccess
+}
+
+// sameValues compares two RRsets, ignoring order and duplicates
+func sameValues(a, b []string) bool {
+	set := func(values []string) map[string]bool {
+		m := make(map[string]bool)
+		for _, v := range values {
+			m[v] = true
+		}
+		return m
+	}
+	as, bs := set(a), set(b)
+	if len(as) != len(bs) {
+		return false
+	}
+	for v := range as {
+		if !bs[v] {
+			return false
+		}
+	}
+	return true
+}
+
+// change is a single update to a TXT RRset
+type change struct {
+	op    string // "add", "delete" or "delete-rrset"
+	name  string // lower-case owner name without trailing dot
+	value string
+}
+
+// scopeOp returns the token operation that covers the change
+func (c change) scopeOp() string {
+	if c.op == "add" {
+		return auth.OpCreate
+	}
+	return auth.OpDelete
+}
+
+func (c change) String() string {
+	if c.op == "delete-rrset" {
+		return fmt.Sprintf("%s TXT %s", c.op, c.name)
+	}
+	return fmt.Sprintf("%s TXT %s %q", c.op, c.name, c.value)
+}
+
+// apply performs the change through cPanel and appends the records it
+// created or deleted to done. Adding a value that already exists and
+// deleting one that does not are no-ops, as RFC 2136 requires.
+func (c change) apply(ctx context.Context, records Records, rrsets *rrsetCache, done *[]applied) error {
+	domain, key := cpanel.SplitFQDN(c.name)
+	if key == "" {
+		return errors.New("records at the zone apex are not managed")
+	}
+	values, err := rrsets.get(c.name)
+	if err != nil {
+		return err
+	}
+
+	switch c.op {
+	case "add":
+		for _, v := range values {
+			if v == c.value {
+				return nil
+			}
+		}
+		if err := records.CreateTxtRecord(ctx, domain, key, c.value); err != nil {
+			return err
+		}
+		*done = append(*done, applied{created: true, name: c.name, value: c.value})
+		rrsets.set(c.name, append(values, c.value))
+	case "delete", "delete-rrset":
+		var kept []string
+		for i, v := range values {
+			if c.op == "delete" && v != c.value {
+				kept = append(kept, v)
+				continue
+			}
+			err := records.DeleteTxtRecord(ctx, domain, key, v)
+			if err != nil && !errors.Is(err, cpanel.ErrRecordNotFound) {
+				rrsets.set(c.name, append(kept, values[i:]...))
+				return err
+			}
+			if err == nil {
+				*done = append(*done, applied{name: c.name, value: v})
+			}
+		}
+		rrsets.set(c.name, kept)
+	}
+	return nil
+}
+
+// parseUpdates prescans the update section (RFC 2136, 3.4.1) and returns
+// the changes to apply
+func parseUpdates(zone string, zoneClass uint16, updates []dnsclient.Resource) ([]change, uint8) {
+	var changes []change
+	for _, rr := range updates {
+		name := ownerName(rr)
+		if !inZone(name, zone) {
+			return nil, dnsclient.RcodeNotZone
+		}
+		if rr.Type != dnsclient.TypeTXT {
+			// Only TXT records are managed through this listener
+			return nil, dnsclient.RcodeRefused
+		}
+		if name == zone {
+			return nil, dnsclient.RcodeRefused
+		}
+
+		switch rr.Class {
+		case zoneClass: // add to an RRset
+			if len(rr.TXT) == 0 {
+				return nil, dnsclient.RcodeFormErr
+			}
+			changes = append(changes, change{op: "add", name: name, value: strings.Join(rr.TXT, "")})
+		case dnsclient.ClassANY: // delete an RRset
+			if rr.TTL != 0 || len(rr.Data) != 0 {
+				return nil, dnsclient.RcodeFormErr
+			}
+			changes = append(changes, change{op: "delete-rrset", name: name})
+		case dnsclient.ClassNONE: // delete an RR from an RRset
+			if rr.TTL != 0 {
+				return nil, dnsclient.RcodeFormErr
+			}
+			changes = append(changes, change{op: "delete", name: name, value: strings.Join(rr.TXT, "")})
+		default:
+			return nil, dnsclient.RcodeFormErr
+		}
+	}
+	return changes, dnsclient.RcodeSuccess
+}
@@ -0,0 +1,257 @@
+package rfc2136
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"dns-proxy/internal/auth"
+	"dns-proxy/internal/cpanel"
+	"dns-proxy/internal/dnsclient"
+)
+
+// fakeRecords keeps TXT values by lower-case name without trailing dot.
+// Creating failValue fails.
+type fakeRecords struct {
+	mu        sync.Mutex
+	values    map[string][]string
+	failValue string
+}
+
+func (f *fakeRecords) ListTxtRecords(ctx context.Context, domain, keyFilter string) ([]cpanel.TxtRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var records []cpanel.TxtRecord
+	for name, values := range f.values {
+		for _, v := range values {
+			records = append(records, cpanel.TxtRecord{Name: name + ".", Value: v})
+		}
+	}
+	return records, nil
+}
+
+func (f *fakeRecords) CreateTxtRecord(ctx context.Context, domain, key, value string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if value == f.failValue {
+		return errors.New("cPanel failed")
+	}
+	name := key + "." + domain
+	f.values[name] = append(f.values[name], value)
+	return nil
+}
+
+func (f *fakeRecords) DeleteTxtRecord(ctx context.Context, domain, key, value string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := key + "." + domain
+	for i, v := range f.values[name] {
+		if v == value {
+			f.values[name] = append(f.values[name][:i], f.values[name][i+1:]...)
+			return nil
+		}
+	}
+	return cpanel.ErrRecordNotFound
+}
+
+func (f *fakeRecords) state() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []string
+	for name, values := range f.values {
+		for _, v := range values {
+			out = append(out, name+"="+v)
+		}
+	}
+	sort.Strings(out)
+	return strings.Join(out, " ")
+}
+
+func update(t *testing.T, s *Server, updates ...dnsclient.Resource) uint8 {
+	t.Helper()
+	return signedUpdate(t, s, nil, "example.com.", updates...)
+}
+
+// signedUpdate sends an UPDATE of zone, signed with key unless it is nil
+func signedUpdate(t *testing.T, s *Server, key *dnsclient.TSIGKey, zone string, updates ...dnsclient.Resource) uint8 {
+	t.Helper()
+	msg := &dnsclient.Message{
+		Header:    dnsclient.Header{ID: 1, Opcode: dnsclient.OpcodeUpdate},
+		Questions: []dnsclient.Question{{Name: zone, Type: dnsclient.TypeSOA, Class: dnsclient.ClassINET}},
+		Authority: updates,
+	}
+	req, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != nil {
+		if req, err = dnsclient.SignTSIG(req, key, nil, time.Now(), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := dnsclient.Unpack(s.Handle(req, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Rcode
+}
+
+func add(name, value string) dnsclient.Resource {
+	return dnsclient.Resource{Name: name, Type: dnsclient.TypeTXT, Class: dnsclient.ClassINET, TTL: 60, TXT: []string{value}}
+}
+
+func remove(name, value string) dnsclient.Resource {
+	return dnsclient.Resource{Name: name, Type: dnsclient.TypeTXT, Class: dnsclient.ClassNONE, TXT: []string{value}}
+}
+
+func TestUpdateRolledBackOnFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		updates []dnsclient.Resource
+		rcode   uint8
+		state   string
+	}{
+		{
+			name:    "applied",
+			updates: []dnsclient.Resource{add("_acme-challenge.example.com.", "new"), remove("_acme-challenge.example.com.", "old")},
+			rcode:   dnsclient.RcodeSuccess,
+			state:   "_acme-challenge.example.com=new",
+		},
+		{
+			name:    "failed add undoes earlier add",
+			updates: []dnsclient.Resource{add("_acme-challenge.example.com.", "new"), add("_acme-challenge.example.com.", "fail")},
+			rcode:   dnsclient.RcodeServFail,
+			state:   "_acme-challenge.example.com=old",
+		},
+		{
+			name:    "failed add restores deleted records",
+			updates: []dnsclient.Resource{remove("_acme-challenge.example.com.", "old"), add("_acme-challenge.example.com.", "fail")},
+			rcode:   dnsclient.RcodeServFail,
+			state:   "_acme-challenge.example.com=old",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := &fakeRecords{values: map[string][]string{"_acme-challenge.example.com": {"old"}}, failValue: "fail"}
+			s := &Server{Records: records, AllowUnsigned: true}
+			if rcode := update(t, s, tt.updates...); rcode != tt.rcode {
+				t.Errorf("rcode %s, want %s", dnsclient.RcodeString(rcode), dnsclient.RcodeString(tt.rcode))
+			}
+			if got := records.state(); got != tt.state {
+				t.Errorf("records %q, want %q", got, tt.state)
+			}
+		})
+	}
+}
+
+func TestKeyScope(t *testing.T) {
+	key := &dnsclient.TSIGKey{Name: "certbot.", Algorithm: dnsclient.HmacSHA256, Secret: []byte("0123456789abcdef")}
+	tests := []struct {
+		zone    string
+		updates []dnsclient.Resource
+		rcode   uint8
+	}{
+		{"example.com.", []dnsclient.Resource{add("_acme-challenge.example.com.", "v")}, dnsclient.RcodeSuccess},
+		{"example.com.", []dnsclient.Resource{add("_acme-challenge.example.com.", "v"), add("www.example.com.", "v")}, dnsclient.RcodeRefused},
+		{"example.org.", []dnsclient.Resource{add("_acme-challenge.example.org.", "v")}, dnsclient.RcodeRefused},
+	}
+	for _, tt := range tests {
+		records := &fakeRecords{values: map[string][]string{}}
+		s := &Server{
+			Keys:    map[string]*dnsclient.TSIGKey{"certbot.": key},
+			Scopes:  map[string]auth.Scope{"certbot.": {Zones: []string{"example.com"}, Names: []string{"_acme-challenge.*"}}},
+			Records: records,
+		}
+		if rcode := signedUpdate(t, s, key, tt.zone, tt.updates...); rcode != tt.rcode {
+			t.Errorf("%v: rcode %s, want %s", tt.updates, dnsclient.RcodeString(rcode), dnsclient.RcodeString(tt.rcode))
+		}
+		if tt.rcode != dnsclient.RcodeSuccess && records.state() != "" {
+			t.Errorf("%v: refused update changed records: %s", tt.updates, records.state())
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	records := &fakeRecords{values: map[string][]string{}}
+	s := &Server{Records: records, AllowUnsigned: true}
+	if rcode := update(t, s, add("a.example.com.", "v")); rcode != dnsclient.RcodeSuccess {
+		t.Fatalf("rcode %s before Drain", dnsclient.RcodeString(rcode))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Drain()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Drain did not return")
+	}
+	// Handlers still running after Drain must not hang
+	if rcode := update(t, s, add("b.example.com.", "v")); rcode != dnsclient.RcodeServFail {
+		t.Errorf("rcode %s after Drain, want SERVFAIL", dnsclient.RcodeString(rcode))
+	}
+	if got := records.state(); got != "a.example.com=v" {
+		t.Errorf("records %q", got)
+	}
+}
+
+func TestTCPConnLimit(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go (&Server{}).serveTCP(ln)
+
+	var idle []net.Conn
+	defer func() {
+		for _, c := range idle {
+			c.Close()
+		}
+	}()
+	// query sends a QUERY, answered with NOTIMP, over a new connection
+	query := func() (net.Conn, error) {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := (&dnsclient.Message{Header: dnsclient.Header{ID: 1}}).Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(req))), req...)); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, err = conn.Read(make([]byte, 512))
+		return conn, err
+	}
+
+	for i := 0; i < maxTCPConns; i++ {
+		conn, err := query()
+		idle = append(idle, conn)
+		if err != nil {
+			t.Fatalf("connection %d: %v", i, err)
+		}
+	}
+	extra, err := query()
+	defer extra.Close()
+	if err == nil {
+		t.Fatalf("connection %d was served", maxTCPConns)
+	}
+
+	// Once a connection closes, the waiting one is served
+	idle[0].Close()
+	extra.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := extra.Read(make([]byte, 512)); err != nil {
+		t.Errorf("waiting connection: %v", err)
+	}
+}
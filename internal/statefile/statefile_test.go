@@ -0,0 +1,76 @@
+package statefile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "state.json")
+
+	// A missing file leaves v as it is
+	v := map[string]int{"kept": 1}
+	if err := Load(path, &v); err != nil || v["kept"] != 1 {
+		t.Fatalf("Load of a missing file = %v, %v", v, err)
+	}
+
+	if err := Save(path, map[string]int{"a": 1, "b": 2}); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]int
+	if err := Load(path, &got); err != nil || got["a"] != 1 || got["b"] != 2 {
+		t.Fatalf("Load = %v, %v", got, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("state file mode %v, want 0600", info.Mode().Perm())
+	}
+	// No temporary files are left behind
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("state directory holds %d files, want 1", len(entries))
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(path, &got); err == nil || !strings.Contains(err.Error(), "failed to parse state file") {
+		t.Errorf("Load of a corrupt file = %v", err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	// Concurrent updates do not lose each other's changes
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var n int
+			if err := Update(path, &n, func() bool { n++; return true }); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	var n int
+	if err := Load(path, &n); err != nil || n != 20 {
+		t.Errorf("counter = %d, %v, want 20", n, err)
+	}
+
+	// Nothing is written when fn reports no change
+	if err := Update(path, &n, func() bool { n = 100; return false }); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(path, &n); err != nil || n != 20 {
+		t.Errorf("counter = %d, %v after an update without change, want 20", n, err)
+	}
+}
@@ -0,0 +1,114 @@
+// Package tlsconfig builds the server TLS configuration of dns-proxy-api
+// from certificate files that can be reloaded while the server runs.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Client certificate policies
+const (
+	ClientAuthNone     = ""         // no client certificates requested
+	ClientAuthRequire  = "require"  // a certificate signed by the client CA is required
+	ClientAuthOptional = "optional" // verified if presented, other auth still possible
+)
+
+// Reloader serves a certificate and client CA pool loaded from files and
+// swaps them atomically on Reload, so renewed certificates are picked up
+// without dropping connections.
+type Reloader struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string // enables client certificate verification
+	ClientAuth   string // ClientAuthRequire (default with a CA) or ClientAuthOptional
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+}
+
+// New loads the files and returns the reloader
+func New(certFile, keyFile, clientCAFile, clientAuth string) (*Reloader, error) {
+	switch clientAuth {
+	case ClientAuthNone, ClientAuthRequire, ClientAuthOptional:
+	default:
+		return nil, fmt.Errorf("unknown client auth mode %q, expected %q or %q", clientAuth, ClientAuthRequire, ClientAuthOptional)
+	}
+	if clientCAFile == "" && clientAuth != ClientAuthNone {
+		return nil, errors.New("client certificate verification needs a client CA file")
+	}
+	if clientCAFile != "" && clientAuth == ClientAuthNone {
+		clientAuth = ClientAuthRequire
+	}
+	r := &Reloader{CertFile: certFile, KeyFile: keyFile, ClientCAFile: clientCAFile, ClientAuth: clientAuth}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the certificate, key and client CA again. On error the
+// previously loaded ones stay in use.
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if r.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", r.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCA = pool
+	r.mu.Unlock()
+	return nil
+}
+
+// Config returns a server TLS configuration that always uses the most
+// recently loaded certificate and client CA
+func (r *Reloader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.getCertificate,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			if r.clientCA == nil {
+				// Use the base configuration
+				return nil, nil
+			}
+			cfg := &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: r.getCertificate,
+				NextProtos:     []string{"h2", "http/1.1"},
+				ClientCAs:      r.clientCA,
+				ClientAuth:     tls.RequireAndVerifyClientCert,
+			}
+			if r.ClientAuth == ClientAuthOptional {
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return cfg, nil
+		},
+	}
+}
+
+func (r *Reloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
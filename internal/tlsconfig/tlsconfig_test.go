@@ -0,0 +1,255 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// issue creates a certificate for cn signed by parent, or self-signed if
+// parent is nil, and returns it with its key
+func issue(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{cn},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// writePEM writes cert and, if key is set, key to dir and returns their paths
+func writePEM(t *testing.T, dir, name string, cert *x509.Certificate, key *ecdsa.PrivateKey) (certFile, keyFile string) {
+	t.Helper()
+	certFile = filepath.Join(dir, name+".pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if key != nil {
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyFile = filepath.Join(dir, name+".key")
+		if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+// handshake connects to a TLS server using cfg and returns the server
+// certificate's common name and the client certificates the server saw
+func handshake(t *testing.T, server *tls.Config, client *tls.Config) (string, []*x509.Certificate, error) {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	type result struct {
+		peers []*x509.Certificate
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer conn.Close()
+		tlsConn := conn.(*tls.Conn)
+		err = tlsConn.Handshake()
+		done <- result{tlsConn.ConnectionState().PeerCertificates, err}
+		// Keep the connection open until the client is done with it
+		conn.Read(make([]byte, 1))
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), client)
+	cn := ""
+	if err == nil {
+		cn = conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+		// TLS 1.3 reports a rejected client certificate on the first read
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, err = conn.Read(make([]byte, 1))
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			err = nil
+		}
+		conn.Close()
+	}
+	res := <-done
+	if err == nil {
+		err = res.err
+	}
+	return cn, res.peers, err
+}
+
+func TestClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := issue(t, "Test CA", nil, nil)
+	serverCert, serverKey := issue(t, "server", ca, caKey)
+	clientCert, clientKey := issue(t, "certbot", ca, caKey)
+	otherCA, otherKey := issue(t, "Other CA", nil, nil)
+	strangerCert, strangerKey := issue(t, "stranger", otherCA, otherKey)
+
+	certFile, keyFile := writePEM(t, dir, "server", serverCert, serverKey)
+	caFile, _ := writePEM(t, dir, "ca", ca, nil)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	withCert := func(cert *x509.Certificate, key *ecdsa.PrivateKey) *tls.Config {
+		return &tls.Config{RootCAs: roots, ServerName: "server", Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}}
+	}
+	noCert := &tls.Config{RootCAs: roots, ServerName: "server"}
+
+	tests := []struct {
+		clientAuth string
+		client     *tls.Config
+		ok         bool
+		peer       string
+	}{
+		{ClientAuthNone, noCert, true, ""},
+		{ClientAuthRequire, noCert, false, ""},
+		{ClientAuthRequire, withCert(clientCert, clientKey), true, "certbot"},
+		{ClientAuthRequire, withCert(strangerCert, strangerKey), false, ""},
+		{ClientAuthOptional, noCert, true, ""},
+		{ClientAuthOptional, withCert(clientCert, clientKey), true, "certbot"},
+		// The client only offers certificates from the CAs the server names
+		{ClientAuthOptional, withCert(strangerCert, strangerKey), true, ""},
+	}
+	for _, tt := range tests {
+		clientCA := caFile
+		if tt.clientAuth == ClientAuthNone {
+			clientCA = ""
+		}
+		r, err := New(certFile, keyFile, clientCA, tt.clientAuth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cn, peers, err := handshake(t, r.Config(), tt.client)
+		if (err == nil) != tt.ok {
+			t.Errorf("client_auth=%q, client certs %d: err = %v, want ok=%v", tt.clientAuth, len(tt.client.Certificates), err, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		if cn != "server" {
+			t.Errorf("server presented %q", cn)
+		}
+		peer := ""
+		if len(peers) > 0 {
+			peer = peers[0].Subject.CommonName
+		}
+		if peer != tt.peer {
+			t.Errorf("client_auth=%q: server saw client %q, want %q", tt.clientAuth, peer, tt.peer)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := issue(t, "Test CA", nil, nil)
+	first, firstKey := issue(t, "first", ca, caKey)
+	certFile, keyFile := writePEM(t, dir, "server", first, firstKey)
+
+	r, err := New(certFile, keyFile, "", ClientAuthNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	client := &tls.Config{RootCAs: roots, InsecureSkipVerify: true}
+
+	server := r.Config()
+	if cn, _, err := handshake(t, server, client); err != nil || cn != "first" {
+		t.Fatalf("before reload: %q, %v", cn, err)
+	}
+
+	second, secondKey := issue(t, "second", ca, caKey)
+	writePEM(t, dir, "server", second, secondKey)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	// A config handed out before the reload serves the new certificate
+	if cn, _, err := handshake(t, server, client); err != nil || cn != "second" {
+		t.Errorf("after reload: %q, %v", cn, err)
+	}
+
+	// A broken file keeps the loaded certificate in use
+	if err := os.WriteFile(certFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Error("Reload of a broken certificate succeeded")
+	}
+	if cn, _, err := handshake(t, server, client); err != nil || cn != "second" {
+		t.Errorf("after a failed reload: %q, %v", cn, err)
+	}
+}
+
+func TestNewRejects(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := issue(t, "Test CA", nil, nil)
+	cert, key := issue(t, "server", ca, caKey)
+	certFile, keyFile := writePEM(t, dir, "server", cert, key)
+	notPEM := filepath.Join(dir, "empty.pem")
+	os.WriteFile(notPEM, []byte("not a certificate"), 0600)
+
+	tests := []struct {
+		certFile, keyFile, caFile, clientAuth string
+		want                                  string
+	}{
+		{certFile, keyFile, "", "sometimes", "unknown client auth mode"},
+		{certFile, keyFile, "", ClientAuthOptional, "needs a client CA file"},
+		{certFile, filepath.Join(dir, "missing.key"), "", "", "failed to load certificate"},
+		{certFile, keyFile, filepath.Join(dir, "missing.pem"), "", "failed to read client CA"},
+		{certFile, keyFile, notPEM, "", "no certificates found"},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.certFile, tt.keyFile, tt.caFile, tt.clientAuth); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("New(..., %q, %q) = %v, want %q", tt.caFile, tt.clientAuth, err, tt.want)
+		}
+	}
+
+	// A client CA alone means client certificates are required
+	r, err := New(certFile, keyFile, certFile, ClientAuthNone)
+	if err != nil || r.ClientAuth != ClientAuthRequire {
+		t.Errorf("New with a CA and no mode: %v, %v", r, err)
+	}
+}
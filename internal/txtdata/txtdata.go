@@ -0,0 +1,131 @@
+// Package txtdata converts TXT record values between their logical form (the
+// bytes a resolver hands to an application) and the quoted, chunked
+// presentation form cPanel stores in zone files.
+package txtdata
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxStringLen is the maximum length of a single DNS character-string
+const MaxStringLen = 255
+
+// Split breaks value into character-strings of at most MaxStringLen bytes.
+// Chunks end on rune boundaries so each one stays valid UTF-8.
+func Split(value string) []string {
+	if value == "" {
+		return []string{""}
+	}
+	var chunks []string
+	for len(value) > MaxStringLen {
+		cut := MaxStringLen
+		for cut > 0 && !utf8.RuneStart(value[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			cut = MaxStringLen
+		}
+		chunks = append(chunks, value[:cut])
+		value = value[cut:]
+	}
+	return append(chunks, value)
+}
+
+// Quote returns s as a quoted character-string with '"' and '\' escaped
+func Quote(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Encode returns the txtdata to send to cPanel for a logical value. Short
+// values without special characters are sent as-is; anything else is sent
+// as a space separated list of quoted character-strings.
+func Encode(value string) string {
+	if len(value) <= MaxStringLen && !strings.ContainsAny(value, "\"\\") {
+		return value
+	}
+	chunks := Split(value)
+	quoted := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		quoted[i] = Quote(chunk)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Decode returns the logical value of txtdata as returned by cPanel. Quoted
+// character-strings are unescaped and concatenated; values that are not in
+// quoted form are returned unchanged.
+func Decode(txtdata string) string {
+	trimmed := strings.TrimSpace(txtdata)
+	if !strings.HasPrefix(trimmed, `"`) {
+		return txtdata
+	}
+	chunks, ok := parseQuoted(trimmed)
+	if !ok {
+		return txtdata
+	}
+	return strings.Join(chunks, "")
+}
+
+// Match reports whether txtdata as stored by cPanel holds the logical value
+func Match(txtdata, value string) bool {
+	return txtdata == value || Decode(txtdata) == value
+}
+
+// parseQuoted parses a sequence of quoted character-strings separated by
+// whitespace. It reports false if s is not entirely in that form.
+func parseQuoted(s string) ([]string, bool) {
+	var chunks []string
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return chunks, true
+		}
+		if s[0] != '"' {
+			return nil, false
+		}
+		var b strings.Builder
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] != '\\' {
+				b.WriteByte(s[i])
+				continue
+			}
+			i++
+			if i >= len(s) {
+				return nil, false
+			}
+			// \DDD is a decimal byte value, anything else is taken literally
+			if i+2 < len(s) && isDigit(s[i]) && isDigit(s[i+1]) && isDigit(s[i+2]) {
+				n, _ := strconv.Atoi(s[i : i+3])
+				if n > 255 {
+					return nil, false
+				}
+				b.WriteByte(byte(n))
+				i += 2
+				continue
+			}
+			b.WriteByte(s[i])
+		}
+		if i >= len(s) {
+			return nil, false // unterminated string
+		}
+		chunks = append(chunks, b.String())
+		s = s[i+1:]
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
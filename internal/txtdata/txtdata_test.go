@@ -0,0 +1,147 @@
+package txtdata
+
+import (
+	"strings"
+	"testing"
+)
+
+// dkimKey is a real 2048-bit RSA DKIM record: 410 bytes, more than fits
+// in one character-string
+const dkimKey = "v=DKIM1; k=rsa; p=MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAsbTHob//Hor3P0+Qmo3DtCTmkXdn2PqxV3/qgV7BtucKCxq6V2fAyL43ALiCR2l9oJZsMtyqNyZw58fzuGb/Rve4VqhLeULUdFJGeW9hY+gSbenz4+mkrYQITUq3nnpJy0IzS3ZFw/bObrRTxK7S9Yh8QhPqHNhpcfW6fWu/P/obyp0fmQYMvTejA4TZqjATGjzwIkhD52YJrM+1kXmNdBPTP97Q0gYHZnB6BH/K6naEFF9O1bKNTm5s2kFJhfCaH6f0tdoMHZ/R1k6MoS01p4xAAJpEHdFNOEPGaevhfM25kt/JONlFnsHuP9DDR60PxU7lGHzG4JvLxTwh7AnTBwIDAQAB"
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		lens  []int
+	}{
+		{"empty", "", []int{0}},
+		{"short", "token", []int{5}},
+		{"exactly 255", strings.Repeat("a", 255), []int{255}},
+		{"256", strings.Repeat("a", 256), []int{255, 1}},
+		{"2048-bit DKIM key", dkimKey, []int{255, 155}},
+		{"rune boundary", strings.Repeat("a", 254) + "é" + "b", []int{254, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := Split(tt.value)
+			var lens []int
+			for _, c := range chunks {
+				lens = append(lens, len(c))
+			}
+			if !equalInts(lens, tt.lens) {
+				t.Errorf("chunk lengths %v, want %v", lens, tt.lens)
+			}
+			if strings.Join(chunks, "") != tt.value {
+				t.Error("chunks do not join to the value")
+			}
+		})
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"", `""`},
+		{"plain", `"plain"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\path`, `"C:\\path"`},
+	}
+	for _, tt := range tests {
+		if got := Quote(tt.in); got != tt.want {
+			t.Errorf("Quote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"short value as-is", "abc123", "abc123"},
+		{"spaces as-is", "v=spf1 -all", "v=spf1 -all"},
+		{"embedded quotes", `a "b" c`, `"a \"b\" c"`},
+		{"backslash", `a\b`, `"a\\b"`},
+		{"2048-bit DKIM key", dkimKey, `"` + dkimKey[:255] + `" "` + dkimKey[255:] + `"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Encode(tt.value); got != tt.want {
+				t.Errorf("Encode = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		txtdata string
+		want    string
+	}{
+		{"unquoted", "abc123", "abc123"},
+		{"unquoted with spaces", "v=spf1 -all", "v=spf1 -all"},
+		{"single quoted string", `"abc123"`, "abc123"},
+		{"escaped quotes and backslash", `"a \"b\" \\c"`, `a "b" \c`},
+		{"decimal escape", `"a\059b"`, "a;b"},
+		{"our chunks", Encode(dkimKey), dkimKey},
+		{"cPanel chunks", `"` + dkimKey[:200] + `" "` + dkimKey[200:] + `"`, dkimKey},
+		{"cPanel chunks with extra spaces", ` "` + dkimKey[:255] + `"  "` + dkimKey[255:] + `" `, dkimKey},
+		{"unterminated", `"abc`, `"abc`},
+		{"bad decimal escape", `"a\999"`, `"a\999"`},
+		{"text after quoted string", `"abc" def`, `"abc" def`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Decode(tt.txtdata); got != tt.want {
+				t.Errorf("Decode(%s) = %q, want %q", tt.txtdata, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		txtdata string
+		value   string
+		want    bool
+	}{
+		{"same plain value", "token", "token", true},
+		{"quoted form", `"token"`, "token", true},
+		{"chunked DKIM key", `"` + dkimKey[:255] + `" "` + dkimKey[255:] + `"`, dkimKey, true},
+		{"differently chunked DKIM key", `"` + dkimKey[:100] + `" "` + dkimKey[100:] + `"`, dkimKey, true},
+		{"escaped quotes", `"a \"b\""`, `a "b"`, true},
+		{"literal quoted value", `"token"`, `"token"`, true},
+		{"different value", `"token"`, "other", false},
+		{"truncated DKIM key", `"` + dkimKey[:255] + `"`, dkimKey, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Match(tt.txtdata, tt.value); got != tt.want {
+				t.Errorf("Match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, value := range []string{"", "token", `"`, `\`, `a "b" \c`, dkimKey, strings.Repeat("é", 300)} {
+		if got := Decode(Encode(value)); got != value {
+			t.Errorf("Decode(Encode(%q)) = %q", value, got)
+		}
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,161 @@
+// Package validate checks domain names, record owner names and TXT values
+// before they are sent to cPanel. Names are expected in A-label form.
+package validate
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// MaxNameLen is the maximum length of a domain name in presentation form
+	MaxNameLen = 253
+	// MaxLabelLen is the maximum length of a single label
+	MaxLabelLen = 63
+	// MaxTXTValueLen caps a TXT value. The DNS allows up to 65535 bytes of
+	// rdata but cPanel and UDP responses get unreliable far below that.
+	MaxTXTValueLen = 4096
+)
+
+// FieldError reports which input field is invalid and why
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+func fieldError(field, format string, args ...interface{}) error {
+	return &FieldError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// Required checks that value is not empty
+func Required(field, value string) error {
+	if value == "" {
+		return fieldError(field, "is required")
+	}
+	return nil
+}
+
+// Hostname checks that name is a fully qualified host or zone name made of
+// letters, digits and hyphens, such as "example.com" or "haos.example.com".
+func Hostname(field, name string) error {
+	labels, err := splitName(field, name)
+	if err != nil {
+		return err
+	}
+	if len(labels) < 2 {
+		return fieldError(field, "must be a fully qualified domain name like example.com")
+	}
+	for _, label := range labels {
+		if err := checkLabel(field, label, false); err != nil {
+			return err
+		}
+	}
+	if isNumeric(labels[len(labels)-1]) {
+		return fieldError(field, "top-level label %q must not be numeric", labels[len(labels)-1])
+	}
+	return nil
+}
+
+// OwnerName checks that name is a valid record owner name relative to a
+// zone. Unlike hostnames, owner names may contain underscore labels such as
+// "_acme-challenge" and may start with a "*" wildcard label.
+func OwnerName(field, name string) error {
+	labels, err := splitName(field, name)
+	if err != nil {
+		return err
+	}
+	for i, label := range labels {
+		if label == "*" {
+			if i != 0 {
+				return fieldError(field, "may only use \"*\" as the leftmost label")
+			}
+			continue
+		}
+		if err := checkLabel(field, label, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FQDN checks the length of the full name a record ends up with
+func FQDN(field, name string) error {
+	if len(name) > MaxNameLen {
+		return fieldError(field, "results in the name %q which is longer than %d bytes", name, MaxNameLen)
+	}
+	return nil
+}
+
+// TXTValue checks that value is a non-empty TXT value within the size limit
+func TXTValue(field, value string) error {
+	if value == "" {
+		return fieldError(field, "is required")
+	}
+	if len(value) > MaxTXTValueLen {
+		return fieldError(field, "is %d bytes long, the maximum is %d", len(value), MaxTXTValueLen)
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < 0x20 || value[i] == 0x7F {
+			return fieldError(field, "must not contain control characters (found 0x%02x at byte %d)", value[i], i)
+		}
+	}
+	return nil
+}
+
+// splitName checks the overall shape of name and returns its labels
+func splitName(field, name string) ([]string, error) {
+	if name == "" {
+		return nil, fieldError(field, "is required")
+	}
+	if strings.HasSuffix(name, ".") {
+		return nil, fieldError(field, "must not end with a dot")
+	}
+	if strings.HasPrefix(name, ".") {
+		return nil, fieldError(field, "must not start with a dot")
+	}
+	if len(name) > MaxNameLen {
+		return nil, fieldError(field, "is longer than %d bytes", MaxNameLen)
+	}
+	labels := strings.Split(name, ".")
+	for _, label := range labels {
+		if label == "" {
+			return nil, fieldError(field, "must not contain empty labels (\"..\")")
+		}
+	}
+	return labels, nil
+}
+
+// checkLabel checks a single LDH label, optionally allowing underscores
+func checkLabel(field, label string, underscore bool) error {
+	if len(label) > MaxLabelLen {
+		return fieldError(field, "label %q is %d bytes long, the maximum is %d", label, len(label), MaxLabelLen)
+	}
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-':
+		case c == '_' && underscore:
+		case c == '*':
+			return fieldError(field, "label %q must not contain \"*\" except as a whole wildcard label", label)
+		default:
+			return fieldError(field, "label %q contains invalid character %q", label, c)
+		}
+	}
+	if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+		return fieldError(field, "label %q must not start or end with a hyphen", label)
+	}
+	return nil
+}
+
+func isNumeric(label string) bool {
+	for i := 0; i < len(label); i++ {
+		if label[i] < '0' || label[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
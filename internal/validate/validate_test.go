@@ -0,0 +1,91 @@
+package validate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHostname(t *testing.T) {
+	for _, name := range []string{"example.com", "haos.example.com", "xn--bcher-kva.de", "a-b.c0.example"} {
+		if err := Hostname("domain", name); err != nil {
+			t.Errorf("Hostname(%q) = %v", name, err)
+		}
+	}
+	tests := []struct {
+		name, want string
+	}{
+		{"", "is required"},
+		{"com", "must be a fully qualified domain name"},
+		{"example.com.", "must not end with a dot"},
+		{".example.com", "must not start with a dot"},
+		{"a..example.com", "must not contain empty labels"},
+		{"_acme.example.com", "contains invalid character '_'"},
+		{"*.example.com", "must not contain \"*\""},
+		{"-a.example.com", "must not start or end with a hyphen"},
+		{"192.0.2.1", "top-level label \"1\" must not be numeric"},
+		{strings.Repeat("a", 64) + ".com", "is 64 bytes long, the maximum is 63"},
+		{strings.Repeat("a.", 127) + "com", "is longer than 253 bytes"},
+	}
+	for _, tt := range tests {
+		err := Hostname("domain", tt.name)
+		var fieldErr *FieldError
+		if !errors.As(err, &fieldErr) || fieldErr.Field != "domain" || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Hostname(%q) = %v, want a domain error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestOwnerName(t *testing.T) {
+	for _, name := range []string{"_acme-challenge", "_acme-challenge.sub", "*", "*.sub", "selector._domainkey", "www"} {
+		if err := OwnerName("key", name); err != nil {
+			t.Errorf("OwnerName(%q) = %v", name, err)
+		}
+	}
+	tests := []struct {
+		name, want string
+	}{
+		{"", "is required"},
+		{"sub.*", "may only use \"*\" as the leftmost label"},
+		{"a*b", "must not contain \"*\""},
+		{"_acme-challenge.", "must not end with a dot"},
+		{"bad name", "contains invalid character ' '"},
+		{"sub-", "must not start or end with a hyphen"},
+	}
+	for _, tt := range tests {
+		if err := OwnerName("key", tt.name); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("OwnerName(%q) = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestFQDN(t *testing.T) {
+	if err := FQDN("key", strings.Repeat("a", MaxNameLen)); err != nil {
+		t.Errorf("a name of %d bytes: %v", MaxNameLen, err)
+	}
+	if err := FQDN("key", strings.Repeat("a", MaxNameLen+1)); err == nil {
+		t.Errorf("a name of %d bytes was accepted", MaxNameLen+1)
+	}
+}
+
+func TestTXTValue(t *testing.T) {
+	for _, value := range []string{"token", "v=spf1 -all", `with "quotes"`, "ünïcode", strings.Repeat("a", MaxTXTValueLen)} {
+		if err := TXTValue("value", value); err != nil {
+			t.Errorf("TXTValue(%.20q) = %v", value, err)
+		}
+	}
+	tests := []struct {
+		value, want string
+	}{
+		{"", "is required"},
+		{strings.Repeat("a", MaxTXTValueLen+1), "the maximum is 4096"},
+		{"line\nbreak", "found 0x0a at byte 4"},
+		{"tab\t", "found 0x09 at byte 3"},
+		{"del\x7f", "found 0x7f at byte 3"},
+	}
+	for _, tt := range tests {
+		if err := TXTValue("value", tt.value); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("TXTValue(%.20q) = %v, want %q", tt.value, err, tt.want)
+		}
+	}
+}